@@ -4,7 +4,16 @@ CREATE TABLE IF NOT EXISTS devices (
     name VARCHAR(255),
     created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
-    last_poll_at TIMESTAMP NULL
+    last_poll_at TIMESTAMP NULL,
+    disabled BOOLEAN NOT NULL DEFAULT FALSE,
+    offline_since TIMESTAMP NULL,
+    battery_level INT NULL,
+    charging BOOLEAN NULL,
+    signal_strength INT NULL,
+    network_operator VARCHAR(100),
+    sim_slots TEXT,
+    app_version VARCHAR(50),
+    last_heartbeat_at TIMESTAMP NULL
 );
 
 CREATE INDEX idx_devices_device_key ON devices(device_key);
@@ -14,14 +23,34 @@ CREATE TABLE IF NOT EXISTS messages (
     topic VARCHAR(255) NOT NULL,
     to_number VARCHAR(20) NOT NULL,
     body TEXT NOT NULL,
+    encoding VARCHAR(10) NOT NULL DEFAULT 'gsm7',
+    segments INT NOT NULL DEFAULT 1,
+    characters INT NOT NULL DEFAULT 0,
     status VARCHAR(20) NOT NULL DEFAULT 'pending',
     created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
     sent_at TIMESTAMP NULL,
+    delivered_at TIMESTAMP NULL,
     failed_at TIMESTAMP NULL,
     failure_reason TEXT,
     assigned_device_id INT,
+    leased_until TIMESTAMP NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NULL,
+    avoid_device_id INT,
+    send_at TIMESTAMP NULL,
+    expires_at TIMESTAMP NULL,
+    expired_at TIMESTAMP NULL,
+    cancelled_at TIMESTAMP NULL,
+    priority VARCHAR(10) NOT NULL DEFAULT 'normal',
+    idempotency_key VARCHAR(255),
+    idempotency_owner INT NOT NULL DEFAULT 0,
+    callback_url VARCHAR(2048),
+    template_name VARCHAR(255),
+    template_version INT,
+    locale VARCHAR(20),
     CONSTRAINT fk_messages_device FOREIGN KEY (assigned_device_id) REFERENCES devices(id) ON DELETE SET NULL,
-    CONSTRAINT chk_status CHECK (status IN ('pending', 'sent', 'failed'))
+    CONSTRAINT chk_status CHECK (status IN ('pending', 'sent', 'delivered', 'undelivered', 'failed', 'expired', 'cancelled')),
+    CONSTRAINT chk_priority CHECK (priority IN ('high', 'normal', 'bulk'))
 );
 
 CREATE INDEX idx_messages_topic ON messages(topic);
@@ -30,6 +59,126 @@ CREATE INDEX idx_messages_to_number ON messages(to_number);
 CREATE INDEX idx_messages_created_at ON messages(created_at);
 CREATE INDEX idx_messages_assigned_device ON messages(assigned_device_id);
 CREATE INDEX idx_messages_topic_status ON messages(topic, status);
+CREATE INDEX idx_messages_leased_until ON messages(leased_until);
+CREATE INDEX idx_messages_next_attempt_at ON messages(next_attempt_at);
+CREATE INDEX idx_messages_send_at ON messages(send_at);
+CREATE INDEX idx_messages_template_name ON messages(template_name);
+CREATE INDEX idx_messages_locale ON messages(locale);
+CREATE INDEX idx_messages_expires_at ON messages(expires_at);
+CREATE INDEX idx_messages_priority ON messages(priority);
+CREATE UNIQUE INDEX idx_messages_idempotency_key_owner ON messages(idempotency_owner, idempotency_key);
+
+CREATE TABLE IF NOT EXISTS message_attempts (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    message_id VARCHAR(255) NOT NULL,
+    attempt INT NOT NULL,
+    device_id INT,
+    status VARCHAR(20) NOT NULL DEFAULT 'leased',
+    reason TEXT,
+    started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP NULL,
+    CONSTRAINT fk_message_attempts_message FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    CONSTRAINT chk_attempt_status CHECK (status IN ('leased', 'sent', 'failed', 'timed_out'))
+);
+
+CREATE INDEX idx_message_attempts_message_id ON message_attempts(message_id);
+CREATE INDEX idx_message_attempts_device_id ON message_attempts(device_id);
+
+CREATE TABLE IF NOT EXISTS message_events (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    message_id VARCHAR(255) NOT NULL,
+    topic VARCHAR(255) NOT NULL,
+    type VARCHAR(30) NOT NULL,
+    device_id INT,
+    detail TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fk_message_events_message FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_message_events_message_id ON message_events(message_id);
+CREATE INDEX idx_message_events_topic ON message_events(topic);
+CREATE INDEX idx_message_events_device_id ON message_events(device_id);
+CREATE INDEX idx_message_events_created_at ON message_events(created_at);
+
+CREATE TABLE IF NOT EXISTS inbound_messages (
+    id VARCHAR(255) PRIMARY KEY,
+    device_id INT,
+    from_number VARCHAR(20) NOT NULL,
+    body TEXT NOT NULL,
+    sim_slot INT,
+    topic VARCHAR(255),
+    reply_to_message_id VARCHAR(255),
+    opt_out BOOLEAN NOT NULL DEFAULT FALSE,
+    received_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fk_inbound_messages_device FOREIGN KEY (device_id) REFERENCES devices(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_inbound_messages_device_id ON inbound_messages(device_id);
+CREATE INDEX idx_inbound_messages_from_number ON inbound_messages(from_number);
+CREATE INDEX idx_inbound_messages_topic ON inbound_messages(topic);
+CREATE INDEX idx_inbound_messages_reply_to_message_id ON inbound_messages(reply_to_message_id);
+CREATE INDEX idx_inbound_messages_received_at ON inbound_messages(received_at);
+CREATE INDEX idx_inbound_messages_created_at ON inbound_messages(created_at);
+
+CREATE TABLE IF NOT EXISTS message_templates (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    topic VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    locale VARCHAR(20) NOT NULL DEFAULT '',
+    version INT NOT NULL,
+    body TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP NULL,
+    UNIQUE(topic, name, locale, version)
+);
+
+CREATE INDEX idx_message_templates_deleted_at ON message_templates(deleted_at);
+
+CREATE TABLE IF NOT EXISTS suppressions (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    phone_number VARCHAR(20) NOT NULL,
+    topic VARCHAR(255) NOT NULL DEFAULT '*',
+    reason VARCHAR(255) NOT NULL,
+    inbound_message_id VARCHAR(255),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(phone_number, topic)
+);
+
+CREATE INDEX idx_suppressions_topic ON suppressions(topic);
+
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    topic VARCHAR(255) NOT NULL,
+    url VARCHAR(2048) NOT NULL,
+    events VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_webhook_subscriptions_topic ON webhook_subscriptions(topic);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    subscription_id INT,
+    message_id VARCHAR(255),
+    event VARCHAR(50) NOT NULL,
+    url VARCHAR(2048) NOT NULL,
+    payload TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_status_code INT,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP NULL,
+    CONSTRAINT chk_webhook_delivery_status CHECK (status IN ('pending', 'delivered', 'dead'))
+);
+
+CREATE INDEX idx_webhook_deliveries_subscription_id ON webhook_deliveries(subscription_id);
+CREATE INDEX idx_webhook_deliveries_message_id ON webhook_deliveries(message_id);
+CREATE INDEX idx_webhook_deliveries_status ON webhook_deliveries(status);
+CREATE INDEX idx_webhook_deliveries_next_attempt_at ON webhook_deliveries(next_attempt_at);
+CREATE INDEX idx_webhook_deliveries_created_at ON webhook_deliveries(created_at);
 
 CREATE TABLE IF NOT EXISTS device_topics (
     id INT AUTO_INCREMENT PRIMARY KEY,
@@ -43,9 +192,51 @@ CREATE TABLE IF NOT EXISTS device_topics (
 CREATE INDEX idx_device_topics_device_id ON device_topics(device_id);
 CREATE INDEX idx_device_topics_topic ON device_topics(topic);
 
+CREATE TABLE IF NOT EXISTS api_keys (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    key_hash VARCHAR(64) NOT NULL UNIQUE,
+    scopes VARCHAR(255) NOT NULL,
+    topics TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP NULL,
+    revoked_at TIMESTAMP NULL
+);
+
+CREATE INDEX idx_api_keys_revoked_at ON api_keys(revoked_at);
+
+CREATE TABLE IF NOT EXISTS device_pairing_codes (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    code VARCHAR(16) NOT NULL UNIQUE,
+    name VARCHAR(255),
+    topics TEXT,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP NULL,
+    device_id INT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_device_pairing_codes_expires_at ON device_pairing_codes(expires_at);
+
+CREATE TABLE IF NOT EXISTS device_heartbeats (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    device_id INT NOT NULL,
+    battery_level INT NULL,
+    charging BOOLEAN NULL,
+    signal_strength INT NULL,
+    network_operator VARCHAR(100),
+    sim_slots TEXT,
+    app_version VARCHAR(50),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_device_heartbeats_device_id ON device_heartbeats(device_id);
+CREATE INDEX idx_device_heartbeats_created_at ON device_heartbeats(created_at);
+
 CREATE TABLE IF NOT EXISTS schema_migrations (
     version INT PRIMARY KEY,
     applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 
-INSERT INTO schema_migrations (version) VALUES (1);
+INSERT INTO schema_migrations (version) VALUES (1), (2), (3), (4), (5), (6), (7), (8), (9);
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ScopeSend   = "send"
+	ScopeRead   = "read"
+	ScopeReport = "report"
+	ScopeAdmin  = "admin"
+)
+
+var APIKeyScopes = []string{ScopeSend, ScopeRead, ScopeReport, ScopeAdmin}
+
+const apiKeyPrefix = "sgw_"
+
+// adminAPIKey stands in for the key configured through ADMIN_API_KEY, so a
+// fresh installation can create its first real keys.
+var adminAPIKey = APIKey{Name: "ADMIN_API_KEY", Scopes: ScopeAdmin}
+
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIKey returns the stored key together with its plaintext, which is
+// not kept and cannot be recovered later.
+func CreateAPIKey(name string, scopes, topics []string) (*APIKey, string, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+	plaintext := apiKeyPrefix + hex.EncodeToString(secret)
+
+	key := &APIKey{
+		Name:    name,
+		Prefix:  plaintext[:len(apiKeyPrefix)+8],
+		KeyHash: HashAPIKey(plaintext),
+		Scopes:  strings.Join(scopes, ","),
+		Topics:  strings.Join(topics, ","),
+	}
+
+	if err := DB.Create(key).Error; err != nil {
+		return nil, "", fmt.Errorf("failed to create api key: %w", err)
+	}
+
+	return key, plaintext, nil
+}
+
+func GetAPIKeys() ([]APIKey, error) {
+	var keys []APIKey
+	if err := DB.Order("id ASC").Find(&keys).Error; err != nil {
+		return nil, fmt.Errorf("failed to query api keys: %w", err)
+	}
+	return keys, nil
+}
+
+func RevokeAPIKey(id uint) error {
+	result := DB.Model(&APIKey{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now().UTC())
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke api key: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// AuthenticateAPIKey returns nil when the key is unknown or revoked.
+func AuthenticateAPIKey(plaintext string) (*APIKey, error) {
+	if admin := os.Getenv("ADMIN_API_KEY"); admin != "" &&
+		subtle.ConstantTimeCompare([]byte(admin), []byte(plaintext)) == 1 {
+		key := adminAPIKey
+		return &key, nil
+	}
+
+	var key APIKey
+	err := DB.Where("key_hash = ? AND revoked_at IS NULL", HashAPIKey(plaintext)).First(&key).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query api key: %w", err)
+	}
+
+	now := time.Now().UTC()
+	if err := DB.Model(&key).Update("last_used_at", now).Error; err != nil {
+		return nil, fmt.Errorf("failed to update api key: %w", err)
+	}
+
+	return &key, nil
+}
+
+func (k APIKey) ScopeList() []string {
+	return splitList(k.Scopes)
+}
+
+// TopicList returns nil when the key may use every topic.
+func (k APIKey) TopicList() []string {
+	return splitList(k.Topics)
+}
+
+// HasScope reports whether the key grants scope. Admin keys hold every scope.
+func (k APIKey) HasScope(scope string) bool {
+	scopes := k.ScopeList()
+	return slices.Contains(scopes, scope) || slices.Contains(scopes, ScopeAdmin)
+}
+
+func (k APIKey) AllowsTopic(topic string) bool {
+	topics := k.TopicList()
+	return len(topics) == 0 || slices.Contains(topics, topic)
+}
+
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
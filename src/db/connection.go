@@ -58,7 +58,8 @@ func ConnectWithConfig(config Config) error {
 	var err error
 
 	gormConfig := &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Silent),
+		Logger:         logger.Default.LogMode(logger.Silent),
+		TranslateError: true,
 	}
 
 	if config.Driver == "sqlite" {
@@ -87,8 +88,14 @@ func ConnectWithConfig(config Config) error {
 		return fmt.Errorf("failed to get sql.DB: %w", err)
 	}
 
-	sqlDB.SetMaxOpenConns(25)
-	sqlDB.SetMaxIdleConns(5)
+	if config.Driver == "sqlite" {
+		// A single connection serializes writes (message claims rely on
+		// this) and keeps every query on the same in-memory database.
+		sqlDB.SetMaxOpenConns(1)
+	} else {
+		sqlDB.SetMaxOpenConns(25)
+		sqlDB.SetMaxIdleConns(5)
+	}
 
 	currentDriver = config.Driver
 
@@ -1,12 +1,34 @@
 package db
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"math/big"
+	"os"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+var ErrInvalidPairingCode = errors.New("invalid or expired pairing code")
+
+const pairingCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+const pairingCodeLength = 8
+
+// DeviceAutoRegisterEnabled restores the old behaviour of creating a device
+// for any unknown X-Device-Key. It is meant for development only.
+func DeviceAutoRegisterEnabled() bool {
+	return os.Getenv("DEVICE_AUTO_REGISTER") == "true"
+}
+
+func getPairingCodeTTL() time.Duration {
+	return time.Duration(getEnvInt("DEVICE_PAIRING_CODE_TTL_MINUTES", 15)) * time.Minute
+}
+
 func GetDeviceByKey(deviceKey string) (*Device, error) {
 	var device Device
 	err := DB.Where("device_key = ?", deviceKey).First(&device).Error
@@ -32,18 +54,42 @@ func CreateDevice(deviceKey string, name *string) (*Device, error) {
 	return device, nil
 }
 
+// UpdateDeviceLastPoll records a poll and, when the monitor had marked the
+// device offline, brings it back online.
 func UpdateDeviceLastPoll(deviceID uint) error {
-	now := time.Now().UTC()
-	err := DB.Model(&Device{}).Where("id = ?", deviceID).Update("last_poll_at", now).Error
-	if err != nil {
-		return fmt.Errorf("failed to update device last poll: %w", err)
-	}
-	return nil
+	return DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now().UTC()
+		err := tx.Model(&Device{}).Where("id = ?", deviceID).Update("last_poll_at", now).Error
+		if err != nil {
+			return fmt.Errorf("failed to update device last poll: %w", err)
+		}
+
+		result := tx.Model(&Device{}).
+			Where("id = ? AND offline_since IS NOT NULL", deviceID).
+			Update("offline_since", nil)
+		if result.Error != nil {
+			return fmt.Errorf("failed to mark device online: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		var device Device
+		if err := tx.First(&device, deviceID).Error; err != nil {
+			return fmt.Errorf("failed to get device: %w", err)
+		}
+
+		return enqueueDeviceWebhooks(tx, device, WebhookDeviceOnline, 0)
+	})
 }
 
 func GetDeviceTopics(deviceID uint) ([]string, error) {
+	return getDeviceTopics(DB, deviceID)
+}
+
+func getDeviceTopics(tx *gorm.DB, deviceID uint) ([]string, error) {
 	var deviceTopics []DeviceTopic
-	err := DB.Where("device_id = ?", deviceID).Order("topic").Find(&deviceTopics).Error
+	err := tx.Where("device_id = ?", deviceID).Order("topic").Find(&deviceTopics).Error
 	if err != nil {
 		return nil, fmt.Errorf("failed to query device topics: %w", err)
 	}
@@ -58,24 +104,451 @@ func GetDeviceTopics(deviceID uint) ([]string, error) {
 
 func SetDeviceTopics(deviceID uint, topics []string) error {
 	return DB.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Where("device_id = ?", deviceID).Delete(&DeviceTopic{}).Error; err != nil {
-			return fmt.Errorf("failed to delete existing topics: %w", err)
+		return setDeviceTopics(tx, deviceID, topics)
+	})
+}
+
+func setDeviceTopics(tx *gorm.DB, deviceID uint, topics []string) error {
+	if err := tx.Where("device_id = ?", deviceID).Delete(&DeviceTopic{}).Error; err != nil {
+		return fmt.Errorf("failed to delete existing topics: %w", err)
+	}
+
+	for _, topic := range topics {
+		deviceTopic := DeviceTopic{
+			DeviceID: deviceID,
+			Topic:    topic,
+		}
+		if err := tx.Create(&deviceTopic).Error; err != nil {
+			return fmt.Errorf("failed to insert topic: %w", err)
+		}
+	}
+
+	if err := tx.Model(&Device{}).Where("id = ?", deviceID).Update("updated_at", time.Now().UTC()).Error; err != nil {
+		return fmt.Errorf("failed to update device timestamp: %w", err)
+	}
+
+	return nil
+}
+
+func generateDeviceKey() (string, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate device key: %w", err)
+	}
+	return "dev_" + hex.EncodeToString(secret), nil
+}
+
+// EnrollDevice creates a device with a generated key and its topics.
+func EnrollDevice(name *string, topics []string) (*Device, error) {
+	deviceKey, err := generateDeviceKey()
+	if err != nil {
+		return nil, err
+	}
+
+	device := &Device{
+		DeviceKey: deviceKey,
+		Name:      name,
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		return createDeviceWithTopics(tx, device, topics)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return device, nil
+}
+
+func CreatePairingCode(name *string, topics []string) (*DevicePairingCode, error) {
+	code := make([]byte, pairingCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(pairingCodeAlphabet))))
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate pairing code: %w", err)
+		}
+		code[i] = pairingCodeAlphabet[n.Int64()]
+	}
+
+	pairingCode := &DevicePairingCode{
+		Code:      string(code),
+		Name:      name,
+		Topics:    strings.Join(topics, ","),
+		ExpiresAt: time.Now().UTC().Add(getPairingCodeTTL()),
+	}
+
+	if err := DB.Create(pairingCode).Error; err != nil {
+		return nil, fmt.Errorf("failed to create pairing code: %w", err)
+	}
+
+	return pairingCode, nil
+}
+
+// RedeemPairingCode exchanges a one-time code for a new device. Codes are
+// matched case-insensitively and may contain spaces or dashes. A name sent
+// by the phone is only used when the admin did not set one.
+func RedeemPairingCode(code string, name *string) (*Device, error) {
+	code = strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(code))
+
+	deviceKey, err := generateDeviceKey()
+	if err != nil {
+		return nil, err
+	}
+
+	var device *Device
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var pairingCode DevicePairingCode
+		err := tx.Where("code = ? AND used_at IS NULL AND expires_at > ?", code, time.Now().UTC()).
+			First(&pairingCode).Error
+		if err == gorm.ErrRecordNotFound {
+			return ErrInvalidPairingCode
+		}
+		if err != nil {
+			return fmt.Errorf("failed to query pairing code: %w", err)
+		}
+
+		// Claim the code before creating the device so that two phones
+		// redeeming it at the same time cannot both succeed.
+		result := tx.Model(&DevicePairingCode{}).
+			Where("id = ? AND used_at IS NULL", pairingCode.ID).
+			Update("used_at", time.Now().UTC())
+		if result.Error != nil {
+			return fmt.Errorf("failed to redeem pairing code: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidPairingCode
+		}
+
+		if pairingCode.Name != nil {
+			name = pairingCode.Name
+		}
+
+		device = &Device{
+			DeviceKey: deviceKey,
+			Name:      name,
+		}
+		if err := createDeviceWithTopics(tx, device, splitList(pairingCode.Topics)); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&pairingCode).Update("device_id", device.ID).Error; err != nil {
+			return fmt.Errorf("failed to link pairing code: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return device, nil
+}
+
+func createDeviceWithTopics(tx *gorm.DB, device *Device, topics []string) error {
+	if err := tx.Create(device).Error; err != nil {
+		return fmt.Errorf("failed to create device: %w", err)
+	}
+
+	for _, topic := range topics {
+		deviceTopic := DeviceTopic{
+			DeviceID: device.ID,
+			Topic:    topic,
+		}
+		if err := tx.Create(&deviceTopic).Error; err != nil {
+			return fmt.Errorf("failed to insert topic: %w", err)
+		}
+	}
+
+	return nil
+}
+
+type DeviceChanges struct {
+	Name     *string
+	Disabled *bool
+	Topics   []string
+}
+
+// GetDeviceOfflineAfter is how long a device may go without polling before
+// it is reported as offline.
+func GetDeviceOfflineAfter() time.Duration {
+	return time.Duration(getEnvInt("DEVICE_OFFLINE_AFTER_SECONDS", 300)) * time.Second
+}
+
+func (d Device) IsOnline() bool {
+	return !d.Disabled && d.OfflineSince == nil && d.LastPollAt != nil &&
+		time.Since(*d.LastPollAt) <= GetDeviceOfflineAfter()
+}
+
+func (d Device) TopicList() []string {
+	topics := make([]string, len(d.Topics))
+	for i, dt := range d.Topics {
+		topics[i] = dt.Topic
+	}
+	return topics
+}
+
+func GetDevices() ([]Device, error) {
+	var devices []Device
+	err := DB.Preload("Topics", func(db *gorm.DB) *gorm.DB {
+		return db.Order("topic")
+	}).Order("id ASC").Find(&devices).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query devices: %w", err)
+	}
+	return devices, nil
+}
+
+func GetDeviceByID(id uint) (*Device, error) {
+	var device Device
+	err := DB.Preload("Topics", func(db *gorm.DB) *gorm.DB {
+		return db.Order("topic")
+	}).First(&device, id).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device: %w", err)
+	}
+	return &device, nil
+}
+
+// CountInFlightMessages returns, per device, the number of messages it has
+// leased and not yet reported on.
+func CountInFlightMessages() (map[uint]int, error) {
+	var rows []struct {
+		AssignedDeviceID uint
+		Count            int
+	}
+	err := DB.Model(&Message{}).
+		Select("assigned_device_id, COUNT(*) AS count").
+		Where("status = ? AND assigned_device_id IS NOT NULL AND leased_until > ?", "pending", time.Now().UTC()).
+		Group("assigned_device_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count in-flight messages: %w", err)
+	}
+
+	counts := make(map[uint]int, len(rows))
+	for _, row := range rows {
+		counts[row.AssignedDeviceID] = row.Count
+	}
+	return counts, nil
+}
+
+// UpdateDevice applies changes and returns gorm.ErrRecordNotFound for an
+// unknown device. Disabling a device releases its leases so other devices
+// can pick the messages up right away.
+func UpdateDevice(id uint, changes DeviceChanges) (*Device, error) {
+	var released []Message
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var device Device
+		if err := tx.First(&device, id).Error; err != nil {
+			return err
 		}
 
-		for _, topic := range topics {
-			deviceTopic := DeviceTopic{
-				DeviceID: deviceID,
-				Topic:    topic,
+		updates := map[string]interface{}{}
+		if changes.Name != nil {
+			if *changes.Name == "" {
+				updates["name"] = nil
+			} else {
+				updates["name"] = *changes.Name
 			}
-			if err := tx.Create(&deviceTopic).Error; err != nil {
-				return fmt.Errorf("failed to insert topic: %w", err)
+		}
+		if changes.Disabled != nil {
+			updates["disabled"] = *changes.Disabled
+		}
+
+		if changes.Topics != nil {
+			if err := setDeviceTopics(tx, id, changes.Topics); err != nil {
+				return err
 			}
 		}
 
-		if err := tx.Model(&Device{}).Where("id = ?", deviceID).Update("updated_at", time.Now().UTC()).Error; err != nil {
-			return fmt.Errorf("failed to update device timestamp: %w", err)
+		if len(updates) == 0 {
+			return nil
+		}
+
+		if err := tx.Model(&device).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update device: %w", err)
+		}
+
+		if changes.Disabled != nil && *changes.Disabled {
+			var err error
+			released, err = releaseDeviceLeases(tx, id, "device disabled")
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	notifyMessageWaiters(released)
+
+	return GetDeviceByID(id)
+}
+
+func DeleteDevice(id uint) error {
+	var released []Message
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		released, err = releaseDeviceLeases(tx, id, "device deleted")
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Where("device_id = ?", id).Delete(&DeviceTopic{}).Error; err != nil {
+			return fmt.Errorf("failed to delete device topics: %w", err)
+		}
+
+		if err := tx.Where("device_id = ?", id).Delete(&DeviceHeartbeat{}).Error; err != nil {
+			return fmt.Errorf("failed to delete device heartbeats: %w", err)
+		}
+
+		// Received messages are kept as history without their device.
+		if err := tx.Model(&InboundMessage{}).Where("device_id = ?", id).Update("device_id", nil).Error; err != nil {
+			return fmt.Errorf("failed to detach inbound messages: %w", err)
+		}
+
+		result := tx.Delete(&Device{}, id)
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete device: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
 		}
 
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	notifyMessageWaiters(released)
+	return nil
+}
+
+// RotateDeviceKey replaces the device key. The old key stops working
+// immediately.
+func RotateDeviceKey(id uint) (*Device, error) {
+	deviceKey, err := generateDeviceKey()
+	if err != nil {
+		return nil, err
+	}
+
+	result := DB.Model(&Device{}).Where("id = ?", id).Update("device_key", deviceKey)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to rotate device key: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return GetDeviceByID(id)
+}
+
+// releaseDeviceLeases unassigns the messages a device has leased and not
+// reported on, so any other device on the topic can claim them right away.
+// The released attempts do not count against the retry policy.
+func releaseDeviceLeases(tx *gorm.DB, deviceID uint, reason string) ([]Message, error) {
+	now := time.Now().UTC()
+
+	var messages []Message
+	err := tx.Where("status = ? AND assigned_device_id = ? AND leased_until > ?", "pending", deviceID, now).
+		Find(&messages).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query device leases: %w", err)
+	}
+
+	if len(messages) == 0 {
+		return nil, nil
+	}
+
+	messageIDs := make([]string, len(messages))
+	for i, msg := range messages {
+		messageIDs[i] = msg.ID
+	}
+
+	err = tx.Model(&Message{}).
+		Where("id IN ?", messageIDs).
+		Updates(map[string]interface{}{
+			"assigned_device_id": nil,
+			"leased_until":       nil,
+			"attempts":           gorm.Expr("attempts - 1"),
+		}).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to release device leases: %w", err)
+	}
+
+	err = tx.Model(&MessageAttempt{}).
+		Where("message_id IN ? AND device_id = ? AND status = ?", messageIDs, deviceID, "leased").
+		Updates(map[string]interface{}{
+			"status":      "timed_out",
+			"reason":      reason,
+			"finished_at": now,
+		}).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to close released attempts: %w", err)
+	}
+
+	if err := recordMessageEvents(tx, messages, EventReleased, &deviceID, &reason); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// MarkOfflineDevices flags enabled devices that have not polled within
+// GetDeviceOfflineAfter, releases their leased messages and notifies
+// webhook subscribers. Devices that never polled are left alone.
+func MarkOfflineDevices() ([]Device, error) {
+	cutoff := time.Now().UTC().Add(-GetDeviceOfflineAfter())
+
+	var candidates []Device
+	err := DB.Where("disabled = ? AND offline_since IS NULL AND last_poll_at < ?", false, cutoff).
+		Order("id ASC").Find(&candidates).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query silent devices: %w", err)
+	}
+
+	var marked []Device
+	for _, device := range candidates {
+		var released []Message
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			now := time.Now().UTC()
+			// Re-check the poll time so a device that polled since the
+			// query above is not marked offline.
+			result := tx.Model(&Device{}).
+				Where("id = ? AND offline_since IS NULL AND last_poll_at < ?", device.ID, cutoff).
+				Update("offline_since", now)
+			if result.Error != nil {
+				return fmt.Errorf("failed to mark device offline: %w", result.Error)
+			}
+			if result.RowsAffected == 0 {
+				return nil
+			}
+			device.OfflineSince = &now
+
+			var err error
+			released, err = releaseDeviceLeases(tx, device.ID, "device offline")
+			if err != nil {
+				return err
+			}
+
+			if err := enqueueDeviceWebhooks(tx, device, WebhookDeviceOffline, len(released)); err != nil {
+				return err
+			}
+
+			marked = append(marked, device)
+			return nil
+		})
+		if err != nil {
+			return marked, err
+		}
+
+		notifyMessageWaiters(released)
+	}
+
+	return marked, nil
 }
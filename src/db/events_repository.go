@@ -0,0 +1,127 @@
+package db
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+const (
+	EventQueued         = "queued"
+	EventUpdated        = "updated"
+	EventLeased         = "leased"
+	EventReleased       = "released"
+	EventSent           = "sent"
+	EventDelivered      = "delivered"
+	EventUndelivered    = "undelivered"
+	EventFailed         = "failed"
+	EventRetryScheduled = "retry_scheduled"
+	EventExpired        = "expired"
+	EventCancelled      = "cancelled"
+)
+
+// StatusEventTypes are the events that queue a message or change its status.
+var StatusEventTypes = []string{
+	EventQueued,
+	EventSent,
+	EventDelivered,
+	EventUndelivered,
+	EventFailed,
+	EventRetryScheduled,
+	EventExpired,
+	EventCancelled,
+}
+
+type MessageEventFilters struct {
+	AfterID    uint
+	Topic      string
+	Topics     []string
+	MessageIDs []string
+	Types      []string
+	Limit      int
+}
+
+func recordMessageEvents(tx *gorm.DB, messages []Message, eventType string, deviceID *uint, detail *string) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	events := make([]MessageEvent, len(messages))
+	for i, msg := range messages {
+		events[i] = MessageEvent{
+			MessageID: msg.ID,
+			Topic:     msg.Topic,
+			Type:      eventType,
+			DeviceID:  deviceID,
+			Detail:    detail,
+		}
+	}
+
+	if err := tx.Create(&events).Error; err != nil {
+		return fmt.Errorf("failed to record message events: %w", err)
+	}
+
+	return nil
+}
+
+func recordMessageEvent(tx *gorm.DB, message Message, eventType string, deviceID *uint, detail *string) error {
+	return recordMessageEvents(tx, []Message{message}, eventType, deviceID, detail)
+}
+
+func GetMessageWithHistory(messageID string) (*Message, error) {
+	var message Message
+	err := DB.Preload("AssignedDevice").
+		Preload("AttemptHistory", func(tx *gorm.DB) *gorm.DB {
+			return tx.Order("attempt ASC, id ASC")
+		}).
+		Preload("Events", func(tx *gorm.DB) *gorm.DB {
+			return tx.Order("id ASC")
+		}).
+		Where("id = ?", messageID).
+		First(&message).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+	return &message, nil
+}
+
+// GetMessageEvents returns the events recorded after AfterID in id order, so
+// the last id read can be used to resume.
+func GetMessageEvents(filters MessageEventFilters) ([]MessageEvent, error) {
+	query := DB.Where("id > ?", filters.AfterID)
+
+	if filters.Topic != "" {
+		query = query.Where("topic = ?", filters.Topic)
+	}
+
+	if len(filters.Topics) > 0 {
+		query = query.Where("topic IN ?", filters.Topics)
+	}
+
+	if len(filters.MessageIDs) > 0 {
+		query = query.Where("message_id IN ?", filters.MessageIDs)
+	}
+
+	if len(filters.Types) > 0 {
+		query = query.Where("type IN ?", filters.Types)
+	}
+
+	var events []MessageEvent
+	err := query.Order("id ASC").Limit(filters.Limit).Find(&events).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message events: %w", err)
+	}
+	return events, nil
+}
+
+func GetLatestMessageEventID() (uint, error) {
+	var id uint
+	err := DB.Model(&MessageEvent{}).Select("COALESCE(MAX(id), 0)").Scan(&id).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to get latest message event: %w", err)
+	}
+	return id, nil
+}
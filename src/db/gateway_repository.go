@@ -1,100 +1,344 @@
 package db
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"slices"
+	"strconv"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// PollBatchSize is the most messages a device is handed by one poll.
+const PollBatchSize = 10
+
 type PollMessage struct {
-	ID       string
-	ToNumber string
-	Body     string
+	ID          string
+	ToNumber    string
+	Body        string
+	LeasedUntil time.Time
+}
+
+func getLeaseTimeout() time.Duration {
+	secondsStr := os.Getenv("MESSAGE_LEASE_SECONDS")
+	if secondsStr == "" {
+		return 300 * time.Second
+	}
+
+	seconds, err := strconv.Atoi(secondsStr)
+	if err != nil || seconds <= 0 {
+		return 300 * time.Second
+	}
+
+	return time.Duration(seconds) * time.Second
 }
 
-func GetPendingMessagesForDevice(deviceID uint, topics []string) ([]PollMessage, error) {
-	if len(topics) == 0 {
+func getPriorityAging() time.Duration {
+	secondsStr := os.Getenv("PRIORITY_AGING_SECONDS")
+	if secondsStr == "" {
+		return 600 * time.Second
+	}
+
+	seconds, err := strconv.Atoi(secondsStr)
+	if err != nil || seconds <= 0 {
+		return 600 * time.Second
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// Messages waiting longer than the aging window move up one lane, so bulk
+// and normal traffic keeps progressing while high priority is always served
+// first. Waiting time counts from send_at for scheduled messages.
+func priorityOrder(now time.Time) clause.OrderBy {
+	agedBefore := now.Add(-getPriorityAging())
+	return clause.OrderBy{
+		Expression: clause.Expr{
+			SQL: `CASE
+				WHEN priority = 'high' THEN 0
+				WHEN priority = 'normal' AND COALESCE(send_at, created_at) <= ? THEN 1
+				WHEN priority = 'normal' OR COALESCE(send_at, created_at) <= ? THEN 2
+				ELSE 3
+			END, COALESCE(send_at, created_at) ASC`,
+			Vars:               []interface{}{agedBefore, agedBefore},
+			WithoutParentheses: true,
+		},
+	}
+}
+
+// GetPendingMessagesForDevice leases up to limit pending messages for topics
+// to the device.
+func GetPendingMessagesForDevice(deviceID uint, topics []string, limit int) ([]PollMessage, error) {
+	if len(topics) == 0 || limit <= 0 {
 		return []PollMessage{}, nil
 	}
 
+	now := time.Now().UTC()
+	leasedUntil := now.Add(getLeaseTimeout())
+
 	var messages []Message
-	err := DB.Where("status = ?", "pending").
-		Where("topic IN ?", topics).
-		Where("assigned_device_id IS NULL OR assigned_device_id = ?", deviceID).
-		Order("created_at ASC").
-		Limit(10).
-		Find(&messages).Error
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		query := tx.Where("status = ?", "pending").
+			Where("topic IN ?", topics).
+			Where("leased_until IS NULL OR leased_until <= ?", now).
+			Where("send_at IS NULL OR send_at <= ?", now).
+			Where("expires_at IS NULL OR expires_at > ?", now).
+			Where("next_attempt_at IS NULL OR next_attempt_at <= ?", now).
+			Where("avoid_device_id IS NULL OR avoid_device_id <> ? OR next_attempt_at <= ?", deviceID, now.Add(-getLeaseTimeout())).
+			Order(priorityOrder(now)).
+			Limit(limit)
+
+		// SQLite serializes writers on a single connection, so the
+		// transaction alone makes the claim atomic there.
+		if !IsSQLite() {
+			query = query.Clauses(clause.Locking{
+				Strength: clause.LockingStrengthUpdate,
+				Options:  clause.LockingOptionsSkipLocked,
+			})
+		}
+
+		if err := query.Find(&messages).Error; err != nil {
+			return fmt.Errorf("failed to query pending messages: %w", err)
+		}
+
+		if len(messages) == 0 {
+			return nil
+		}
+
+		if err := leaseMessagesToDevice(tx, deviceID, messages, leasedUntil); err != nil {
+			return fmt.Errorf("failed to assign messages to device: %w", err)
+		}
+
+		return nil
+	})
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to query pending messages: %w", err)
+		return nil, err
 	}
 
 	pollMessages := make([]PollMessage, len(messages))
 	for i, msg := range messages {
 		pollMessages[i] = PollMessage{
-			ID:       msg.ID,
-			ToNumber: msg.ToNumber,
-			Body:     msg.Body,
+			ID:          msg.ID,
+			ToNumber:    msg.ToNumber,
+			Body:        msg.Body,
+			LeasedUntil: leasedUntil,
 		}
 	}
 
-	if len(pollMessages) > 0 {
-		if err := assignMessagesToDevice(deviceID, pollMessages); err != nil {
-			return nil, fmt.Errorf("failed to assign messages to device: %w", err)
+	return pollMessages, nil
+}
+
+// GetLeasedMessagesForDevice returns the messages still leased to the device
+// without a reported status, so a reconnecting device can pick up where it
+// left off instead of waiting for the leases to expire.
+func GetLeasedMessagesForDevice(deviceID uint) ([]PollMessage, error) {
+	var messages []Message
+	err := DB.Where("status = ?", "pending").
+		Where("assigned_device_id = ?", deviceID).
+		Where("leased_until > ?", time.Now().UTC()).
+		Order("created_at ASC").
+		Find(&messages).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query leased messages: %w", err)
+	}
+
+	pollMessages := make([]PollMessage, len(messages))
+	for i, msg := range messages {
+		pollMessages[i] = PollMessage{
+			ID:          msg.ID,
+			ToNumber:    msg.ToNumber,
+			Body:        msg.Body,
+			LeasedUntil: *msg.LeasedUntil,
 		}
 	}
 
 	return pollMessages, nil
 }
 
-func assignMessagesToDevice(deviceID uint, messages []PollMessage) error {
-	if len(messages) == 0 {
-		return nil
-	}
-
+func leaseMessagesToDevice(tx *gorm.DB, deviceID uint, messages []Message, leasedUntil time.Time) error {
 	messageIDs := make([]string, len(messages))
 	for i, msg := range messages {
 		messageIDs[i] = msg.ID
 	}
 
-	err := DB.Model(&Message{}).
-		Where("id IN ?", messageIDs).
-		Where("assigned_device_id IS NULL").
-		Update("assigned_device_id", deviceID).Error
+	now := time.Now().UTC()
+	err := tx.Model(&MessageAttempt{}).
+		Where("message_id IN ?", messageIDs).
+		Where("status = ?", "leased").
+		Updates(map[string]interface{}{
+			"status":      "timed_out",
+			"finished_at": now,
+		}).Error
+	if err != nil {
+		return fmt.Errorf("failed to close expired attempts: %w", err)
+	}
 
+	err = tx.Model(&Message{}).
+		Where("id IN ?", messageIDs).
+		Updates(map[string]interface{}{
+			"assigned_device_id": deviceID,
+			"leased_until":       leasedUntil,
+			"attempts":           gorm.Expr("attempts + 1"),
+		}).Error
 	if err != nil {
-		return fmt.Errorf("failed to update message assignments: %w", err)
+		return fmt.Errorf("failed to update message leases: %w", err)
 	}
 
-	return nil
-}
+	attempts := make([]MessageAttempt, len(messages))
+	for i, msg := range messages {
+		attempts[i] = MessageAttempt{
+			MessageID: msg.ID,
+			Attempt:   msg.Attempts + 1,
+			DeviceID:  &deviceID,
+			Status:    "leased",
+		}
+	}
 
-func UpdateMessageStatus(messageID string, status string, reason *string) error {
-	if status != "sent" && status != "failed" {
-		return fmt.Errorf("invalid status: must be 'sent' or 'failed'")
+	if err := tx.Create(&attempts).Error; err != nil {
+		return fmt.Errorf("failed to record message attempts: %w", err)
 	}
 
-	now := time.Now().UTC()
-	updates := make(map[string]interface{})
-	updates["status"] = status
+	return recordMessageEvents(tx, messages, EventLeased, &deviceID, nil)
+}
+
+var (
+	ErrInvalidStatusTransition = errors.New("invalid status transition")
+	ErrStaleStatusUpdate       = errors.New("message status has already advanced")
+	ErrMessageNotAssigned      = errors.New("message is not assigned to this device")
+	ErrLeaseExpired            = errors.New("device no longer holds the message lease")
+)
 
-	if status == "sent" {
-		updates["sent_at"] = now
-	} else {
-		updates["failed_at"] = now
-		updates["failure_reason"] = reason
+// A message that was sent can no longer fail; a carrier failure after that
+// is reported as undelivered.
+var statusTransitions = map[string][]string{
+	"pending": {"sent", "failed", "delivered", "undelivered"},
+	"sent":    {"delivered", "undelivered"},
+}
+
+var statusStages = map[string]int{
+	"pending":     0,
+	"sent":        1,
+	"failed":      1,
+	"delivered":   2,
+	"undelivered": 2,
+}
+
+func checkStatusTransition(from, to string) error {
+	if slices.Contains(statusTransitions[from], to) {
+		return nil
 	}
 
-	result := DB.Model(&Message{}).Where("id = ?", messageID).Updates(updates)
-	if result.Error != nil {
-		return fmt.Errorf("failed to update message status: %w", result.Error)
+	fromStage, fromOK := statusStages[from]
+	if fromOK && fromStage > 0 && (from == to || statusStages[to] < fromStage) {
+		return fmt.Errorf("%w: message is already %s", ErrStaleStatusUpdate, from)
 	}
 
-	if result.RowsAffected == 0 {
-		return gorm.ErrRecordNotFound
+	return fmt.Errorf("%w: cannot change status from %s to %s", ErrInvalidStatusTransition, from, to)
+}
+
+// UpdateMessageStatus applies a status reported by deviceID, which must be
+// the device the message is assigned to.
+func UpdateMessageStatus(deviceID uint, messageID string, status string, reason *string) error {
+	if _, ok := statusStages[status]; !ok || status == "pending" {
+		return fmt.Errorf("invalid status: must be 'sent', 'failed', 'delivered' or 'undelivered'")
 	}
 
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var message Message
+		err := tx.Where("id = ?", messageID).First(&message).Error
+		if err != nil {
+			return err
+		}
+
+		if message.AssignedDeviceID == nil || *message.AssignedDeviceID != deviceID {
+			return ErrMessageNotAssigned
+		}
+
+		if err := checkStatusTransition(message.Status, status); err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		eventType := status
+		attemptStatus := status
+		updates := make(map[string]interface{})
+		updates["status"] = status
+		updates["leased_until"] = nil
+
+		switch status {
+		case "sent":
+			updates["sent_at"] = now
+			updates["failed_at"] = nil
+			updates["failure_reason"] = nil
+		case "delivered", "undelivered":
+			attemptStatus = "sent"
+			if message.SentAt == nil {
+				updates["sent_at"] = now
+			}
+			if status == "delivered" {
+				updates["delivered_at"] = now
+			} else {
+				updates["failed_at"] = now
+				updates["failure_reason"] = reason
+			}
+		case "failed":
+			// Once the lease is gone the message may be queued for a retry or
+			// claimed again, so a late failure must not reschedule it.
+			if message.Status == "pending" && (message.LeasedUntil == nil || !message.LeasedUntil.After(now)) {
+				return ErrLeaseExpired
+			}
+
+			updates["failed_at"] = now
+			updates["failure_reason"] = reason
+
+			policy := GetRetryPolicy()
+			attempts := max(message.Attempts, 1)
+			if message.Status == "pending" && reason != nil && policy.ShouldRetry(attempts, *reason) {
+				eventType = EventRetryScheduled
+				updates["status"] = "pending"
+				updates["failed_at"] = nil
+				updates["next_attempt_at"] = now.Add(policy.Backoff(attempts))
+				if policy.UseOtherDevice {
+					updates["avoid_device_id"] = message.AssignedDeviceID
+				}
+			}
+		}
+
+		if err := tx.Model(&Message{}).Where("id = ?", messageID).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update message status: %w", err)
+		}
+
+		err = tx.Model(&MessageAttempt{}).
+			Where("message_id = ? AND device_id = ? AND status = ?", messageID, deviceID, "leased").
+			Updates(map[string]interface{}{
+				"status":      attemptStatus,
+				"reason":      reason,
+				"finished_at": now,
+			}).Error
+		if err != nil {
+			return fmt.Errorf("failed to update message attempt: %w", err)
+		}
+
+		if err := recordMessageEvent(tx, message, eventType, message.AssignedDeviceID, reason); err != nil {
+			return err
+		}
+
+		if eventType == EventRetryScheduled {
+			return nil
+		}
+
+		return enqueueMessageWebhooks(tx, message, status, reason)
+	})
+	if err != nil {
+		return err
+	}
+
+	notifyMessageEvents()
+
 	return nil
 }
 
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type SimSlot struct {
+	Slot           int     `json:"slot"`
+	Operator       *string `json:"operator,omitempty"`
+	PhoneNumber    *string `json:"phone_number,omitempty"`
+	SignalStrength *int    `json:"signal_strength,omitempty"`
+}
+
+type HeartbeatParams struct {
+	BatteryLevel    *int
+	Charging        *bool
+	SignalStrength  *int
+	NetworkOperator *string
+	SimSlots        []SimSlot
+	AppVersion      *string
+}
+
+type HeartbeatFilters struct {
+	DeviceID uint
+	Since    *time.Time
+	Limit    int
+	Offset   int
+}
+
+// GetLowBatteryLevel is the battery percentage at or below which a device
+// that is not charging is flagged.
+func GetLowBatteryLevel() int {
+	return getEnvInt("DEVICE_LOW_BATTERY_PERCENT", 20)
+}
+
+func (t DeviceTelemetry) SimSlotList() []SimSlot {
+	slots := []SimSlot{}
+	if t.SimSlots != nil {
+		_ = json.Unmarshal([]byte(*t.SimSlots), &slots)
+	}
+	return slots
+}
+
+func (t DeviceTelemetry) IsBatteryLow() bool {
+	charging := t.Charging != nil && *t.Charging
+	return t.BatteryLevel != nil && *t.BatteryLevel <= GetLowBatteryLevel() && !charging
+}
+
+// RecordHeartbeat appends to the device's history and replaces its latest
+// telemetry.
+func RecordHeartbeat(deviceID uint, params HeartbeatParams) (*DeviceHeartbeat, error) {
+	telemetry := DeviceTelemetry{
+		BatteryLevel:    params.BatteryLevel,
+		Charging:        params.Charging,
+		SignalStrength:  params.SignalStrength,
+		NetworkOperator: params.NetworkOperator,
+		AppVersion:      params.AppVersion,
+	}
+	if params.SimSlots != nil {
+		encoded, err := json.Marshal(params.SimSlots)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode sim slots: %w", err)
+		}
+		simSlots := string(encoded)
+		telemetry.SimSlots = &simSlots
+	}
+
+	heartbeat := &DeviceHeartbeat{
+		DeviceID:  deviceID,
+		Telemetry: telemetry,
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(heartbeat).Error; err != nil {
+			return fmt.Errorf("failed to create heartbeat: %w", err)
+		}
+
+		err := tx.Model(&Device{}).Where("id = ?", deviceID).
+			Select("battery_level", "charging", "signal_strength", "network_operator", "sim_slots", "app_version", "last_heartbeat_at").
+			Updates(&Device{Telemetry: telemetry, LastHeartbeatAt: &heartbeat.CreatedAt}).Error
+		if err != nil {
+			return fmt.Errorf("failed to update device telemetry: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return heartbeat, nil
+}
+
+func GetDeviceHeartbeats(filters HeartbeatFilters) ([]DeviceHeartbeat, int, error) {
+	query := DB.Model(&DeviceHeartbeat{}).Where("device_id = ?", filters.DeviceID)
+	if filters.Since != nil {
+		query = query.Where("created_at >= ?", filters.Since.UTC())
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count heartbeats: %w", err)
+	}
+
+	query = query.Order("created_at DESC, id DESC")
+	if filters.Limit > 0 {
+		query = query.Limit(filters.Limit)
+	}
+	if filters.Offset > 0 {
+		query = query.Offset(filters.Offset)
+	}
+
+	var heartbeats []DeviceHeartbeat
+	if err := query.Find(&heartbeats).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to query heartbeats: %w", err)
+	}
+
+	return heartbeats, int(total), nil
+}
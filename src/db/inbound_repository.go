@@ -0,0 +1,176 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type InboundParams struct {
+	DeviceID   uint
+	FromNumber string
+	Body       string
+	SimSlot    *int
+	ReceivedAt time.Time
+}
+
+type InboundFilters struct {
+	Topic      string
+	Topics     []string
+	FromNumber string
+	Keyword    string
+	DeviceID   uint
+	Limit      int
+	Offset     int
+}
+
+type InboundWebhookData struct {
+	ID               string    `json:"id"`
+	FromNumber       string    `json:"from_number"`
+	Body             string    `json:"body"`
+	DeviceID         uint      `json:"device_id"`
+	SimSlot          *int      `json:"sim_slot,omitempty"`
+	Topic            *string   `json:"topic,omitempty"`
+	ReplyToMessageID *string   `json:"reply_to_message_id,omitempty"`
+	OptOut           bool      `json:"opt_out"`
+	ReceivedAt       time.Time `json:"received_at"`
+}
+
+func CreateInboundMessage(params InboundParams) (*InboundMessage, error) {
+	inbound := &InboundMessage{
+		ID:         fmt.Sprintf("in_%s", uuid.New().String()[:8]),
+		DeviceID:   &params.DeviceID,
+		FromNumber: params.FromNumber,
+		Body:       params.Body,
+		SimSlot:    params.SimSlot,
+		ReceivedAt: params.ReceivedAt.UTC(),
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		original, err := findRepliedMessage(tx, params.FromNumber)
+		if err != nil {
+			return err
+		}
+
+		var callbackURL *string
+		if original != nil {
+			inbound.Topic = &original.Topic
+			inbound.ReplyToMessageID = &original.ID
+			callbackURL = original.CallbackURL
+		}
+
+		inbound.OptOut = IsOptOutKeyword(params.Body)
+
+		if err := tx.Create(inbound).Error; err != nil {
+			return fmt.Errorf("failed to create inbound message: %w", err)
+		}
+
+		topic := ""
+		if inbound.Topic != nil {
+			topic = *inbound.Topic
+		}
+
+		if inbound.OptOut {
+			suppressionTopic := GlobalSuppressionTopic
+			if getOptOutScope() == "topic" && topic != "" {
+				suppressionTopic = topic
+			}
+			if _, err := addSuppression(tx, inbound.FromNumber, suppressionTopic, "keyword", &inbound.ID); err != nil {
+				return err
+			}
+		}
+
+		data := InboundWebhookData{
+			ID:               inbound.ID,
+			FromNumber:       inbound.FromNumber,
+			Body:             inbound.Body,
+			DeviceID:         params.DeviceID,
+			SimSlot:          inbound.SimSlot,
+			Topic:            inbound.Topic,
+			ReplyToMessageID: inbound.ReplyToMessageID,
+			OptOut:           inbound.OptOut,
+			ReceivedAt:       inbound.ReceivedAt,
+		}
+
+		return enqueueWebhookEvent(tx, topic, inbound.ReplyToMessageID, callbackURL, WebhookMessageReceived, data)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return inbound, nil
+}
+
+func findRepliedMessage(tx *gorm.DB, fromNumber string) (*Message, error) {
+	var message Message
+	err := tx.Where("to_number = ?", fromNumber).
+		Where("sent_at IS NOT NULL").
+		Order("sent_at DESC").
+		First(&message).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find replied message: %w", err)
+	}
+	return &message, nil
+}
+
+func applyInboundFilters(query *gorm.DB, filters InboundFilters) *gorm.DB {
+	if filters.Topic != "" {
+		query = query.Where("topic = ?", filters.Topic)
+	}
+
+	if len(filters.Topics) > 0 {
+		query = query.Where("topic IN ?", filters.Topics)
+	}
+
+	if filters.FromNumber != "" {
+		query = query.Where("from_number = ?", filters.FromNumber)
+	}
+
+	if filters.Keyword != "" {
+		query = query.Where("LOWER(body) LIKE LOWER(?)", "%"+filters.Keyword+"%")
+	}
+
+	if filters.DeviceID != 0 {
+		query = query.Where("device_id = ?", filters.DeviceID)
+	}
+
+	return query
+}
+
+func GetInboundMessages(filters InboundFilters) ([]InboundMessage, error) {
+	query := applyInboundFilters(DB.Model(&InboundMessage{}), filters)
+
+	query = query.Order("received_at DESC")
+
+	if filters.Limit > 0 {
+		query = query.Limit(filters.Limit)
+	}
+
+	if filters.Offset > 0 {
+		query = query.Offset(filters.Offset)
+	}
+
+	var messages []InboundMessage
+	if err := query.Find(&messages).Error; err != nil {
+		return nil, fmt.Errorf("failed to query inbound messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+func CountInboundMessages(filters InboundFilters) (int, error) {
+	query := applyInboundFilters(DB.Model(&InboundMessage{}), filters)
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("failed to count inbound messages: %w", err)
+	}
+
+	return int(count), nil
+}
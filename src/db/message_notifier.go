@@ -0,0 +1,97 @@
+package db
+
+import "sync"
+
+// Long-polling devices wait on the topics they serve and are woken when a
+// message for one of them is queued or released by this process. Other API
+// instances do not notify each other, so waiters still re-check on timeout.
+type messageWaiter struct {
+	topics []string
+	ready  chan struct{}
+}
+
+var (
+	waitersMu      sync.Mutex
+	waitersByTopic = map[string]map[*messageWaiter]struct{}{}
+)
+
+// WaitForMessages returns a channel that is closed once a message becomes
+// available for any of topics. The returned function must be called to stop
+// waiting.
+func WaitForMessages(topics []string) (<-chan struct{}, func()) {
+	waiter := &messageWaiter{topics: topics, ready: make(chan struct{})}
+
+	waitersMu.Lock()
+	for _, topic := range topics {
+		if waitersByTopic[topic] == nil {
+			waitersByTopic[topic] = map[*messageWaiter]struct{}{}
+		}
+		waitersByTopic[topic][waiter] = struct{}{}
+	}
+	waitersMu.Unlock()
+
+	return waiter.ready, func() {
+		waitersMu.Lock()
+		removeWaiter(waiter)
+		waitersMu.Unlock()
+	}
+}
+
+func notifyMessageWaiters(messages []Message) {
+	waitersMu.Lock()
+	defer waitersMu.Unlock()
+
+	for _, msg := range messages {
+		for waiter := range waitersByTopic[msg.Topic] {
+			removeWaiter(waiter)
+			close(waiter.ready)
+		}
+	}
+}
+
+// removeWaiter must be called with waitersMu held. A waiter is removed from
+// every topic before its channel is closed, so it is never closed twice.
+func removeWaiter(waiter *messageWaiter) {
+	for _, topic := range waiter.topics {
+		delete(waitersByTopic[topic], waiter)
+		if len(waitersByTopic[topic]) == 0 {
+			delete(waitersByTopic, topic)
+		}
+	}
+}
+
+// Event streams are woken whenever this process commits message events and
+// then read the event log themselves, so a wake-up carries no data.
+var (
+	eventSubscribersMu sync.Mutex
+	eventSubscribers   = map[chan struct{}]struct{}{}
+)
+
+// SubscribeMessageEvents returns a channel that receives a value after
+// message events are recorded. Wake-ups are coalesced while the subscriber
+// is busy. The returned function must be called to unsubscribe.
+func SubscribeMessageEvents() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	eventSubscribersMu.Lock()
+	eventSubscribers[ch] = struct{}{}
+	eventSubscribersMu.Unlock()
+
+	return ch, func() {
+		eventSubscribersMu.Lock()
+		delete(eventSubscribers, ch)
+		eventSubscribersMu.Unlock()
+	}
+}
+
+func notifyMessageEvents() {
+	eventSubscribersMu.Lock()
+	defer eventSubscribersMu.Unlock()
+
+	for ch := range eventSubscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
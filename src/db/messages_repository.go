@@ -1,19 +1,25 @@
 package db
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"sms-gateway-api/sms"
 	"strconv"
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type MessageFilters struct {
 	Topic    string
+	Topics   []string
 	ToNumber string
 	Keyword  string
 	Status   string
+	Priority string
 	Limit    int
 	Offset   int
 }
@@ -32,12 +38,57 @@ func getDeduplicationInterval() time.Duration {
 	return time.Duration(minutes) * time.Minute
 }
 
+var (
+	ErrDuplicateMessage  = errors.New("duplicate message")
+	ErrMessageNotPending = errors.New("message is no longer pending")
+	ErrMessageLeased     = errors.New("message is currently leased to a device")
+
+	ErrIdempotencyKeyExists = errors.New("idempotency key was already used")
+)
+
+func getIdempotencyKeyTTL() time.Duration {
+	ttlStr := os.Getenv("IDEMPOTENCY_KEY_TTL_MINUTES")
+	if ttlStr == "" {
+		return 1440 * time.Minute
+	}
+
+	minutes, err := strconv.Atoi(ttlStr)
+	if err != nil || minutes < 0 {
+		return 1440 * time.Minute
+	}
+
+	return time.Duration(minutes) * time.Minute
+}
+
+// FindMessageByIdempotencyKey only looks at keys sent with the same API key;
+// owner is 0 for requests made without one.
+func FindMessageByIdempotencyKey(owner uint, key string) (*Message, error) {
+	cutoffTime := time.Now().Add(-getIdempotencyKeyTTL())
+
+	var message Message
+	err := DB.Where("idempotency_owner = ? AND idempotency_key = ? AND created_at > ?", owner, key, cutoffTime).
+		First(&message).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message by idempotency key: %w", err)
+	}
+
+	return &message, nil
+}
+
 func FindDuplicateMessage(toNumber, body string) (*Message, error) {
+	return findDuplicateMessage(DB, toNumber, body)
+}
+
+func findDuplicateMessage(tx *gorm.DB, toNumber, body string) (*Message, error) {
 	interval := getDeduplicationInterval()
 	cutoffTime := time.Now().Add(-interval)
 
 	var message Message
-	err := DB.Where("to_number = ? AND body = ? AND created_at > ?", toNumber, body, cutoffTime).
+	err := tx.Where("to_number = ? AND body = ? AND created_at > ?", toNumber, body, cutoffTime).
+		Where("status NOT IN ?", []string{"failed", "undelivered", "cancelled", "expired"}).
 		Order("created_at DESC").
 		First(&message).Error
 
@@ -48,36 +99,375 @@ func FindDuplicateMessage(toNumber, body string) (*Message, error) {
 	return &message, nil
 }
 
-func CreateMessage(topic, toNumber, body string) (*Message, error) {
-	existingMsg, err := FindDuplicateMessage(toNumber, body)
-	if err == nil && existingMsg != nil {
-		return nil, fmt.Errorf("duplicate message: same message was sent to %s within the deduplication interval", toNumber)
-	}
+func duplicateMessageError(toNumber string) error {
+	return fmt.Errorf("%w: same message was sent to %s within the deduplication interval", ErrDuplicateMessage, toNumber)
+}
+
+type MessageParams struct {
+	Topic     string
+	ToNumber  string
+	Body      string
+	SendAt    *time.Time
+	ExpiresAt *time.Time
+	Priority  string
+
+	IdempotencyKey   string
+	IdempotencyOwner uint
+	CallbackURL      *string
+
+	TemplateName    string
+	TemplateVersion int
+	Locale          string
+}
 
-	id := fmt.Sprintf("msg_%s", uuid.New().String()[:8])
+type BatchResult struct {
+	Message *Message
+	Err     error
+}
 
-	message := &Message{
-		ID:       id,
+func CreateMessage(topic, toNumber, body string) (*Message, error) {
+	return CreateMessageWithParams(MessageParams{
 		Topic:    topic,
 		ToNumber: toNumber,
 		Body:     body,
-		Status:   "pending",
+	})
+}
+
+func CreateMessageWithParams(params MessageParams) (*Message, error) {
+	suppressed, err := isSuppressed(DB, params.ToNumber, params.Topic)
+	if err != nil {
+		return nil, err
+	}
+	if suppressed {
+		return nil, suppressedRecipientError(params.ToNumber)
+	}
+
+	if params.IdempotencyKey == "" {
+		existingMsg, err := FindDuplicateMessage(params.ToNumber, params.Body)
+		if err == nil && existingMsg != nil {
+			return nil, duplicateMessageError(params.ToNumber)
+		}
 	}
 
-	if err := DB.Create(message).Error; err != nil {
-		return nil, fmt.Errorf("failed to create message: %w", err)
+	message := newMessage(params)
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if message.IdempotencyKey != nil {
+			// An expired key may be used again, so the message holding it
+			// gives it up.
+			err := tx.Model(&Message{}).
+				Where("idempotency_owner = ? AND idempotency_key = ?", message.IdempotencyOwner, *message.IdempotencyKey).
+				Where("created_at <= ?", time.Now().Add(-getIdempotencyKeyTTL())).
+				Update("idempotency_key", nil).Error
+			if err != nil {
+				return fmt.Errorf("failed to release idempotency key: %w", err)
+			}
+		}
+
+		if err := tx.Create(message).Error; err != nil {
+			if message.IdempotencyKey != nil && errors.Is(err, gorm.ErrDuplicatedKey) {
+				return ErrIdempotencyKeyExists
+			}
+			return fmt.Errorf("failed to create message: %w", err)
+		}
+
+		return recordMessageEvent(tx, *message, EventQueued, nil, nil)
+	})
+
+	if err != nil {
+		return nil, err
 	}
 
+	notifyMessageWaiters([]Message{*message})
+	notifyMessageEvents()
+
 	return message, nil
 }
 
-func GetMessages(filters MessageFilters) ([]Message, error) {
-	query := DB.Model(&Message{})
+func CreateMessagesBatch(params []MessageParams) ([]BatchResult, error) {
+	results := make([]BatchResult, len(params))
+	var created []Message
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		seen := make(map[string]bool)
+		var messages []*Message
+
+		for i, p := range params {
+			suppressed, err := isSuppressed(tx, p.ToNumber, p.Topic)
+			if err != nil {
+				return err
+			}
+			if suppressed {
+				results[i].Err = suppressedRecipientError(p.ToNumber)
+				continue
+			}
+
+			key := p.ToNumber + "\x00" + p.Body
+			if seen[key] {
+				results[i].Err = duplicateMessageError(p.ToNumber)
+				continue
+			}
+			seen[key] = true
+
+			existingMsg, err := findDuplicateMessage(tx, p.ToNumber, p.Body)
+			if err == nil && existingMsg != nil {
+				results[i].Err = duplicateMessageError(p.ToNumber)
+				continue
+			}
+
+			results[i].Message = newMessage(p)
+			messages = append(messages, results[i].Message)
+		}
+
+		if len(messages) == 0 {
+			return nil
+		}
+
+		if err := tx.CreateInBatches(messages, 100).Error; err != nil {
+			return fmt.Errorf("failed to create messages: %w", err)
+		}
+
+		created = make([]Message, len(messages))
+		for i, msg := range messages {
+			created[i] = *msg
+		}
+
+		return recordMessageEvents(tx, created, EventQueued, nil, nil)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	notifyMessageWaiters(created)
+	if len(created) > 0 {
+		notifyMessageEvents()
+	}
+
+	return results, nil
+}
+
+func newMessage(params MessageParams) *Message {
+	priority := params.Priority
+	if priority == "" {
+		priority = "normal"
+	}
+
+	var idempotencyKey *string
+	if params.IdempotencyKey != "" {
+		idempotencyKey = &params.IdempotencyKey
+	}
+
+	var templateName *string
+	var templateVersion *int
+	if params.TemplateName != "" {
+		templateName = &params.TemplateName
+		templateVersion = &params.TemplateVersion
+	}
+
+	var locale *string
+	if params.Locale != "" {
+		locale = &params.Locale
+	}
+
+	stats := sms.AnalyzeBody(params.Body)
+
+	return &Message{
+		ID:               fmt.Sprintf("msg_%s", uuid.New().String()[:8]),
+		Topic:            params.Topic,
+		ToNumber:         params.ToNumber,
+		Body:             params.Body,
+		Encoding:         stats.Encoding,
+		Segments:         stats.Segments,
+		Characters:       stats.Characters,
+		Status:           "pending",
+		SendAt:           toUTC(params.SendAt),
+		ExpiresAt:        toUTC(params.ExpiresAt),
+		Priority:         priority,
+		IdempotencyKey:   idempotencyKey,
+		IdempotencyOwner: params.IdempotencyOwner,
+		CallbackURL:      params.CallbackURL,
+		TemplateName:     templateName,
+		TemplateVersion:  templateVersion,
+		Locale:           locale,
+	}
+}
+
+func ExpireMessages() (int64, error) {
+	now := time.Now().UTC()
+
+	var messages []Message
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Select("id", "topic").
+			Where("status = ?", "pending").
+			Where("expires_at <= ?", now).
+			Where("leased_until IS NULL OR leased_until <= ?", now).
+			Find(&messages).Error
+		if err != nil {
+			return err
+		}
+
+		if len(messages) == 0 {
+			return nil
+		}
+
+		messageIDs := make([]string, len(messages))
+		for i, msg := range messages {
+			messageIDs[i] = msg.ID
+		}
+
+		err = tx.Model(&Message{}).
+			Where("id IN ?", messageIDs).
+			Updates(map[string]interface{}{
+				"status":       "expired",
+				"expired_at":   now,
+				"leased_until": nil,
+			}).Error
+		if err != nil {
+			return err
+		}
+
+		return recordMessageEvents(tx, messages, EventExpired, nil, nil)
+	})
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire messages: %w", err)
+	}
+
+	if len(messages) > 0 {
+		notifyMessageEvents()
+	}
+
+	return int64(len(messages)), nil
+}
+
+type MessageChanges struct {
+	ToNumber  *string
+	Body      *string
+	SetSendAt bool
+	SendAt    *time.Time
+}
+
+func CancelMessage(messageID string) (*Message, error) {
+	var message *Message
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		message, err = lockEditableMessage(tx, messageID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		updates := map[string]interface{}{
+			"status":       "cancelled",
+			"cancelled_at": now,
+		}
+		if err := tx.Model(message).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to cancel message: %w", err)
+		}
+
+		return recordMessageEvent(tx, *message, EventCancelled, nil, nil)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	notifyMessageEvents()
+
+	return message, nil
+}
 
+func UpdatePendingMessage(messageID string, changes MessageChanges) (*Message, error) {
+	var message *Message
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		message, err = lockEditableMessage(tx, messageID)
+		if err != nil {
+			return err
+		}
+
+		updates := make(map[string]interface{})
+		if changes.ToNumber != nil {
+			suppressed, err := isSuppressed(tx, *changes.ToNumber, message.Topic)
+			if err != nil {
+				return err
+			}
+			if suppressed {
+				return suppressedRecipientError(*changes.ToNumber)
+			}
+			updates["to_number"] = *changes.ToNumber
+		}
+		if changes.Body != nil {
+			stats := sms.AnalyzeBody(*changes.Body)
+			updates["body"] = *changes.Body
+			updates["encoding"] = stats.Encoding
+			updates["segments"] = stats.Segments
+			updates["characters"] = stats.Characters
+		}
+		if changes.SetSendAt {
+			updates["send_at"] = toUTC(changes.SendAt)
+		}
+
+		if len(updates) == 0 {
+			return nil
+		}
+
+		if err := tx.Model(message).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update message: %w", err)
+		}
+
+		return recordMessageEvent(tx, *message, EventUpdated, nil, nil)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	notifyMessageWaiters([]Message{*message})
+
+	return message, nil
+}
+
+func lockEditableMessage(tx *gorm.DB, messageID string) (*Message, error) {
+	query := tx.Where("id = ?", messageID)
+	if !IsSQLite() {
+		query = query.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate})
+	}
+
+	var message Message
+	if err := query.First(&message).Error; err != nil {
+		return nil, err
+	}
+
+	if message.Status != "pending" {
+		return nil, ErrMessageNotPending
+	}
+
+	if message.LeasedUntil != nil && message.LeasedUntil.After(time.Now()) {
+		return nil, ErrMessageLeased
+	}
+
+	return &message, nil
+}
+
+func toUTC(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
+
+func applyMessageFilters(query *gorm.DB, filters MessageFilters) *gorm.DB {
 	if filters.Topic != "" {
 		query = query.Where("topic = ?", filters.Topic)
 	}
 
+	if len(filters.Topics) > 0 {
+		query = query.Where("topic IN ?", filters.Topics)
+	}
+
 	if filters.ToNumber != "" {
 		query = query.Where("to_number = ?", filters.ToNumber)
 	}
@@ -86,10 +476,27 @@ func GetMessages(filters MessageFilters) ([]Message, error) {
 		query = query.Where("LOWER(body) LIKE LOWER(?)", "%"+filters.Keyword+"%")
 	}
 
-	if filters.Status != "" {
+	if filters.Priority != "" {
+		query = query.Where("priority = ?", filters.Priority)
+	}
+
+	now := time.Now().UTC()
+	switch filters.Status {
+	case "":
+	case "scheduled":
+		query = query.Where("status = ? AND send_at > ?", "pending", now)
+	case "pending":
+		query = query.Where("status = ? AND (send_at IS NULL OR send_at <= ?)", "pending", now)
+	default:
 		query = query.Where("status = ?", filters.Status)
 	}
 
+	return query
+}
+
+func GetMessages(filters MessageFilters) ([]Message, error) {
+	query := applyMessageFilters(DB.Model(&Message{}), filters)
+
 	query = query.Order("created_at DESC")
 
 	if filters.Limit > 0 {
@@ -109,23 +516,7 @@ func GetMessages(filters MessageFilters) ([]Message, error) {
 }
 
 func CountMessages(filters MessageFilters) (int, error) {
-	query := DB.Model(&Message{})
-
-	if filters.Topic != "" {
-		query = query.Where("topic = ?", filters.Topic)
-	}
-
-	if filters.ToNumber != "" {
-		query = query.Where("to_number = ?", filters.ToNumber)
-	}
-
-	if filters.Keyword != "" {
-		query = query.Where("LOWER(body) LIKE LOWER(?)", "%"+filters.Keyword+"%")
-	}
-
-	if filters.Status != "" {
-		query = query.Where("status = ?", filters.Status)
-	}
+	query := applyMessageFilters(DB.Model(&Message{}), filters)
 
 	var count int64
 	if err := query.Count(&count).Error; err != nil {
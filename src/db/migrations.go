@@ -1,12 +1,53 @@
 package db
 
+import (
+	"fmt"
+	"sms-gateway-api/sms"
+
+	"gorm.io/gorm"
+)
+
+type migration struct {
+	Version int
+	Up      func(tx *gorm.DB) error
+}
+
+var migrations = []migration{
+	{Version: 2, Up: refreshMessageStatusConstraint},
+	{Version: 3, Up: refreshMessageStatusConstraint},
+	{Version: 4, Up: refreshMessageStatusConstraint},
+	{Version: 5, Up: func(tx *gorm.DB) error {
+		_, err := normalizeStoredPhoneNumbers(tx)
+		return err
+	}},
+	{Version: 6, Up: backfillMessageBodyStats},
+	{Version: 7, Up: addTemplateLocales},
+	{Version: 8, Up: scopeIdempotencyKeys},
+	{Version: 9, Up: keepInboundMessagesOfDeletedDevices},
+}
+
 func RunMigrations() error {
-	return DB.AutoMigrate(
+	err := DB.AutoMigrate(
 		&Device{},
 		&Message{},
 		&DeviceTopic{},
+		&MessageAttempt{},
+		&MessageEvent{},
+		&InboundMessage{},
+		&Suppression{},
+		&WebhookSubscription{},
+		&WebhookDelivery{},
+		&MessageTemplate{},
+		&APIKey{},
+		&DevicePairingCode{},
+		&DeviceHeartbeat{},
 		&SchemaMigration{},
 	)
+	if err != nil {
+		return err
+	}
+
+	return applyVersionedMigrations()
 }
 
 func InitSchema() error {
@@ -21,3 +62,213 @@ func GetCurrentVersion() (int, error) {
 	}
 	return migration.Version, nil
 }
+
+func applyVersionedMigrations() error {
+	current, err := GetCurrentVersion()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+
+		if err := m.Up(DB); err != nil {
+			return fmt.Errorf("failed to apply migration %d: %w", m.Version, err)
+		}
+
+		if err := DB.Create(&SchemaMigration{Version: m.Version}).Error; err != nil {
+			return fmt.Errorf("failed to record migration %d: %w", m.Version, err)
+		}
+	}
+
+	return nil
+}
+
+func refreshMessageStatusConstraint(tx *gorm.DB) error {
+	migrator := tx.Migrator()
+
+	for _, name := range []string{"chk_status", "chk_messages_status"} {
+		if migrator.HasConstraint(&Message{}, name) {
+			if err := migrator.DropConstraint(&Message{}, name); err != nil {
+				return fmt.Errorf("failed to drop constraint %s: %w", name, err)
+			}
+		}
+	}
+
+	return migrator.CreateConstraint(&Message{}, "chk_messages_status")
+}
+
+func backfillMessageBodyStats(tx *gorm.DB) error {
+	var messages []Message
+	return tx.Select("id", "body").FindInBatches(&messages, 500, func(_ *gorm.DB, _ int) error {
+		for _, message := range messages {
+			stats := sms.AnalyzeBody(message.Body)
+			err := tx.Model(&Message{}).Where("id = ?", message.ID).Updates(map[string]interface{}{
+				"encoding":   stats.Encoding,
+				"segments":   stats.Segments,
+				"characters": stats.Characters,
+			}).Error
+			if err != nil {
+				return fmt.Errorf("failed to backfill message %s: %w", message.ID, err)
+			}
+		}
+		return nil
+	}).Error
+}
+
+// Templates created before locale variants existed become variants of the
+// default locale, and the old (topic, name, version) unique index is dropped
+// so other locales can reuse version numbers.
+func addTemplateLocales(tx *gorm.DB) error {
+	migrator := tx.Migrator()
+	if migrator.HasIndex(&MessageTemplate{}, "idx_template_version") {
+		if err := migrator.DropIndex(&MessageTemplate{}, "idx_template_version"); err != nil {
+			return fmt.Errorf("failed to drop index idx_template_version: %w", err)
+		}
+	}
+
+	err := tx.Model(&MessageTemplate{}).Where("locale = ?", "").Update("locale", sms.GetDefaultLocale()).Error
+	if err != nil {
+		return fmt.Errorf("failed to set template locales: %w", err)
+	}
+	return nil
+}
+
+// Idempotency keys become unique per owner, the API key that sent them. The
+// index is created here rather than from the model tags because a key reused
+// after it expired is first kept only on its newest message; the older
+// messages are past the TTL and would never replay.
+func scopeIdempotencyKeys(tx *gorm.DB) error {
+	migrator := tx.Migrator()
+	if migrator.HasIndex(&Message{}, "idx_messages_idempotency_key") {
+		if err := migrator.DropIndex(&Message{}, "idx_messages_idempotency_key"); err != nil {
+			return fmt.Errorf("failed to drop index idx_messages_idempotency_key: %w", err)
+		}
+	}
+
+	var keys []string
+	err := tx.Model(&Message{}).
+		Where("idempotency_key IS NOT NULL").
+		Group("idempotency_key").
+		Having("COUNT(*) > 1").
+		Pluck("idempotency_key", &keys).Error
+	if err != nil {
+		return fmt.Errorf("failed to find reused idempotency keys: %w", err)
+	}
+
+	for _, key := range keys {
+		var newest Message
+		if err := tx.Select("id").Where("idempotency_key = ?", key).Order("created_at DESC").First(&newest).Error; err != nil {
+			return fmt.Errorf("failed to find message for idempotency key: %w", err)
+		}
+
+		err := tx.Model(&Message{}).
+			Where("idempotency_key = ? AND id <> ?", key, newest.ID).
+			Update("idempotency_key", nil).Error
+		if err != nil {
+			return fmt.Errorf("failed to clear reused idempotency key: %w", err)
+		}
+	}
+
+	if migrator.HasIndex(&Message{}, "idx_messages_idempotency_key_owner") {
+		return nil
+	}
+	err = tx.Exec("CREATE UNIQUE INDEX idx_messages_idempotency_key_owner ON messages (idempotency_owner, idempotency_key)").Error
+	if err != nil {
+		return fmt.Errorf("failed to create index idx_messages_idempotency_key_owner: %w", err)
+	}
+	return nil
+}
+
+// Inbound messages used to be deleted together with their device. AutoMigrate
+// makes device_id nullable but leaves the existing foreign key alone, so it is
+// recreated with ON DELETE SET NULL.
+func keepInboundMessagesOfDeletedDevices(tx *gorm.DB) error {
+	migrator := tx.Migrator()
+	if migrator.HasConstraint(&InboundMessage{}, "Device") {
+		if err := migrator.DropConstraint(&InboundMessage{}, "Device"); err != nil {
+			return fmt.Errorf("failed to drop inbound message device constraint: %w", err)
+		}
+	}
+
+	if err := migrator.CreateConstraint(&InboundMessage{}, "Device"); err != nil {
+		return fmt.Errorf("failed to create inbound message device constraint: %w", err)
+	}
+	return nil
+}
+
+func NormalizeStoredPhoneNumbers() (int64, error) {
+	var updated int64
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		updated, err = normalizeStoredPhoneNumbers(tx)
+		return err
+	})
+	return updated, err
+}
+
+func normalizeStoredPhoneNumbers(tx *gorm.DB) (int64, error) {
+	var updated int64
+
+	columns := []struct {
+		model  interface{}
+		column string
+	}{
+		{&Message{}, "to_number"},
+		{&InboundMessage{}, "from_number"},
+	}
+
+	for _, c := range columns {
+		var numbers []string
+		if err := tx.Model(c.model).Distinct(c.column).Pluck(c.column, &numbers).Error; err != nil {
+			return updated, fmt.Errorf("failed to read %s: %w", c.column, err)
+		}
+
+		for _, raw := range numbers {
+			normalized, err := sms.NormalizePhoneNumber(raw)
+			if err != nil || normalized == raw {
+				continue
+			}
+
+			result := tx.Model(c.model).Where(c.column+" = ?", raw).Update(c.column, normalized)
+			if result.Error != nil {
+				return updated, fmt.Errorf("failed to normalize %s %s: %w", c.column, raw, result.Error)
+			}
+			updated += result.RowsAffected
+		}
+	}
+
+	var suppressions []Suppression
+	if err := tx.Find(&suppressions).Error; err != nil {
+		return updated, fmt.Errorf("failed to read suppressions: %w", err)
+	}
+
+	for _, suppression := range suppressions {
+		normalized, err := sms.NormalizePhoneNumber(suppression.PhoneNumber)
+		if err != nil || normalized == suppression.PhoneNumber {
+			continue
+		}
+
+		var count int64
+		err = tx.Model(&Suppression{}).
+			Where("phone_number = ? AND topic = ?", normalized, suppression.Topic).
+			Count(&count).Error
+		if err != nil {
+			return updated, fmt.Errorf("failed to check suppression: %w", err)
+		}
+
+		if count > 0 {
+			err = tx.Delete(&suppression).Error
+		} else {
+			err = tx.Model(&suppression).Update("phone_number", normalized).Error
+		}
+		if err != nil {
+			return updated, fmt.Errorf("failed to normalize suppression %d: %w", suppression.ID, err)
+		}
+		updated++
+	}
+
+	return updated, nil
+}
@@ -2,16 +2,40 @@ package db
 
 import (
 	"time"
+
+	"gorm.io/gorm"
 )
 
 type Device struct {
-	ID         uint          `gorm:"primaryKey;autoIncrement"`
-	DeviceKey  string        `gorm:"uniqueIndex;size:255;not null"`
-	Name       *string       `gorm:"size:255"`
-	CreatedAt  time.Time     `gorm:"not null;autoCreateTime"`
-	UpdatedAt  time.Time     `gorm:"not null;autoUpdateTime"`
-	LastPollAt *time.Time    `gorm:"index"`
-	Topics     []DeviceTopic `gorm:"foreignKey:DeviceID;constraint:OnDelete:CASCADE"`
+	ID              uint       `gorm:"primaryKey;autoIncrement"`
+	DeviceKey       string     `gorm:"uniqueIndex;size:255;not null"`
+	Name            *string    `gorm:"size:255"`
+	CreatedAt       time.Time  `gorm:"not null;autoCreateTime"`
+	UpdatedAt       time.Time  `gorm:"not null;autoUpdateTime"`
+	LastPollAt      *time.Time `gorm:"index"`
+	Disabled        bool       `gorm:"not null;default:false"`
+	OfflineSince    *time.Time
+	Telemetry       DeviceTelemetry `gorm:"embedded"`
+	LastHeartbeatAt *time.Time
+	Topics          []DeviceTopic `gorm:"foreignKey:DeviceID;constraint:OnDelete:CASCADE"`
+}
+
+// DeviceTelemetry is the health a device reports with each heartbeat. It is
+// kept as the latest state on Device and as history in DeviceHeartbeat.
+type DeviceTelemetry struct {
+	BatteryLevel    *int
+	Charging        *bool
+	SignalStrength  *int
+	NetworkOperator *string `gorm:"size:100"`
+	SimSlots        *string `gorm:"type:text"`
+	AppVersion      *string `gorm:"size:50"`
+}
+
+type DeviceHeartbeat struct {
+	ID        uint            `gorm:"primaryKey;autoIncrement"`
+	DeviceID  uint            `gorm:"index;not null"`
+	Telemetry DeviceTelemetry `gorm:"embedded"`
+	CreatedAt time.Time       `gorm:"index;not null;autoCreateTime"`
 }
 
 type Message struct {
@@ -19,13 +43,89 @@ type Message struct {
 	Topic            string    `gorm:"index:idx_topic_status;size:255;not null"`
 	ToNumber         string    `gorm:"index;size:20;not null"`
 	Body             string    `gorm:"type:text;not null"`
-	Status           string    `gorm:"index:idx_topic_status;size:20;not null;default:pending;check:status IN ('pending','sent','failed')"`
+	Encoding         string    `gorm:"size:10;not null;default:gsm7"`
+	Segments         int       `gorm:"not null;default:1"`
+	Characters       int       `gorm:"not null;default:0"`
+	Status           string    `gorm:"index:idx_topic_status;size:20;not null;default:pending;check:status IN ('pending','sent','delivered','undelivered','failed','expired','cancelled')"`
 	CreatedAt        time.Time `gorm:"index;not null;autoCreateTime"`
 	SentAt           *time.Time
+	DeliveredAt      *time.Time
 	FailedAt         *time.Time
-	FailureReason    *string `gorm:"type:text"`
-	AssignedDeviceID *uint   `gorm:"index"`
-	AssignedDevice   *Device `gorm:"foreignKey:AssignedDeviceID;constraint:OnDelete:SET NULL"`
+	FailureReason    *string    `gorm:"type:text"`
+	AssignedDeviceID *uint      `gorm:"index"`
+	AssignedDevice   *Device    `gorm:"foreignKey:AssignedDeviceID;constraint:OnDelete:SET NULL"`
+	LeasedUntil      *time.Time `gorm:"index"`
+	Attempts         int        `gorm:"not null;default:0"`
+	NextAttemptAt    *time.Time `gorm:"index"`
+	AvoidDeviceID    *uint
+	SendAt           *time.Time `gorm:"index"`
+	ExpiresAt        *time.Time `gorm:"index"`
+	ExpiredAt        *time.Time
+	CancelledAt      *time.Time
+	Priority         string  `gorm:"index;size:10;not null;default:normal;check:priority IN ('high','normal','bulk')"`
+	IdempotencyKey   *string `gorm:"size:255"`
+	IdempotencyOwner uint    `gorm:"not null;default:0"`
+	CallbackURL      *string `gorm:"size:2048"`
+	TemplateName     *string `gorm:"index;size:255"`
+	TemplateVersion  *int
+	Locale           *string          `gorm:"index;size:20"`
+	AttemptHistory   []MessageAttempt `gorm:"foreignKey:MessageID;constraint:OnDelete:CASCADE"`
+	Events           []MessageEvent   `gorm:"foreignKey:MessageID;constraint:OnDelete:CASCADE"`
+}
+
+type MessageAttempt struct {
+	ID         uint      `gorm:"primaryKey;autoIncrement"`
+	MessageID  string    `gorm:"index;size:255;not null"`
+	Attempt    int       `gorm:"not null"`
+	DeviceID   *uint     `gorm:"index"`
+	Status     string    `gorm:"size:20;not null;default:leased;check:status IN ('leased','sent','failed','timed_out')"`
+	Reason     *string   `gorm:"type:text"`
+	StartedAt  time.Time `gorm:"not null;autoCreateTime"`
+	FinishedAt *time.Time
+}
+
+type MessageEvent struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement"`
+	MessageID string    `gorm:"index;size:255;not null"`
+	Topic     string    `gorm:"index;size:255;not null"`
+	Type      string    `gorm:"size:30;not null"`
+	DeviceID  *uint     `gorm:"index"`
+	Detail    *string   `gorm:"type:text"`
+	CreatedAt time.Time `gorm:"index;not null;autoCreateTime"`
+}
+
+type MessageTemplate struct {
+	ID        uint           `gorm:"primaryKey;autoIncrement"`
+	Topic     string         `gorm:"uniqueIndex:idx_template_locale_version;size:255;not null"`
+	Name      string         `gorm:"uniqueIndex:idx_template_locale_version;size:255;not null"`
+	Locale    string         `gorm:"uniqueIndex:idx_template_locale_version;size:20;not null;default:''"`
+	Version   int            `gorm:"uniqueIndex:idx_template_locale_version;not null"`
+	Body      string         `gorm:"type:text;not null"`
+	CreatedAt time.Time      `gorm:"not null;autoCreateTime"`
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+type APIKey struct {
+	ID         uint      `gorm:"primaryKey;autoIncrement"`
+	Name       string    `gorm:"size:255;not null"`
+	Prefix     string    `gorm:"size:16;not null"`
+	KeyHash    string    `gorm:"uniqueIndex;size:64;not null"`
+	Scopes     string    `gorm:"size:255;not null"`
+	Topics     string    `gorm:"type:text"`
+	CreatedAt  time.Time `gorm:"not null;autoCreateTime"`
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time `gorm:"index"`
+}
+
+type DevicePairingCode struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement"`
+	Code      string    `gorm:"uniqueIndex;size:16;not null"`
+	Name      *string   `gorm:"size:255"`
+	Topics    string    `gorm:"type:text"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+	UsedAt    *time.Time
+	DeviceID  *uint
+	CreatedAt time.Time `gorm:"not null;autoCreateTime"`
 }
 
 type DeviceTopic struct {
@@ -36,6 +136,53 @@ type DeviceTopic struct {
 	Device    Device    `gorm:"foreignKey:DeviceID;constraint:OnDelete:CASCADE"`
 }
 
+type InboundMessage struct {
+	ID               string  `gorm:"primaryKey;size:255"`
+	DeviceID         *uint   `gorm:"index"`
+	Device           *Device `gorm:"foreignKey:DeviceID;constraint:OnDelete:SET NULL"`
+	FromNumber       string  `gorm:"index;size:20;not null"`
+	Body             string  `gorm:"type:text;not null"`
+	SimSlot          *int
+	Topic            *string   `gorm:"index;size:255"`
+	ReplyToMessageID *string   `gorm:"index;size:255"`
+	OptOut           bool      `gorm:"not null;default:false"`
+	ReceivedAt       time.Time `gorm:"index;not null"`
+	CreatedAt        time.Time `gorm:"index;not null;autoCreateTime"`
+}
+
+type Suppression struct {
+	ID               uint      `gorm:"primaryKey;autoIncrement"`
+	PhoneNumber      string    `gorm:"uniqueIndex:idx_suppression_number_topic;size:20;not null"`
+	Topic            string    `gorm:"uniqueIndex:idx_suppression_number_topic;index;size:255;not null;default:*"`
+	Reason           string    `gorm:"size:255;not null"`
+	InboundMessageID *string   `gorm:"size:255"`
+	CreatedAt        time.Time `gorm:"not null;autoCreateTime"`
+}
+
+type WebhookSubscription struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement"`
+	Topic     string    `gorm:"index;size:255;not null"`
+	URL       string    `gorm:"size:2048;not null"`
+	Events    string    `gorm:"size:255;not null;default:''"`
+	CreatedAt time.Time `gorm:"not null;autoCreateTime"`
+}
+
+type WebhookDelivery struct {
+	ID             uint      `gorm:"primaryKey;autoIncrement"`
+	SubscriptionID *uint     `gorm:"index"`
+	MessageID      *string   `gorm:"index;size:255"`
+	Event          string    `gorm:"size:50;not null"`
+	URL            string    `gorm:"size:2048;not null"`
+	Payload        string    `gorm:"type:text;not null"`
+	Status         string    `gorm:"index;size:20;not null;default:pending;check:status IN ('pending','delivered','dead')"`
+	Attempts       int       `gorm:"not null;default:0"`
+	NextAttemptAt  time.Time `gorm:"index;not null"`
+	LastStatusCode *int
+	LastError      *string   `gorm:"type:text"`
+	CreatedAt      time.Time `gorm:"index;not null;autoCreateTime"`
+	DeliveredAt    *time.Time
+}
+
 type SchemaMigration struct {
 	Version   int       `gorm:"primaryKey"`
 	AppliedAt time.Time `gorm:"not null;autoCreateTime"`
@@ -3,46 +3,89 @@ package db
 import (
 	"fmt"
 	"time"
+
+	"gorm.io/gorm"
 )
 
-type ReportSummary struct {
-	Total   int64
-	Sent    int64
-	Failed  int64
-	Pending int64
+type ReportFilters struct {
+	Topic    string
+	Topics   []string
+	Template string
+	Locale   string
+}
+
+// ReportCounts are the per-status totals of a report, for the whole period
+// or for one topic, locale or date.
+type ReportCounts struct {
+	Total        int64
+	Sent         int64
+	Delivered    int64
+	Undelivered  int64
+	Failed       int64
+	Pending      int64
+	Expired      int64
+	Cancelled    int64
+	Segments     int64
+	SentSegments int64
 }
 
+// reportCountsSelect computes the ReportCounts columns; grouped queries
+// prepend their group column.
+const reportCountsSelect = `
+		COUNT(*) as total,
+		SUM(CASE WHEN status = 'sent' THEN 1 ELSE 0 END) as sent,
+		SUM(CASE WHEN status = 'delivered' THEN 1 ELSE 0 END) as delivered,
+		SUM(CASE WHEN status = 'undelivered' THEN 1 ELSE 0 END) as undelivered,
+		SUM(CASE WHEN status = 'failed' THEN 1 ELSE 0 END) as failed,
+		SUM(CASE WHEN status = 'pending' THEN 1 ELSE 0 END) as pending,
+		SUM(CASE WHEN status = 'expired' THEN 1 ELSE 0 END) as expired,
+		SUM(CASE WHEN status = 'cancelled' THEN 1 ELSE 0 END) as cancelled,
+		SUM(segments) as segments,
+		SUM(CASE WHEN status IN ('sent','delivered','undelivered') THEN segments ELSE 0 END) as sent_segments
+	`
+
 type TopicStats struct {
-	Topic   string
-	Total   int64
-	Sent    int64
-	Failed  int64
-	Pending int64
+	Topic string
+	ReportCounts
+}
+
+type LocaleStats struct {
+	Locale string
+	ReportCounts
 }
 
 type TimelineEntry struct {
-	Date    string
-	Total   int64
-	Sent    int64
-	Failed  int64
-	Pending int64
+	Date string
+	ReportCounts
 }
 
-func GetReportSummary(startDate, endDate time.Time, topic string) (*ReportSummary, error) {
-	query := DB.Model(&Message{}).
-		Where("created_at >= ? AND created_at <= ?", startDate, endDate)
+func applyReportFilters(query *gorm.DB, startDate, endDate time.Time, filters ReportFilters) *gorm.DB {
+	query = query.Where("created_at >= ? AND created_at <= ?", startDate, endDate)
 
-	if topic != "" {
-		query = query.Where("topic = ?", topic)
+	if filters.Topic != "" {
+		query = query.Where("topic = ?", filters.Topic)
 	}
 
-	var summary ReportSummary
-	err := query.Select(`
-		COUNT(*) as total,
-		SUM(CASE WHEN status = 'sent' THEN 1 ELSE 0 END) as sent,
-		SUM(CASE WHEN status = 'failed' THEN 1 ELSE 0 END) as failed,
-		SUM(CASE WHEN status = 'pending' THEN 1 ELSE 0 END) as pending
-	`).Scan(&summary).Error
+	if len(filters.Topics) > 0 {
+		query = query.Where("topic IN ?", filters.Topics)
+	}
+
+	if filters.Template != "" {
+		query = query.Where("template_name = ?", filters.Template)
+	}
+
+	if filters.Locale != "" {
+		query = query.Where("locale = ?", filters.Locale)
+	}
+
+	return query
+}
+
+func GetReportSummary(startDate, endDate time.Time, filters ReportFilters) (*ReportCounts, error) {
+	query := applyReportFilters(DB.Model(&Message{}), startDate, endDate, filters)
+
+	var summary ReportCounts
+	err := query.Select(reportCountsSelect).Scan(&summary).Error
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to get report summary: %w", err)
@@ -51,22 +94,11 @@ func GetReportSummary(startDate, endDate time.Time, topic string) (*ReportSummar
 	return &summary, nil
 }
 
-func GetTopicStats(startDate, endDate time.Time, topicFilter string) ([]TopicStats, error) {
-	query := DB.Model(&Message{}).
-		Where("created_at >= ? AND created_at <= ?", startDate, endDate)
-
-	if topicFilter != "" {
-		query = query.Where("topic = ?", topicFilter)
-	}
+func GetTopicStats(startDate, endDate time.Time, filters ReportFilters) ([]TopicStats, error) {
+	query := applyReportFilters(DB.Model(&Message{}), startDate, endDate, filters)
 
 	var stats []TopicStats
-	err := query.Select(`
-		topic,
-		COUNT(*) as total,
-		SUM(CASE WHEN status = 'sent' THEN 1 ELSE 0 END) as sent,
-		SUM(CASE WHEN status = 'failed' THEN 1 ELSE 0 END) as failed,
-		SUM(CASE WHEN status = 'pending' THEN 1 ELSE 0 END) as pending
-	`).Group("topic").Order("topic").Scan(&stats).Error
+	err := query.Select("topic," + reportCountsSelect).Group("topic").Order("topic").Scan(&stats).Error
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to query topic stats: %w", err)
@@ -75,7 +107,20 @@ func GetTopicStats(startDate, endDate time.Time, topicFilter string) ([]TopicSta
 	return stats, nil
 }
 
-func GetTimelineStats(startDate, endDate time.Time, aggregation string, topic string) ([]TimelineEntry, error) {
+func GetLocaleStats(startDate, endDate time.Time, filters ReportFilters) ([]LocaleStats, error) {
+	query := applyReportFilters(DB.Model(&Message{}), startDate, endDate, filters)
+
+	var stats []LocaleStats
+	err := query.Select("COALESCE(locale, '') as locale," + reportCountsSelect).Group("locale").Order("locale").Scan(&stats).Error
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to query locale stats: %w", err)
+	}
+
+	return stats, nil
+}
+
+func GetTimelineStats(startDate, endDate time.Time, aggregation string, filters ReportFilters) ([]TimelineEntry, error) {
 	var dateFormat string
 
 	if IsSQLite() {
@@ -102,23 +147,10 @@ func GetTimelineStats(startDate, endDate time.Time, aggregation string, topic st
 		}
 	}
 
-	query := DB.Model(&Message{}).
-		Where("created_at >= ? AND created_at <= ?", startDate, endDate)
-
-	if topic != "" {
-		query = query.Where("topic = ?", topic)
-	}
+	query := applyReportFilters(DB.Model(&Message{}), startDate, endDate, filters)
 
 	var timeline []TimelineEntry
-	selectQuery := fmt.Sprintf(`
-		%s as date,
-		COUNT(*) as total,
-		SUM(CASE WHEN status = 'sent' THEN 1 ELSE 0 END) as sent,
-		SUM(CASE WHEN status = 'failed' THEN 1 ELSE 0 END) as failed,
-		SUM(CASE WHEN status = 'pending' THEN 1 ELSE 0 END) as pending
-	`, dateFormat)
-
-	err := query.Select(selectQuery).
+	err := query.Select(dateFormat + " as date," + reportCountsSelect).
 		Group(dateFormat).
 		Order(dateFormat).
 		Scan(&timeline).Error
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type RetryPolicy struct {
+	MaxAttempts    int
+	BaseBackoff    time.Duration
+	MaxBackoff     time.Duration
+	RetryOn        []string
+	NeverRetryOn   []string
+	UseOtherDevice bool
+}
+
+func GetRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    getEnvInt("RETRY_MAX_ATTEMPTS", 1),
+		BaseBackoff:    time.Duration(getEnvInt("RETRY_BACKOFF_SECONDS", 30)) * time.Second,
+		MaxBackoff:     time.Duration(getEnvInt("RETRY_MAX_BACKOFF_SECONDS", 3600)) * time.Second,
+		RetryOn:        getEnvList("RETRY_ON_REASONS", ""),
+		NeverRetryOn:   getEnvList("RETRY_NEVER_REASONS", "invalid number,invalid phone number,blocked"),
+		UseOtherDevice: os.Getenv("RETRY_ON_OTHER_DEVICE") == "true",
+	}
+}
+
+func (p RetryPolicy) ShouldRetry(attempts int, reason string) bool {
+	if attempts >= p.MaxAttempts {
+		return false
+	}
+
+	reason = strings.ToLower(reason)
+
+	for _, never := range p.NeverRetryOn {
+		if strings.Contains(reason, never) {
+			return false
+		}
+	}
+
+	if len(p.RetryOn) == 0 {
+		return true
+	}
+
+	for _, retryOn := range p.RetryOn {
+		if strings.Contains(reason, retryOn) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p RetryPolicy) Backoff(attempts int) time.Duration {
+	backoff := p.BaseBackoff
+	for i := 1; i < attempts && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+
+	return backoff
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.Atoi(valueStr)
+	if err != nil || value < 0 {
+		return defaultValue
+	}
+
+	return value
+}
+
+func getEnvList(key, defaultValue string) []string {
+	value := getEnvWithDefault(key, defaultValue)
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
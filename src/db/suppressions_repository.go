@@ -0,0 +1,134 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const GlobalSuppressionTopic = "*"
+
+var ErrRecipientSuppressed = errors.New("recipient is on the suppression list")
+
+type SuppressionFilters struct {
+	PhoneNumber string
+	Topic       string
+	Limit       int
+	Offset      int
+}
+
+func getOptOutKeywords() []string {
+	return getEnvList("OPT_OUT_KEYWORDS", "stop,stopall,unsubscribe,cancel,end,quit,parar,sair,cancelar")
+}
+
+func getOptOutScope() string {
+	if getEnvWithDefault("OPT_OUT_SCOPE", "global") == "topic" {
+		return "topic"
+	}
+	return "global"
+}
+
+func IsOptOutKeyword(body string) bool {
+	word := strings.ToLower(strings.Trim(strings.TrimSpace(body), ".!"))
+	return slices.Contains(getOptOutKeywords(), word)
+}
+
+func AddSuppression(phoneNumber, topic, reason string) (*Suppression, error) {
+	var suppression *Suppression
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		suppression, err = addSuppression(tx, phoneNumber, topic, reason, nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return suppression, nil
+}
+
+func addSuppression(tx *gorm.DB, phoneNumber, topic, reason string, inboundMessageID *string) (*Suppression, error) {
+	if topic == "" {
+		topic = GlobalSuppressionTopic
+	}
+
+	var existing Suppression
+	err := tx.Where("phone_number = ? AND topic = ?", phoneNumber, topic).First(&existing).Error
+	if err == nil {
+		return &existing, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return nil, fmt.Errorf("failed to query suppression: %w", err)
+	}
+
+	suppression := &Suppression{
+		PhoneNumber:      phoneNumber,
+		Topic:            topic,
+		Reason:           reason,
+		InboundMessageID: inboundMessageID,
+	}
+
+	if err := tx.Create(suppression).Error; err != nil {
+		return nil, fmt.Errorf("failed to create suppression: %w", err)
+	}
+
+	return suppression, nil
+}
+
+func DeleteSuppression(id uint) error {
+	result := DB.Delete(&Suppression{}, id)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete suppression: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func applySuppressionFilters(query *gorm.DB, filters SuppressionFilters) *gorm.DB {
+	if filters.PhoneNumber != "" {
+		query = query.Where("phone_number = ?", filters.PhoneNumber)
+	}
+
+	if filters.Topic != "" {
+		query = query.Where("topic = ?", filters.Topic)
+	}
+
+	return query
+}
+
+func GetSuppressions(filters SuppressionFilters) ([]Suppression, int, error) {
+	query := applySuppressionFilters(DB.Model(&Suppression{}), filters)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count suppressions: %w", err)
+	}
+
+	var suppressions []Suppression
+	err := query.Order("created_at DESC, id DESC").Limit(filters.Limit).Offset(filters.Offset).Find(&suppressions).Error
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query suppressions: %w", err)
+	}
+
+	return suppressions, int(total), nil
+}
+
+func isSuppressed(tx *gorm.DB, phoneNumber, topic string) (bool, error) {
+	var count int64
+	err := tx.Model(&Suppression{}).
+		Where("phone_number = ?", phoneNumber).
+		Where("topic IN ?", []string{topic, GlobalSuppressionTopic}).
+		Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check suppression list: %w", err)
+	}
+	return count > 0, nil
+}
+
+func suppressedRecipientError(toNumber string) error {
+	return fmt.Errorf("%w: %s has opted out of messages", ErrRecipientSuppressed, toNumber)
+}
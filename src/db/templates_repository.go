@@ -0,0 +1,175 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sms-gateway-api/sms"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrTemplateExists = errors.New("template already exists")
+
+func CreateTemplate(topic, name, locale, body string) (*MessageTemplate, error) {
+	var template *MessageTemplate
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(&MessageTemplate{}).
+			Where("topic = ? AND name = ? AND locale = ?", topic, name, locale).
+			Count(&count).Error
+		if err != nil {
+			return fmt.Errorf("failed to check template: %w", err)
+		}
+		if count > 0 {
+			return ErrTemplateExists
+		}
+
+		version, err := nextTemplateVersion(tx, topic, name, locale)
+		if err != nil {
+			return err
+		}
+
+		template = &MessageTemplate{Topic: topic, Name: name, Locale: locale, Version: version, Body: body}
+		if err := tx.Create(template).Error; err != nil {
+			return fmt.Errorf("failed to create template: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+// UpdateTemplate stores body as a new version of the locale variant, adding
+// the variant if the template only exists in other locales. Earlier versions
+// are kept so messages rendered from them stay attributable.
+func UpdateTemplate(topic, name, locale, body string) (*MessageTemplate, error) {
+	var template *MessageTemplate
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		query := tx.Where("topic = ? AND name = ?", topic, name)
+		if !IsSQLite() {
+			query = query.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate})
+		}
+
+		var variants []MessageTemplate
+		if err := query.Find(&variants).Error; err != nil {
+			return fmt.Errorf("failed to query template: %w", err)
+		}
+		if len(variants) == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		version, err := nextTemplateVersion(tx, topic, name, locale)
+		if err != nil {
+			return err
+		}
+
+		template = &MessageTemplate{Topic: topic, Name: name, Locale: locale, Version: version, Body: body}
+		if err := tx.Create(template).Error; err != nil {
+			return fmt.Errorf("failed to create template version: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+// nextTemplateVersion numbers a new version after every earlier one of the
+// locale variant, deleted ones included, so a version is never reused.
+func nextTemplateVersion(tx *gorm.DB, topic, name, locale string) (int, error) {
+	var latest int
+	err := tx.Unscoped().Model(&MessageTemplate{}).
+		Where("topic = ? AND name = ? AND locale = ?", topic, name, locale).
+		Select("COALESCE(MAX(version), 0)").
+		Scan(&latest).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to query template versions: %w", err)
+	}
+	return latest + 1, nil
+}
+
+// GetTemplate returns the given version of a locale variant, or the latest
+// one when version is 0.
+func GetTemplate(topic, name, locale string, version int) (*MessageTemplate, error) {
+	query := DB.Where("topic = ? AND name = ? AND locale = ?", topic, name, locale)
+	if version > 0 {
+		query = query.Where("version = ?", version)
+	}
+
+	var template MessageTemplate
+	err := query.Order("version DESC").First(&template).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query template: %w", err)
+	}
+
+	return &template, nil
+}
+
+// FindTemplate resolves the variant to render for a locale, falling back to
+// the base language and then to the default locale. A pinned version belongs
+// to one variant, so it is only looked up in the requested locale.
+func FindTemplate(topic, name, locale string, version int) (*MessageTemplate, error) {
+	candidates := sms.LocaleFallbacks(locale)
+	if version > 0 {
+		candidates = candidates[:1]
+	}
+
+	for _, candidate := range candidates {
+		template, err := GetTemplate(topic, name, candidate, version)
+		if err != nil || template != nil {
+			return template, err
+		}
+	}
+	return nil, nil
+}
+
+func GetTemplateVersions(topic, name string) ([]MessageTemplate, error) {
+	var versions []MessageTemplate
+	err := DB.Where("topic = ? AND name = ?", topic, name).Order("locale ASC, version DESC").Find(&versions).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query template versions: %w", err)
+	}
+	return versions, nil
+}
+
+// GetTemplates lists the latest version of every locale variant.
+func GetTemplates(topic string) ([]MessageTemplate, error) {
+	latest := DB.Model(&MessageTemplate{}).Select("MAX(id)").Group("topic, name, locale")
+
+	query := DB.Where("id IN (?)", latest)
+	if topic != "" {
+		query = query.Where("topic = ?", topic)
+	}
+
+	var templates []MessageTemplate
+	if err := query.Order("topic ASC, name ASC, locale ASC").Find(&templates).Error; err != nil {
+		return nil, fmt.Errorf("failed to query templates: %w", err)
+	}
+	return templates, nil
+}
+
+// DeleteTemplate removes one locale variant, or every variant when locale is
+// empty. The versions are only marked deleted, so messages rendered from them
+// stay attributable.
+func DeleteTemplate(topic, name, locale string) error {
+	query := DB.Where("topic = ? AND name = ?", topic, name)
+	if locale != "" {
+		query = query.Where("locale = ?", locale)
+	}
+
+	result := query.Delete(&MessageTemplate{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete template: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+var ErrWebhookTargetNotAllowed = errors.New("webhook target is not allowed")
+
+// GetWebhookAllowedHosts lists the hosts that may receive webhooks over plain
+// http or on a private address, such as a receiver on the local network.
+func GetWebhookAllowedHosts() []string {
+	return getEnvList("WEBHOOK_ALLOWED_HOSTS", "")
+}
+
+func IsWebhookHostAllowed(host string) bool {
+	return slices.Contains(GetWebhookAllowedHosts(), strings.ToLower(host))
+}
+
+// IsPublicWebhookIP reports whether ip may receive webhooks. Loopback, private
+// and link-local addresses are refused so that webhooks cannot be used to
+// reach services on the gateway's own network.
+func IsPublicWebhookIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsUnspecified()
+}
+
+// ValidateWebhookURL checks a subscription or callback URL: it must use https
+// and must not point at a local or private address, unless its host is listed
+// in WEBHOOK_ALLOWED_HOSTS. Host names are checked again once they are
+// resolved, when the webhook is sent.
+func ValidateWebhookURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Hostname() == "" {
+		return fmt.Errorf("%w: must be an absolute URL", ErrWebhookTargetNotAllowed)
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	if IsWebhookHostAllowed(host) {
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return fmt.Errorf("%w: must use http or https", ErrWebhookTargetNotAllowed)
+		}
+		return nil
+	}
+
+	if parsed.Scheme != "https" {
+		return fmt.Errorf("%w: must use https", ErrWebhookTargetNotAllowed)
+	}
+
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s is a local host", ErrWebhookTargetNotAllowed, host)
+	}
+
+	if ip := net.ParseIP(host); ip != nil && !IsPublicWebhookIP(ip) {
+		return fmt.Errorf("%w: %s is not a public address", ErrWebhookTargetNotAllowed, host)
+	}
+
+	return nil
+}
@@ -0,0 +1,333 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	WebhookMessageSent        = "message.sent"
+	WebhookMessageFailed      = "message.failed"
+	WebhookMessageDelivered   = "message.delivered"
+	WebhookMessageUndelivered = "message.undelivered"
+	WebhookMessageReceived    = "message.received"
+	WebhookDeviceOffline      = "device.offline"
+	WebhookDeviceOnline       = "device.online"
+)
+
+var WebhookEvents = []string{
+	WebhookMessageSent,
+	WebhookMessageFailed,
+	WebhookMessageDelivered,
+	WebhookMessageUndelivered,
+	WebhookMessageReceived,
+	WebhookDeviceOffline,
+	WebhookDeviceOnline,
+}
+
+type WebhookPayload struct {
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+type MessageWebhookData struct {
+	MessageID string  `json:"message_id"`
+	Topic     string  `json:"topic"`
+	ToNumber  string  `json:"to_number"`
+	Status    string  `json:"status"`
+	Reason    *string `json:"reason,omitempty"`
+	DeviceID  *uint   `json:"device_id,omitempty"`
+}
+
+type DeviceWebhookData struct {
+	DeviceID         uint       `json:"device_id"`
+	Name             *string    `json:"name,omitempty"`
+	Topics           []string   `json:"topics"`
+	LastPollAt       *time.Time `json:"last_poll_at,omitempty"`
+	ReleasedMessages int        `json:"released_messages"`
+}
+
+var ErrWebhookSigningDisabled = errors.New("WEBHOOK_SIGNING_SECRET is not set")
+
+// GetWebhookSigningSecret returns the key every webhook call is signed with.
+// Webhooks are refused while it is empty, so a receiver can always verify
+// that a call came from the gateway.
+func GetWebhookSigningSecret() string {
+	return os.Getenv("WEBHOOK_SIGNING_SECRET")
+}
+
+func GetWebhookPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		BaseBackoff: time.Duration(getEnvInt("WEBHOOK_BACKOFF_SECONDS", 30)) * time.Second,
+		MaxBackoff:  time.Duration(getEnvInt("WEBHOOK_MAX_BACKOFF_SECONDS", 3600)) * time.Second,
+	}
+}
+
+func CreateWebhookSubscription(topic, url string, events []string) (*WebhookSubscription, error) {
+	subscription := &WebhookSubscription{
+		Topic:  topic,
+		URL:    url,
+		Events: strings.Join(events, ","),
+	}
+
+	if err := DB.Create(subscription).Error; err != nil {
+		return nil, fmt.Errorf("failed to create webhook subscription: %w", err)
+	}
+
+	return subscription, nil
+}
+
+func GetWebhookSubscriptions() ([]WebhookSubscription, error) {
+	var subscriptions []WebhookSubscription
+	if err := DB.Order("id ASC").Find(&subscriptions).Error; err != nil {
+		return nil, fmt.Errorf("failed to query webhook subscriptions: %w", err)
+	}
+	return subscriptions, nil
+}
+
+func DeleteWebhookSubscription(id uint) error {
+	result := DB.Delete(&WebhookSubscription{}, id)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete webhook subscription: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (s WebhookSubscription) EventList() []string {
+	if s.Events == "" {
+		return nil
+	}
+	return strings.Split(s.Events, ",")
+}
+
+func (s WebhookSubscription) wants(event string) bool {
+	events := s.EventList()
+	return len(events) == 0 || slices.Contains(events, event)
+}
+
+func enqueueMessageWebhooks(tx *gorm.DB, message Message, status string, reason *string) error {
+	data := MessageWebhookData{
+		MessageID: message.ID,
+		Topic:     message.Topic,
+		ToNumber:  message.ToNumber,
+		Status:    status,
+		Reason:    reason,
+		DeviceID:  message.AssignedDeviceID,
+	}
+
+	return enqueueWebhookEvent(tx, message.Topic, &message.ID, message.CallbackURL, "message."+status, data)
+}
+
+// enqueueDeviceWebhooks notifies subscribers of any topic the device serves.
+func enqueueDeviceWebhooks(tx *gorm.DB, device Device, event string, releasedMessages int) error {
+	topics, err := getDeviceTopics(tx, device.ID)
+	if err != nil {
+		return err
+	}
+
+	data := DeviceWebhookData{
+		DeviceID:         device.ID,
+		Name:             device.Name,
+		Topics:           topics,
+		LastPollAt:       device.LastPollAt,
+		ReleasedMessages: releasedMessages,
+	}
+
+	return enqueueTopicsWebhookEvent(tx, topics, nil, nil, event, data)
+}
+
+func enqueueWebhookEvent(tx *gorm.DB, topic string, messageID *string, callbackURL *string, event string, data interface{}) error {
+	return enqueueTopicsWebhookEvent(tx, []string{topic}, messageID, callbackURL, event, data)
+}
+
+func enqueueTopicsWebhookEvent(tx *gorm.DB, topics []string, messageID *string, callbackURL *string, event string, data interface{}) error {
+	var subscriptions []WebhookSubscription
+	err := tx.Where("topic IN ?", append(slices.Clone(topics), "*")).Order("id ASC").Find(&subscriptions).Error
+	if err != nil {
+		return fmt.Errorf("failed to query webhook subscriptions: %w", err)
+	}
+
+	now := time.Now().UTC()
+	payload, err := json.Marshal(WebhookPayload{Event: event, CreatedAt: now, Data: data})
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+
+	var deliveries []WebhookDelivery
+	for _, subscription := range subscriptions {
+		if !subscription.wants(event) {
+			continue
+		}
+		deliveries = append(deliveries, WebhookDelivery{
+			SubscriptionID: &subscription.ID,
+			MessageID:      messageID,
+			Event:          event,
+			URL:            subscription.URL,
+			Payload:        string(payload),
+			Status:         "pending",
+			NextAttemptAt:  now,
+		})
+	}
+
+	if callbackURL != nil && *callbackURL != "" {
+		deliveries = append(deliveries, WebhookDelivery{
+			MessageID:     messageID,
+			Event:         event,
+			URL:           *callbackURL,
+			Payload:       string(payload),
+			Status:        "pending",
+			NextAttemptAt: now,
+		})
+	}
+
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	if err := tx.Create(&deliveries).Error; err != nil {
+		return fmt.Errorf("failed to enqueue webhook deliveries: %w", err)
+	}
+
+	return nil
+}
+
+func ClaimDueWebhookDeliveries(limit int, claimFor time.Duration) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now().UTC()
+
+		query := tx.Where("status = ?", "pending").
+			Where("next_attempt_at <= ?", now).
+			Order("next_attempt_at ASC, id ASC").
+			Limit(limit)
+
+		if !IsSQLite() {
+			query = query.Clauses(clause.Locking{
+				Strength: clause.LockingStrengthUpdate,
+				Options:  clause.LockingOptionsSkipLocked,
+			})
+		}
+
+		if err := query.Find(&deliveries).Error; err != nil {
+			return err
+		}
+
+		if len(deliveries) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, len(deliveries))
+		for i, delivery := range deliveries {
+			ids[i] = delivery.ID
+		}
+
+		return tx.Model(&WebhookDelivery{}).
+			Where("id IN ?", ids).
+			Update("next_attempt_at", now.Add(claimFor)).Error
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim webhook deliveries: %w", err)
+	}
+
+	return deliveries, nil
+}
+
+func MarkWebhookDelivered(id uint, statusCode int) error {
+	err := DB.Model(&WebhookDelivery{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":           "delivered",
+		"attempts":         gorm.Expr("attempts + 1"),
+		"last_status_code": statusCode,
+		"last_error":       nil,
+		"delivered_at":     time.Now().UTC(),
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to mark webhook delivered: %w", err)
+	}
+	return nil
+}
+
+func MarkWebhookFailed(id uint, statusCode *int, reason string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var delivery WebhookDelivery
+		if err := tx.Where("id = ?", id).First(&delivery).Error; err != nil {
+			return err
+		}
+
+		policy := GetWebhookPolicy()
+		attempts := delivery.Attempts + 1
+		updates := map[string]interface{}{
+			"attempts":         attempts,
+			"last_status_code": statusCode,
+			"last_error":       reason,
+		}
+
+		if attempts >= policy.MaxAttempts {
+			updates["status"] = "dead"
+		} else {
+			updates["next_attempt_at"] = time.Now().UTC().Add(policy.Backoff(attempts))
+		}
+
+		if err := tx.Model(&delivery).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to mark webhook failed: %w", err)
+		}
+
+		return nil
+	})
+}
+
+func GetWebhookDeliveries(status string, limit, offset int) ([]WebhookDelivery, int64, error) {
+	query := DB.Model(&WebhookDelivery{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count webhook deliveries: %w", err)
+	}
+
+	var deliveries []WebhookDelivery
+	err := query.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&deliveries).Error
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query webhook deliveries: %w", err)
+	}
+
+	return deliveries, total, nil
+}
+
+func RetryWebhookDelivery(id uint) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	if err := DB.Where("id = ?", id).First(&delivery).Error; err != nil {
+		return nil, err
+	}
+
+	err := DB.Model(&delivery).Updates(map[string]interface{}{
+		"status":          "pending",
+		"attempts":        0,
+		"next_attempt_at": time.Now().UTC(),
+	}).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to retry webhook delivery: %w", err)
+	}
+
+	if err := DB.Where("id = ?", id).First(&delivery).Error; err != nil {
+		return nil, fmt.Errorf("failed to reload webhook delivery: %w", err)
+	}
+
+	return &delivery, nil
+}
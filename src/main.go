@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
 	"sms-gateway-api/db"
 	"sms-gateway-api/rest"
+	"sms-gateway-api/worker"
+	_ "time/tzdata"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -32,12 +36,35 @@ func main() {
 		log.Printf("Database schema version: %d", version)
 	}
 
+	// Re-running normalization is needed after DEFAULT_COUNTRY_CODE changes,
+	// since migration 5 only ran once with the value set at the time.
+	if len(os.Args) > 1 && os.Args[1] == "normalize-phone-numbers" {
+		updated, err := db.NormalizeStoredPhoneNumbers()
+		if err != nil {
+			log.Fatalf("Failed to normalize phone numbers: %v", err)
+		}
+		log.Printf("Normalized %d phone numbers", updated)
+		return
+	}
+
+	if os.Getenv("ADMIN_API_KEY") == "" {
+		log.Println("Warning: ADMIN_API_KEY is not set; API keys can only be created by existing admin keys")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go worker.RunExpirySweeper(ctx)
+	go worker.RunWebhookDispatcher(ctx)
+	go worker.RunDeviceMonitor(ctx)
+
 	app := fiber.New()
 
 	app.Use(cors.New(cors.Config{
-		AllowOrigins: "*",
-		AllowHeaders: "Origin, Content-Type, Accept, Authorization, X-Device-Key",
-		AllowMethods: "GET, POST, PUT, DELETE, OPTIONS",
+		AllowOrigins:  "*",
+		AllowHeaders:  "Origin, Content-Type, Accept, Authorization, X-Device-Key, Idempotency-Key",
+		AllowMethods:  "GET, POST, PUT, PATCH, DELETE, OPTIONS",
+		ExposeHeaders: "Idempotent-Replayed",
 	}))
 
 	rest.Init(app)
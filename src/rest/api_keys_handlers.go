@@ -0,0 +1,98 @@
+package rest
+
+import (
+	"slices"
+	"sms-gateway-api/db"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+func CreateAPIKeyHandler(c *fiber.Ctx) error {
+	var req CreateAPIKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ReturnBadRequest(c, "Invalid request body")
+	}
+
+	if req.Name == "" {
+		return ReturnBadRequest(c, "name is required")
+	}
+
+	if len(req.Scopes) == 0 {
+		return ReturnBadRequest(c, "scopes is required")
+	}
+
+	for _, scope := range req.Scopes {
+		if !slices.Contains(db.APIKeyScopes, scope) {
+			return ReturnBadRequest(c, "Invalid scope '"+scope+"'. Must be one of: "+strings.Join(db.APIKeyScopes, ", "))
+		}
+	}
+
+	for _, topic := range req.Topics {
+		if topic == "" || strings.Contains(topic, ",") {
+			return ReturnBadRequest(c, "topics must be non-empty and must not contain commas")
+		}
+	}
+
+	key, plaintext, err := db.CreateAPIKey(req.Name, req.Scopes, req.Topics)
+	if err != nil {
+		return ReturnInternalError(c, "Failed to create API key")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(CreateAPIKeyResponse{
+		APIKeyDetail: toAPIKeyDetail(*key),
+		Key:          plaintext,
+	})
+}
+
+func ListAPIKeysHandler(c *fiber.Ctx) error {
+	keys, err := db.GetAPIKeys()
+	if err != nil {
+		return ReturnInternalError(c, "Failed to retrieve API keys")
+	}
+
+	details := make([]APIKeyDetail, len(keys))
+	for i, key := range keys {
+		details[i] = toAPIKeyDetail(key)
+	}
+
+	return c.JSON(details)
+}
+
+func RevokeAPIKeyHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id < 1 {
+		return ReturnBadRequest(c, "Invalid API key id")
+	}
+
+	err = db.RevokeAPIKey(uint(id))
+	if err == gorm.ErrRecordNotFound {
+		return ReturnNotFound(c, "API key not found")
+	}
+	if err != nil {
+		return ReturnInternalError(c, "Failed to revoke API key")
+	}
+
+	return c.JSON(SuccessResponse{
+		Message: "API key revoked",
+	})
+}
+
+func toAPIKeyDetail(key db.APIKey) APIKeyDetail {
+	topics := key.TopicList()
+	if topics == nil {
+		topics = []string{}
+	}
+
+	return APIKeyDetail{
+		ID:         key.ID,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     key.ScopeList(),
+		Topics:     topics,
+		CreatedAt:  key.CreatedAt,
+		LastUsedAt: key.LastUsedAt,
+		RevokedAt:  key.RevokedAt,
+	}
+}
@@ -0,0 +1,245 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"sms-gateway-api/db"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func setupAPIKeysTestApp() *fiber.App {
+	app := fiber.New()
+	app.Post("/messages", RequireAPIKey(db.ScopeSend), QueueSMSHandler)
+	app.Get("/messages", RequireAPIKey(db.ScopeRead), ListMessagesHandler)
+	app.Get("/messages/:id", RequireAPIKey(db.ScopeRead), GetMessageHandler)
+	app.Get("/reports", RequireAPIKey(db.ScopeReport), GetReportsHandler)
+	app.Post("/api-keys", RequireAPIKey(db.ScopeAdmin), CreateAPIKeyHandler)
+	app.Get("/api-keys", RequireAPIKey(db.ScopeAdmin), ListAPIKeysHandler)
+	app.Delete("/api-keys/:id", RequireAPIKey(db.ScopeAdmin), RevokeAPIKeyHandler)
+	return app
+}
+
+func TestAPIKeyHandlers(t *testing.T) {
+	setupTestDB(t)
+	defer teardownTestDB()
+
+	t.Setenv("ADMIN_API_KEY", "bootstrap-secret")
+
+	app := setupAPIKeysTestApp()
+
+	_, otpKey, err := db.CreateAPIKey("otp-service", []string{db.ScopeSend, db.ScopeRead}, []string{"otp"})
+	if err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+	_, revokedKey, err := db.CreateAPIKey("old-service", []string{db.ScopeSend}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+	if err := db.RevokeAPIKey(2); err != nil {
+		t.Fatalf("Failed to revoke API key: %v", err)
+	}
+
+	marketing, err := db.CreateMessage("marketing", "+258841234567", "Weekend sale!")
+	if err != nil {
+		t.Fatalf("Failed to create message: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		key            string
+		payload        interface{}
+		expectedStatus int
+		checkResponse  func(t *testing.T, body []byte)
+	}{
+		{
+			name:           "Missing key",
+			method:         "POST",
+			path:           "/messages",
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "+258841234567", Body: "Your OTP is 123456"},
+			expectedStatus: fiber.StatusUnauthorized,
+		},
+		{
+			name:           "Unknown key",
+			method:         "POST",
+			path:           "/messages",
+			key:            "sgw_unknown",
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "+258841234567", Body: "Your OTP is 123456"},
+			expectedStatus: fiber.StatusUnauthorized,
+		},
+		{
+			name:           "Revoked key",
+			method:         "POST",
+			path:           "/messages",
+			key:            revokedKey,
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "+258841234567", Body: "Your OTP is 123456"},
+			expectedStatus: fiber.StatusUnauthorized,
+		},
+		{
+			name:           "Send to allowed topic",
+			method:         "POST",
+			path:           "/messages",
+			key:            otpKey,
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "+258841234567", Body: "Your OTP is 123456"},
+			expectedStatus: fiber.StatusCreated,
+		},
+		{
+			name:           "Send to other topic",
+			method:         "POST",
+			path:           "/messages",
+			key:            otpKey,
+			payload:        QueueSMSRequest{Topic: "marketing", ToNumber: "+258841234567", Body: "Weekend sale!"},
+			expectedStatus: fiber.StatusForbidden,
+		},
+		{
+			name:           "List only returns allowed topics",
+			method:         "GET",
+			path:           "/messages",
+			key:            otpKey,
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response MessagesListResponse
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.Pagination.Total != 1 || response.Data[0].Topic != "otp" {
+					t.Errorf("Expected only the otp message, got %+v", response.Data)
+				}
+			},
+		},
+		{
+			name:           "Message of other topic is hidden",
+			method:         "GET",
+			path:           "/messages/" + marketing.ID,
+			key:            otpKey,
+			expectedStatus: fiber.StatusNotFound,
+		},
+		{
+			name:           "Missing scope",
+			method:         "GET",
+			path:           "/reports",
+			key:            otpKey,
+			expectedStatus: fiber.StatusForbidden,
+		},
+		{
+			name:           "Non-admin cannot manage keys",
+			method:         "GET",
+			path:           "/api-keys",
+			key:            otpKey,
+			expectedStatus: fiber.StatusForbidden,
+		},
+		{
+			name:           "Bootstrap key creates a key",
+			method:         "POST",
+			path:           "/api-keys",
+			key:            "bootstrap-secret",
+			payload:        CreateAPIKeyRequest{Name: "reporting", Scopes: []string{db.ScopeReport}},
+			expectedStatus: fiber.StatusCreated,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response CreateAPIKeyResponse
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.Key == "" || response.Prefix == "" || response.Key[:len(response.Prefix)] != response.Prefix {
+					t.Errorf("Unexpected key: %+v", response)
+				}
+			},
+		},
+		{
+			name:           "Invalid scope",
+			method:         "POST",
+			path:           "/api-keys",
+			key:            "bootstrap-secret",
+			payload:        CreateAPIKeyRequest{Name: "broken", Scopes: []string{"everything"}},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "List keys does not expose secrets",
+			method:         "GET",
+			path:           "/api-keys",
+			key:            "bootstrap-secret",
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response []map[string]interface{}
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if len(response) != 3 {
+					t.Fatalf("Expected 3 keys, got %d", len(response))
+				}
+				for _, key := range response {
+					if _, ok := key["key"]; ok {
+						t.Errorf("Expected no plaintext key in list, got %+v", key)
+					}
+				}
+				if response[0]["last_used_at"] == nil {
+					t.Error("Expected last_used_at to be set for a used key")
+				}
+			},
+		},
+		{
+			name:           "Revoke key",
+			method:         "DELETE",
+			path:           "/api-keys/1",
+			key:            "bootstrap-secret",
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Revoked key is rejected",
+			method:         "GET",
+			path:           "/messages",
+			key:            otpKey,
+			expectedStatus: fiber.StatusUnauthorized,
+		},
+		{
+			name:           "Revoke already revoked key",
+			method:         "DELETE",
+			path:           "/api-keys/1",
+			key:            "bootstrap-secret",
+			expectedStatus: fiber.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bodyReader io.Reader
+			if tt.payload != nil {
+				bodyBytes, err := json.Marshal(tt.payload)
+				if err != nil {
+					t.Fatalf("Failed to marshal payload: %v", err)
+				}
+				bodyReader = bytes.NewReader(bodyBytes)
+			}
+
+			req := httptest.NewRequest(tt.method, tt.path, bodyReader)
+			req.Header.Set("Content-Type", "application/json")
+			if tt.key != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.key)
+			}
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Failed to perform request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read response body: %v", err)
+			}
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d. Response: %s", tt.expectedStatus, resp.StatusCode, string(body))
+			}
+
+			if tt.checkResponse != nil {
+				tt.checkResponse(t, body)
+			}
+		})
+	}
+}
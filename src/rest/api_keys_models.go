@@ -0,0 +1,25 @@
+package rest
+
+import "time"
+
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name" validate:"required"`
+	Scopes []string `json:"scopes" validate:"required"`
+	Topics []string `json:"topics,omitempty"`
+}
+
+type APIKeyDetail struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	Topics     []string   `json:"topics"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+type CreateAPIKeyResponse struct {
+	APIKeyDetail
+	Key string `json:"key"`
+}
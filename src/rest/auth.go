@@ -0,0 +1,106 @@
+package rest
+
+import (
+	"fmt"
+	"sms-gateway-api/db"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const apiKeyLocal = "apiKey"
+
+// RequireAPIKey authenticates the Authorization: Bearer key and checks that
+// it grants scope. The key is stored in the request locals for topic checks.
+func RequireAPIKey(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token := bearerToken(c)
+		if token == "" {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid or missing API key",
+			})
+		}
+
+		key, err := db.AuthenticateAPIKey(token)
+		if err != nil {
+			return ReturnInternalError(c, "Failed to authenticate API key")
+		}
+		if key == nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid or missing API key",
+			})
+		}
+
+		if !key.HasScope(scope) {
+			return ReturnForbidden(c, fmt.Sprintf("API key does not have the '%s' scope", scope))
+		}
+
+		c.Locals(apiKeyLocal, key)
+		return c.Next()
+	}
+}
+
+func bearerToken(c *fiber.Ctx) string {
+	header := c.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[7:])
+}
+
+// requestAPIKey is nil for routes mounted without RequireAPIKey.
+func requestAPIKey(c *fiber.Ctx) *db.APIKey {
+	key, _ := c.Locals(apiKeyLocal).(*db.APIKey)
+	return key
+}
+
+func topicAllowed(c *fiber.Ctx, topic string) bool {
+	key := requestAPIKey(c)
+	return key == nil || key.AllowsTopic(topic)
+}
+
+// allowedTopics returns nil when the request may see every topic.
+func allowedTopics(c *fiber.Ctx) []string {
+	key := requestAPIKey(c)
+	if key == nil {
+		return nil
+	}
+	return key.TopicList()
+}
+
+func returnTopicForbidden(c *fiber.Ctx, topic string) error {
+	return ReturnForbidden(c, fmt.Sprintf("API key is not allowed to use topic '%s'", topic))
+}
+
+// authenticateDevice resolves the X-Device-Key header. When the returned
+// device is nil the error response has already been sent and the handler
+// should return err.
+func authenticateDevice(c *fiber.Ctx) (*db.Device, error) {
+	deviceKey := c.Get("X-Device-Key")
+	if deviceKey == "" {
+		return nil, c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or missing device key",
+		})
+	}
+
+	device, err := db.GetDeviceByKey(deviceKey)
+	if err != nil {
+		return nil, ReturnInternalError(c, "Failed to authenticate device")
+	}
+
+	if device == nil {
+		return nil, c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or missing device key",
+		})
+	}
+
+	if device.Disabled {
+		return nil, returnDeviceDisabled(c)
+	}
+
+	return device, nil
+}
+
+func returnDeviceDisabled(c *fiber.Ctx) error {
+	return ReturnForbidden(c, "Device is disabled")
+}
@@ -1,9 +1,15 @@
 package rest
 
 import (
+	"errors"
+	"math"
+	"slices"
 	"sms-gateway-api/db"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 func UpdateDeviceTopicsHandler(c *fiber.Ctx) error {
@@ -20,12 +26,22 @@ func UpdateDeviceTopicsHandler(c *fiber.Ctx) error {
 	}
 
 	if device == nil {
+		if !db.DeviceAutoRegisterEnabled() {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid or missing device key",
+			})
+		}
+
 		device, err = db.CreateDevice(deviceKey, nil)
 		if err != nil {
 			return ReturnInternalError(c, "Failed to create device")
 		}
 	}
 
+	if device.Disabled {
+		return returnDeviceDisabled(c)
+	}
+
 	var req DeviceConfigRequest
 	if err := c.BodyParser(&req); err != nil {
 		return ReturnBadRequest(c, "Invalid request body")
@@ -35,6 +51,27 @@ func UpdateDeviceTopicsHandler(c *fiber.Ctx) error {
 		return ReturnBadRequest(c, "topics is required")
 	}
 
+	// Topics are assigned by an administrator, otherwise any enrolled device
+	// could subscribe itself to otp. Repeating the assigned topics is
+	// accepted so devices that send their configuration on startup keep
+	// working. Auto-registered development devices still pick their own.
+	if !db.DeviceAutoRegisterEnabled() {
+		topics, err := db.GetDeviceTopics(device.ID)
+		if err != nil {
+			return ReturnInternalError(c, "Failed to retrieve device topics")
+		}
+
+		assigned, _ := normalizeDeviceTopics(topics)
+		requested, ok := normalizeDeviceTopics(req.Topics)
+		if !ok || !slices.Equal(assigned, requested) {
+			return ReturnForbidden(c, "Device topics are assigned by an administrator")
+		}
+
+		return c.JSON(SuccessResponse{
+			Message: "Device configuration unchanged",
+		})
+	}
+
 	if err := db.SetDeviceTopics(device.ID, req.Topics); err != nil {
 		return ReturnInternalError(c, "Failed to update device topics")
 	}
@@ -47,32 +84,354 @@ func UpdateDeviceTopicsHandler(c *fiber.Ctx) error {
 }
 
 func GetDeviceTopicsHandler(c *fiber.Ctx) error {
-	deviceKey := c.Get("X-Device-Key")
-	if deviceKey == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Invalid or missing device key",
-		})
+	device, err := authenticateDevice(c)
+	if device == nil {
+		return err
 	}
 
-	device, err := db.GetDeviceByKey(deviceKey)
+	topics, err := db.GetDeviceTopics(device.ID)
 	if err != nil {
-		return ReturnInternalError(c, "Failed to authenticate device")
+		return ReturnInternalError(c, "Failed to retrieve device topics")
 	}
 
-	if device == nil {
+	response := DeviceConfigRequest{
+		Topics: topics,
+	}
+
+	return c.JSON(response)
+}
+
+func EnrollDeviceHandler(c *fiber.Ctx) error {
+	var req EnrollDeviceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ReturnBadRequest(c, "Invalid request body")
+	}
+
+	topics, ok := normalizeDeviceTopics(req.Topics)
+	if !ok {
+		return ReturnBadRequest(c, "topics must be non-empty and must not contain commas")
+	}
+
+	device, err := db.EnrollDevice(req.Name, topics)
+	if err != nil {
+		return ReturnInternalError(c, "Failed to create device")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(EnrolledDeviceResponse{
+		ID:        device.ID,
+		Name:      device.Name,
+		DeviceKey: device.DeviceKey,
+		Topics:    topics,
+	})
+}
+
+func CreatePairingCodeHandler(c *fiber.Ctx) error {
+	var req EnrollDeviceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ReturnBadRequest(c, "Invalid request body")
+	}
+
+	topics, ok := normalizeDeviceTopics(req.Topics)
+	if !ok {
+		return ReturnBadRequest(c, "topics must be non-empty and must not contain commas")
+	}
+
+	pairingCode, err := db.CreatePairingCode(req.Name, topics)
+	if err != nil {
+		return ReturnInternalError(c, "Failed to create pairing code")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(PairingCodeResponse{
+		Code:      pairingCode.Code,
+		Name:      pairingCode.Name,
+		Topics:    topics,
+		ExpiresAt: pairingCode.ExpiresAt,
+	})
+}
+
+func PairDeviceHandler(c *fiber.Ctx) error {
+	var req PairDeviceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ReturnBadRequest(c, "Invalid request body")
+	}
+
+	if req.Code == "" {
+		return ReturnBadRequest(c, "code is required")
+	}
+
+	device, err := db.RedeemPairingCode(req.Code, req.Name)
+	if errors.Is(err, db.ErrInvalidPairingCode) {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Invalid or missing device key",
+			"error": "Invalid or expired pairing code",
 		})
 	}
+	if err != nil {
+		return ReturnInternalError(c, "Failed to pair device")
+	}
 
 	topics, err := db.GetDeviceTopics(device.ID)
 	if err != nil {
 		return ReturnInternalError(c, "Failed to retrieve device topics")
 	}
 
-	response := DeviceConfigRequest{
-		Topics: topics,
+	return c.Status(fiber.StatusCreated).JSON(EnrolledDeviceResponse{
+		ID:        device.ID,
+		Name:      device.Name,
+		DeviceKey: device.DeviceKey,
+		Topics:    topics,
+	})
+}
+
+// normalizeDeviceTopics sorts and de-duplicates topics. Commas are rejected
+// because pairing codes store their topics as a comma separated list.
+func normalizeDeviceTopics(topics []string) ([]string, bool) {
+	normalized := []string{}
+	for _, topic := range topics {
+		if topic == "" || strings.Contains(topic, ",") {
+			return nil, false
+		}
+		normalized = append(normalized, topic)
 	}
+	slices.Sort(normalized)
+	return slices.Compact(normalized), true
+}
 
-	return c.JSON(response)
+func ListDevicesHandler(c *fiber.Ctx) error {
+	devices, err := db.GetDevices()
+	if err != nil {
+		return ReturnInternalError(c, "Failed to retrieve devices")
+	}
+
+	inFlight, err := db.CountInFlightMessages()
+	if err != nil {
+		return ReturnInternalError(c, "Failed to retrieve devices")
+	}
+
+	details := make([]DeviceDetail, len(devices))
+	for i, device := range devices {
+		details[i] = toDeviceDetail(device, inFlight[device.ID])
+	}
+
+	return c.JSON(details)
+}
+
+func GetDeviceHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id < 1 {
+		return ReturnBadRequest(c, "Invalid device id")
+	}
+
+	device, err := db.GetDeviceByID(uint(id))
+	if err != nil {
+		return ReturnInternalError(c, "Failed to retrieve device")
+	}
+	if device == nil {
+		return ReturnNotFound(c, "Device not found")
+	}
+
+	return deviceDetailResponse(c, *device)
+}
+
+func UpdateDeviceHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id < 1 {
+		return ReturnBadRequest(c, "Invalid device id")
+	}
+
+	var req UpdateDeviceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ReturnBadRequest(c, "Invalid request body")
+	}
+
+	if req.Name == nil && req.Disabled == nil && req.Topics == nil {
+		return ReturnBadRequest(c, "At least one of name, disabled or topics is required")
+	}
+
+	var topics []string
+	if req.Topics != nil {
+		var ok bool
+		topics, ok = normalizeDeviceTopics(req.Topics)
+		if !ok {
+			return ReturnBadRequest(c, "topics must be non-empty and must not contain commas")
+		}
+	}
+
+	device, err := db.UpdateDevice(uint(id), db.DeviceChanges{
+		Name:     req.Name,
+		Disabled: req.Disabled,
+		Topics:   topics,
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ReturnNotFound(c, "Device not found")
+	}
+	if err != nil {
+		return ReturnInternalError(c, "Failed to update device")
+	}
+
+	return deviceDetailResponse(c, *device)
+}
+
+func DeleteDeviceHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id < 1 {
+		return ReturnBadRequest(c, "Invalid device id")
+	}
+
+	err = db.DeleteDevice(uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ReturnNotFound(c, "Device not found")
+	}
+	if err != nil {
+		return ReturnInternalError(c, "Failed to delete device")
+	}
+
+	return c.JSON(SuccessResponse{
+		Message: "Device deleted",
+	})
+}
+
+func RotateDeviceKeyHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id < 1 {
+		return ReturnBadRequest(c, "Invalid device id")
+	}
+
+	device, err := db.RotateDeviceKey(uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ReturnNotFound(c, "Device not found")
+	}
+	if err != nil {
+		return ReturnInternalError(c, "Failed to rotate device key")
+	}
+
+	return c.JSON(EnrolledDeviceResponse{
+		ID:        device.ID,
+		Name:      device.Name,
+		DeviceKey: device.DeviceKey,
+		Topics:    device.TopicList(),
+	})
+}
+
+func deviceDetailResponse(c *fiber.Ctx, device db.Device) error {
+	inFlight, err := db.CountInFlightMessages()
+	if err != nil {
+		return ReturnInternalError(c, "Failed to retrieve device")
+	}
+
+	return c.JSON(toDeviceDetail(device, inFlight[device.ID]))
+}
+
+func toDeviceDetail(device db.Device, inFlight int) DeviceDetail {
+	status := "offline"
+	if device.Disabled {
+		status = "disabled"
+	} else if device.IsOnline() {
+		status = "online"
+	}
+
+	detail := DeviceDetail{
+		ID:           device.ID,
+		Name:         device.Name,
+		Topics:       device.TopicList(),
+		Status:       status,
+		Disabled:     device.Disabled,
+		LastPollAt:   device.LastPollAt,
+		OfflineSince: device.OfflineSince,
+		InFlight:     inFlight,
+		CreatedAt:    device.CreatedAt,
+		UpdatedAt:    device.UpdatedAt,
+
+		LastHeartbeatAt: device.LastHeartbeatAt,
+	}
+
+	if device.LastHeartbeatAt != nil {
+		telemetry := toDeviceTelemetry(device.Telemetry)
+		detail.Telemetry = &telemetry
+	}
+
+	return detail
+}
+
+func ListDeviceHeartbeatsHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id < 1 {
+		return ReturnBadRequest(c, "Invalid device id")
+	}
+
+	page := c.QueryInt("page", 1)
+	if page < 1 {
+		page = 1
+	}
+
+	limit := c.QueryInt("limit", 100)
+	if limit < 1 {
+		limit = 100
+	}
+	if limit > 1000 {
+		limit = 1000
+	}
+
+	var since *time.Time
+	if sinceStr := c.Query("since"); sinceStr != "" {
+		t, err := parseFlexibleDate(sinceStr, false)
+		if err != nil {
+			return ReturnBadRequest(c, "Invalid since format. Use ISO 8601 format (e.g., 2026-01-01T00:00:00Z or 2026-01-01)")
+		}
+		since = &t
+	}
+
+	device, err := db.GetDeviceByID(uint(id))
+	if err != nil {
+		return ReturnInternalError(c, "Failed to retrieve device")
+	}
+	if device == nil {
+		return ReturnNotFound(c, "Device not found")
+	}
+
+	heartbeats, total, err := db.GetDeviceHeartbeats(db.HeartbeatFilters{
+		DeviceID: device.ID,
+		Since:    since,
+		Limit:    limit,
+		Offset:   (page - 1) * limit,
+	})
+	if err != nil {
+		return ReturnInternalError(c, "Failed to retrieve heartbeats")
+	}
+
+	details := make([]DeviceHeartbeatDetail, len(heartbeats))
+	for i, heartbeat := range heartbeats {
+		details[i] = DeviceHeartbeatDetail{
+			ID:              heartbeat.ID,
+			CreatedAt:       heartbeat.CreatedAt,
+			DeviceTelemetry: toDeviceTelemetry(heartbeat.Telemetry),
+		}
+	}
+
+	return c.JSON(DeviceHeartbeatsListResponse{
+		Data: details,
+		Pagination: PaginationInfo{
+			Page:       page,
+			Limit:      limit,
+			Total:      total,
+			TotalPages: int(math.Ceil(float64(total) / float64(limit))),
+		},
+	})
+}
+
+func toDeviceTelemetry(telemetry db.DeviceTelemetry) DeviceTelemetry {
+	slots := telemetry.SimSlotList()
+	simSlots := make([]SimSlot, len(slots))
+	for i, slot := range slots {
+		simSlots[i] = SimSlot(slot)
+	}
+
+	return DeviceTelemetry{
+		BatteryLevel:    telemetry.BatteryLevel,
+		Charging:        telemetry.Charging,
+		LowBattery:      telemetry.IsBatteryLow(),
+		SignalStrength:  telemetry.SignalStrength,
+		NetworkOperator: telemetry.NetworkOperator,
+		SimSlots:        simSlots,
+		AppVersion:      telemetry.AppVersion,
+	}
 }
@@ -5,8 +5,11 @@ import (
 	"encoding/json"
 	"io"
 	"net/http/httptest"
+	"slices"
 	"sms-gateway-api/db"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -15,6 +18,14 @@ func setupDevicesTestApp() *fiber.App {
 	app := fiber.New()
 	app.Get("/devices", GetDeviceTopicsHandler)
 	app.Put("/devices", UpdateDeviceTopicsHandler)
+	app.Post("/admin/devices", EnrollDeviceHandler)
+	app.Post("/admin/devices/pairing-codes", CreatePairingCodeHandler)
+	app.Post("/gateway/pair", PairDeviceHandler)
+	app.Get("/admin/devices", ListDevicesHandler)
+	app.Get("/admin/devices/:id", GetDeviceHandler)
+	app.Patch("/admin/devices/:id", UpdateDeviceHandler)
+	app.Delete("/admin/devices/:id", DeleteDeviceHandler)
+	app.Post("/admin/devices/:id/rotate-key", RotateDeviceKeyHandler)
 	return app
 }
 
@@ -37,6 +48,8 @@ func TestUpdateDeviceTopicsHandler(t *testing.T) {
 	setupDevicesTestDB(t)
 	defer teardownTestDB()
 
+	t.Setenv("DEVICE_AUTO_REGISTER", "true")
+
 	app := setupDevicesTestApp()
 
 	tests := []struct {
@@ -289,3 +302,471 @@ func TestGetDeviceTopicsHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestDeviceEnrollment(t *testing.T) {
+	setupDevicesTestDB(t)
+	defer teardownTestDB()
+
+	app := setupDevicesTestApp()
+
+	expired, err := db.CreatePairingCode(nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create pairing code: %v", err)
+	}
+	if err := db.DB.Model(expired).Update("expires_at", time.Now().UTC().Add(-time.Minute)).Error; err != nil {
+		t.Fatalf("Failed to expire pairing code: %v", err)
+	}
+
+	var enrolledKey, pairingCode, pairedKey string
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		deviceKey      func() string
+		payload        func() interface{}
+		expectedStatus int
+		checkResponse  func(t *testing.T, body []byte)
+	}{
+		{
+			name:           "Unknown device key is not registered",
+			method:         "PUT",
+			path:           "/devices",
+			deviceKey:      func() string { return "self_registered_device" },
+			payload:        func() interface{} { return DeviceConfigRequest{Topics: []string{"otp"}} },
+			expectedStatus: fiber.StatusUnauthorized,
+			checkResponse: func(t *testing.T, body []byte) {
+				device, err := db.GetDeviceByKey("self_registered_device")
+				if err != nil {
+					t.Fatalf("Failed to get device: %v", err)
+				}
+				if device != nil {
+					t.Error("Expected unknown device not to be created")
+				}
+			},
+		},
+		{
+			name:   "Admin creates a device",
+			method: "POST",
+			path:   "/admin/devices",
+			payload: func() interface{} {
+				return EnrollDeviceRequest{Name: strPtr("Front desk phone"), Topics: []string{"otp", "alerts", "otp"}}
+			},
+			expectedStatus: fiber.StatusCreated,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response EnrolledDeviceResponse
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.DeviceKey == "" || len(response.Topics) != 2 {
+					t.Errorf("Unexpected device: %+v", response)
+				}
+				enrolledKey = response.DeviceKey
+			},
+		},
+		{
+			name:           "Enrolled device can use its key",
+			method:         "PUT",
+			path:           "/devices",
+			deviceKey:      func() string { return enrolledKey },
+			payload:        func() interface{} { return DeviceConfigRequest{Topics: []string{"alerts", "otp"}} },
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Enrolled device cannot change its topics",
+			method:         "PUT",
+			path:           "/devices",
+			deviceKey:      func() string { return enrolledKey },
+			payload:        func() interface{} { return DeviceConfigRequest{Topics: []string{"alerts", "otp", "marketing"}} },
+			expectedStatus: fiber.StatusForbidden,
+			checkResponse: func(t *testing.T, body []byte) {
+				device, err := db.GetDeviceByKey(enrolledKey)
+				if err != nil {
+					t.Fatalf("Failed to get device: %v", err)
+				}
+				if topics, _ := db.GetDeviceTopics(device.ID); len(topics) != 2 {
+					t.Errorf("Expected the assigned topics to stay, got %v", topics)
+				}
+			},
+		},
+		{
+			name:           "Invalid topics",
+			method:         "POST",
+			path:           "/admin/devices",
+			payload:        func() interface{} { return EnrollDeviceRequest{Topics: []string{"otp,alerts"}} },
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:   "Admin creates a pairing code",
+			method: "POST",
+			path:   "/admin/devices/pairing-codes",
+			payload: func() interface{} {
+				return EnrollDeviceRequest{Name: strPtr("Warehouse phone"), Topics: []string{"otp"}}
+			},
+			expectedStatus: fiber.StatusCreated,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response PairingCodeResponse
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if len(response.Code) != 8 || !response.ExpiresAt.After(time.Now()) {
+					t.Errorf("Unexpected pairing code: %+v", response)
+				}
+				pairingCode = response.Code
+			},
+		},
+		{
+			name:   "Phone exchanges the code for a key",
+			method: "POST",
+			path:   "/gateway/pair",
+			payload: func() interface{} {
+				return PairDeviceRequest{Code: strings.ToLower(pairingCode[:4] + "-" + pairingCode[4:])}
+			},
+			expectedStatus: fiber.StatusCreated,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response EnrolledDeviceResponse
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.DeviceKey == "" || response.Name == nil || *response.Name != "Warehouse phone" {
+					t.Errorf("Unexpected device: %+v", response)
+				}
+				if len(response.Topics) != 1 || response.Topics[0] != "otp" {
+					t.Errorf("Expected topics [otp], got %v", response.Topics)
+				}
+				pairedKey = response.DeviceKey
+			},
+		},
+		{
+			name:           "Paired device can use its key",
+			method:         "GET",
+			path:           "/devices",
+			deviceKey:      func() string { return pairedKey },
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Pairing code cannot be reused",
+			method:         "POST",
+			path:           "/gateway/pair",
+			payload:        func() interface{} { return PairDeviceRequest{Code: pairingCode} },
+			expectedStatus: fiber.StatusUnauthorized,
+		},
+		{
+			name:           "Expired pairing code",
+			method:         "POST",
+			path:           "/gateway/pair",
+			payload:        func() interface{} { return PairDeviceRequest{Code: expired.Code} },
+			expectedStatus: fiber.StatusUnauthorized,
+		},
+		{
+			name:           "Missing pairing code",
+			method:         "POST",
+			path:           "/gateway/pair",
+			payload:        func() interface{} { return PairDeviceRequest{} },
+			expectedStatus: fiber.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bodyReader io.Reader
+			if tt.payload != nil {
+				bodyBytes, err := json.Marshal(tt.payload())
+				if err != nil {
+					t.Fatalf("Failed to marshal payload: %v", err)
+				}
+				bodyReader = bytes.NewReader(bodyBytes)
+			}
+
+			req := httptest.NewRequest(tt.method, tt.path, bodyReader)
+			req.Header.Set("Content-Type", "application/json")
+			if tt.deviceKey != nil {
+				req.Header.Set("X-Device-Key", tt.deviceKey())
+			}
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Failed to perform request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read response body: %v", err)
+			}
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d. Response: %s", tt.expectedStatus, resp.StatusCode, string(body))
+			}
+
+			if tt.checkResponse != nil {
+				tt.checkResponse(t, body)
+			}
+		})
+	}
+}
+
+func TestDeviceAdminHandlers(t *testing.T) {
+	setupDevicesTestDB(t)
+	defer teardownTestDB()
+
+	app := setupDevicesTestApp()
+
+	busy, err := db.CreateDevice("busy_device", strPtr("Front desk phone"))
+	if err != nil {
+		t.Fatalf("Failed to create test device: %v", err)
+	}
+	if err := db.SetDeviceTopics(busy.ID, []string{"otp", "alerts"}); err != nil {
+		t.Fatalf("Failed to set device topics: %v", err)
+	}
+	if _, err := db.CreateDevice("idle_device", nil); err != nil {
+		t.Fatalf("Failed to create test device: %v", err)
+	}
+
+	if _, err := db.CreateMessage("otp", "+258841234567", "Your OTP is 123456"); err != nil {
+		t.Fatalf("Failed to create message: %v", err)
+	}
+	if _, err := db.GetPendingMessagesForDevice(busy.ID, []string{"otp"}, db.PollBatchSize); err != nil {
+		t.Fatalf("Failed to lease messages: %v", err)
+	}
+	if err := db.UpdateDeviceLastPoll(busy.ID); err != nil {
+		t.Fatalf("Failed to update last poll: %v", err)
+	}
+
+	inbound, err := db.CreateInboundMessage(db.InboundParams{
+		DeviceID:   busy.ID,
+		FromNumber: "+258841234567",
+		Body:       "Thanks",
+		ReceivedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create inbound message: %v", err)
+	}
+
+	// Deleting the device must not cascade to its inbound messages.
+	if err := db.GetDB().Exec("PRAGMA foreign_keys = ON").Error; err != nil {
+		t.Fatalf("Failed to enable foreign keys: %v", err)
+	}
+
+	rotatedKey := ""
+
+	checkDevice := func(check func(t *testing.T, device DeviceDetail)) func(t *testing.T, body []byte) {
+		return func(t *testing.T, body []byte) {
+			var response DeviceDetail
+			if err := json.Unmarshal(body, &response); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			check(t, response)
+		}
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		deviceKey      func() string
+		payload        interface{}
+		expectedStatus int
+		checkResponse  func(t *testing.T, body []byte)
+	}{
+		{
+			name:           "List devices",
+			method:         "GET",
+			path:           "/admin/devices",
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response []DeviceDetail
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if len(response) != 2 {
+					t.Fatalf("Expected 2 devices, got %d", len(response))
+				}
+				if response[0].Status != "online" || response[0].InFlight != 1 || len(response[0].Topics) != 2 {
+					t.Errorf("Unexpected busy device: %+v", response[0])
+				}
+				if response[1].Status != "offline" || response[1].InFlight != 0 || response[1].LastPollAt != nil {
+					t.Errorf("Unexpected idle device: %+v", response[1])
+				}
+			},
+		},
+		{
+			name:           "Get unknown device",
+			method:         "GET",
+			path:           "/admin/devices/99",
+			expectedStatus: fiber.StatusNotFound,
+		},
+		{
+			name:           "Rename device",
+			method:         "PATCH",
+			path:           "/admin/devices/1",
+			payload:        UpdateDeviceRequest{Name: strPtr("Back office phone")},
+			expectedStatus: fiber.StatusOK,
+			checkResponse: checkDevice(func(t *testing.T, device DeviceDetail) {
+				if device.Name == nil || *device.Name != "Back office phone" {
+					t.Errorf("Expected device to be renamed, got %+v", device)
+				}
+			}),
+		},
+		{
+			name:           "Empty update",
+			method:         "PATCH",
+			path:           "/admin/devices/1",
+			payload:        map[string]interface{}{},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Assign topics",
+			method:         "PATCH",
+			path:           "/admin/devices/1",
+			payload:        UpdateDeviceRequest{Topics: []string{"otp", "marketing", "otp"}},
+			expectedStatus: fiber.StatusOK,
+			checkResponse: checkDevice(func(t *testing.T, device DeviceDetail) {
+				if !slices.Equal(device.Topics, []string{"marketing", "otp"}) {
+					t.Errorf("Expected topics [marketing otp], got %v", device.Topics)
+				}
+			}),
+		},
+		{
+			name:           "Invalid topics",
+			method:         "PATCH",
+			path:           "/admin/devices/1",
+			payload:        UpdateDeviceRequest{Topics: []string{"otp,alerts"}},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Disable device releases its messages",
+			method:         "PATCH",
+			path:           "/admin/devices/1",
+			payload:        map[string]interface{}{"disabled": true},
+			expectedStatus: fiber.StatusOK,
+			checkResponse: checkDevice(func(t *testing.T, device DeviceDetail) {
+				if device.Status != "disabled" || device.InFlight != 0 {
+					t.Errorf("Expected disabled device without in-flight messages, got %+v", device)
+				}
+			}),
+		},
+		{
+			name:           "Disabled device is rejected",
+			method:         "GET",
+			path:           "/devices",
+			deviceKey:      func() string { return "busy_device" },
+			expectedStatus: fiber.StatusForbidden,
+		},
+		{
+			name:           "Enable device",
+			method:         "PATCH",
+			path:           "/admin/devices/1",
+			payload:        map[string]interface{}{"disabled": false},
+			expectedStatus: fiber.StatusOK,
+			checkResponse: checkDevice(func(t *testing.T, device DeviceDetail) {
+				if device.Disabled || device.Status != "online" {
+					t.Errorf("Expected enabled device, got %+v", device)
+				}
+			}),
+		},
+		{
+			name:           "Enabled device is accepted",
+			method:         "GET",
+			path:           "/devices",
+			deviceKey:      func() string { return "busy_device" },
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Rotate device key",
+			method:         "POST",
+			path:           "/admin/devices/2/rotate-key",
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response EnrolledDeviceResponse
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.DeviceKey == "" || response.DeviceKey == "idle_device" {
+					t.Errorf("Expected a new device key, got '%s'", response.DeviceKey)
+				}
+				rotatedKey = response.DeviceKey
+			},
+		},
+		{
+			name:           "Old key stops working",
+			method:         "GET",
+			path:           "/devices",
+			deviceKey:      func() string { return "idle_device" },
+			expectedStatus: fiber.StatusUnauthorized,
+		},
+		{
+			name:           "New key works",
+			method:         "GET",
+			path:           "/devices",
+			deviceKey:      func() string { return rotatedKey },
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Delete device",
+			method:         "DELETE",
+			path:           "/admin/devices/1",
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Deleted device is gone",
+			method:         "GET",
+			path:           "/admin/devices/1",
+			expectedStatus: fiber.StatusNotFound,
+		},
+		{
+			name:           "Delete unknown device",
+			method:         "DELETE",
+			path:           "/admin/devices/1",
+			expectedStatus: fiber.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bodyReader io.Reader
+			if tt.payload != nil {
+				bodyBytes, err := json.Marshal(tt.payload)
+				if err != nil {
+					t.Fatalf("Failed to marshal payload: %v", err)
+				}
+				bodyReader = bytes.NewReader(bodyBytes)
+			}
+
+			req := httptest.NewRequest(tt.method, tt.path, bodyReader)
+			req.Header.Set("Content-Type", "application/json")
+			if tt.deviceKey != nil {
+				req.Header.Set("X-Device-Key", tt.deviceKey())
+			}
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Failed to perform request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read response body: %v", err)
+			}
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d. Response: %s", tt.expectedStatus, resp.StatusCode, string(body))
+			}
+
+			if tt.checkResponse != nil {
+				tt.checkResponse(t, body)
+			}
+		})
+	}
+
+	t.Run("Inbound history survives device deletion", func(t *testing.T) {
+		messages, err := db.GetInboundMessages(db.InboundFilters{Limit: 10})
+		if err != nil {
+			t.Fatalf("Failed to get inbound messages: %v", err)
+		}
+		if len(messages) != 1 || messages[0].ID != inbound.ID || messages[0].DeviceID != nil {
+			t.Errorf("Expected the inbound message without its device, got %+v", messages)
+		}
+	})
+}
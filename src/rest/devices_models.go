@@ -1,5 +1,7 @@
 package rest
 
+import "time"
+
 type DeviceConfigRequest struct {
 	Topics []string `json:"topics" validate:"required"`
 }
@@ -7,3 +9,70 @@ type DeviceConfigRequest struct {
 type SuccessResponse struct {
 	Message string `json:"message"`
 }
+
+type EnrollDeviceRequest struct {
+	Name   *string  `json:"name,omitempty"`
+	Topics []string `json:"topics,omitempty"`
+}
+
+type EnrolledDeviceResponse struct {
+	ID        uint     `json:"id"`
+	Name      *string  `json:"name,omitempty"`
+	DeviceKey string   `json:"device_key"`
+	Topics    []string `json:"topics"`
+}
+
+type PairingCodeResponse struct {
+	Code      string    `json:"code"`
+	Name      *string   `json:"name,omitempty"`
+	Topics    []string  `json:"topics"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type PairDeviceRequest struct {
+	Code string  `json:"code" validate:"required"`
+	Name *string `json:"name,omitempty"`
+}
+
+type DeviceDetail struct {
+	ID           uint       `json:"id"`
+	Name         *string    `json:"name,omitempty"`
+	Topics       []string   `json:"topics"`
+	Status       string     `json:"status"`
+	Disabled     bool       `json:"disabled"`
+	LastPollAt   *time.Time `json:"last_poll_at,omitempty"`
+	OfflineSince *time.Time `json:"offline_since,omitempty"`
+	InFlight     int        `json:"in_flight"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+
+	LastHeartbeatAt *time.Time       `json:"last_heartbeat_at,omitempty"`
+	Telemetry       *DeviceTelemetry `json:"telemetry,omitempty"`
+}
+
+type DeviceTelemetry struct {
+	BatteryLevel    *int      `json:"battery_level,omitempty"`
+	Charging        *bool     `json:"charging,omitempty"`
+	LowBattery      bool      `json:"low_battery"`
+	SignalStrength  *int      `json:"signal_strength,omitempty"`
+	NetworkOperator *string   `json:"network_operator,omitempty"`
+	SimSlots        []SimSlot `json:"sim_slots"`
+	AppVersion      *string   `json:"app_version,omitempty"`
+}
+
+type DeviceHeartbeatDetail struct {
+	ID        uint      `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	DeviceTelemetry
+}
+
+type DeviceHeartbeatsListResponse struct {
+	Data       []DeviceHeartbeatDetail `json:"data"`
+	Pagination PaginationInfo          `json:"pagination"`
+}
+
+type UpdateDeviceRequest struct {
+	Name     *string  `json:"name,omitempty"`
+	Disabled *bool    `json:"disabled,omitempty"`
+	Topics   []string `json:"topics,omitempty"`
+}
@@ -1,29 +1,28 @@
 package rest
 
 import (
+	"errors"
+	"os"
+	"slices"
 	"sms-gateway-api/db"
+	"strconv"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
 )
 
+var reportableStatuses = []string{"sent", "failed", "delivered", "undelivered"}
+
 func PollMessagesHandler(c *fiber.Ctx) error {
-	deviceKey := c.Get("X-Device-Key")
-	if deviceKey == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Invalid or missing device key",
-		})
+	device, err := authenticateDevice(c)
+	if device == nil {
+		return err
 	}
 
-	device, err := db.GetDeviceByKey(deviceKey)
+	wait, err := parseWait(c.Query("wait"))
 	if err != nil {
-		return ReturnInternalError(c, "Failed to authenticate device")
-	}
-
-	if device == nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Invalid or missing device key",
-		})
+		return ReturnBadRequest(c, "Invalid wait value. Use a duration such as 30s or a number of seconds")
 	}
 
 	topics, err := db.GetDeviceTopics(device.ID)
@@ -31,48 +30,107 @@ func PollMessagesHandler(c *fiber.Ctx) error {
 		return ReturnInternalError(c, "Failed to retrieve device topics")
 	}
 
-	messages, err := db.GetPendingMessagesForDevice(device.ID, topics)
+	if err := db.UpdateDeviceLastPoll(device.ID); err != nil {
+		return ReturnInternalError(c, "Failed to update device poll time")
+	}
+
+	messages, err := waitForPendingMessages(device.ID, topics, wait)
 	if err != nil {
 		return ReturnInternalError(c, "Failed to retrieve pending messages")
 	}
 
-	if err := db.UpdateDeviceLastPoll(device.ID); err != nil {
-		return ReturnInternalError(c, "Failed to update device poll time")
+	response := PollResponse{
+		Messages: toPollMessages(messages),
 	}
 
+	return c.JSON(response)
+}
+
+func toPollMessages(messages []db.PollMessage) []PollMessage {
 	pollMessages := make([]PollMessage, len(messages))
 	for i, msg := range messages {
 		pollMessages[i] = PollMessage{
-			ID:       msg.ID,
-			ToNumber: msg.ToNumber,
-			Body:     msg.Body,
+			ID:          msg.ID,
+			ToNumber:    msg.ToNumber,
+			Body:        msg.Body,
+			LeasedUntil: msg.LeasedUntil,
 		}
 	}
+	return pollMessages
+}
 
-	response := PollResponse{
-		Messages: pollMessages,
+func getLongPollMaxWait() time.Duration {
+	secondsStr := os.Getenv("LONG_POLL_MAX_WAIT_SECONDS")
+	if secondsStr == "" {
+		return 30 * time.Second
 	}
 
-	return c.JSON(response)
+	seconds, err := strconv.Atoi(secondsStr)
+	if err != nil || seconds < 0 {
+		return 30 * time.Second
+	}
+
+	return time.Duration(seconds) * time.Second
 }
 
-func UpdateMessageStatusHandler(c *fiber.Ctx) error {
-	deviceKey := c.Get("X-Device-Key")
-	if deviceKey == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Invalid or missing device key",
-		})
+// parseWait accepts a Go duration ("30s") or a number of seconds ("30") and
+// caps it at LONG_POLL_MAX_WAIT_SECONDS.
+func parseWait(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
 	}
 
-	device, err := db.GetDeviceByKey(deviceKey)
+	wait, err := time.ParseDuration(value)
 	if err != nil {
-		return ReturnInternalError(c, "Failed to authenticate device")
+		seconds, convErr := strconv.Atoi(value)
+		if convErr != nil {
+			return 0, err
+		}
+		wait = time.Duration(seconds) * time.Second
+	}
+
+	if wait < 0 {
+		return 0, errors.New("wait must not be negative")
+	}
+
+	return min(wait, getLongPollMaxWait()), nil
+}
+
+// waitForPendingMessages claims messages for the device and, when none are
+// pending, blocks until one is queued for its topics or wait runs out.
+func waitForPendingMessages(deviceID uint, topics []string, wait time.Duration) ([]db.PollMessage, error) {
+	if wait <= 0 {
+		return db.GetPendingMessagesForDevice(deviceID, topics, db.PollBatchSize)
 	}
 
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+
+	for {
+		// Subscribe before querying so a message queued in between still
+		// wakes this request.
+		ready, stop := db.WaitForMessages(topics)
+
+		messages, err := db.GetPendingMessagesForDevice(deviceID, topics, db.PollBatchSize)
+		if err != nil || len(messages) > 0 {
+			stop()
+			return messages, err
+		}
+
+		select {
+		case <-ready:
+			stop()
+		case <-deadline.C:
+			stop()
+			return messages, nil
+		}
+	}
+}
+
+func UpdateMessageStatusHandler(c *fiber.Ctx) error {
+	device, err := authenticateDevice(c)
 	if device == nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Invalid or missing device key",
-		})
+		return err
 	}
 
 	messageID := c.Params("messageId")
@@ -85,30 +143,115 @@ func UpdateMessageStatusHandler(c *fiber.Ctx) error {
 		return ReturnBadRequest(c, "Invalid request body")
 	}
 
+	message, err := applyStatusUpdate(device.ID, messageID, req)
+	if err != nil {
+		return ReturnError(c, err)
+	}
+
+	response := SuccessResponse{
+		Message: message,
+	}
+
+	return c.JSON(response)
+}
+
+// applyStatusUpdate is shared by the REST and WebSocket gateways. Rejected
+// updates are returned as a *fiber.Error carrying the response status.
+func applyStatusUpdate(deviceID uint, messageID string, req StatusUpdateRequest) (string, error) {
 	if req.Status == "" {
-		return ReturnBadRequest(c, "status is required")
+		return "", fiber.NewError(fiber.StatusBadRequest, "status is required")
 	}
 
-	if req.Status != "sent" && req.Status != "failed" {
-		return ReturnBadRequest(c, "Invalid status. Must be one of: sent, failed")
+	if !slices.Contains(reportableStatuses, req.Status) {
+		return "", fiber.NewError(fiber.StatusBadRequest, "Invalid status. Must be one of: sent, failed, delivered, undelivered")
 	}
 
-	if req.Status == "failed" && (req.Reason == nil || *req.Reason == "") {
+	if (req.Status == "failed" || req.Status == "undelivered") && (req.Reason == nil || *req.Reason == "") {
 		emptyReason := "Unknown error"
 		req.Reason = &emptyReason
 	}
 
-	err = db.UpdateMessageStatus(messageID, req.Status, req.Reason)
+	err := db.UpdateMessageStatus(deviceID, messageID, req.Status, req.Reason)
 	if err == gorm.ErrRecordNotFound {
-		return ReturnNotFound(c, "Message not found")
+		return "", fiber.NewError(fiber.StatusNotFound, "Message not found")
+	}
+	if errors.Is(err, db.ErrMessageNotAssigned) {
+		return "", fiber.NewError(fiber.StatusForbidden, "Message is not assigned to this device")
+	}
+	if errors.Is(err, db.ErrStaleStatusUpdate) {
+		return "Status update ignored, message status has already advanced", nil
+	}
+	if errors.Is(err, db.ErrLeaseExpired) {
+		return "Status update ignored, the message lease has expired", nil
+	}
+	if errors.Is(err, db.ErrInvalidStatusTransition) {
+		return "", fiber.NewError(fiber.StatusConflict, err.Error())
 	}
 	if err != nil {
-		return ReturnInternalError(c, "Failed to update message status")
+		return "", fiber.NewError(fiber.StatusInternalServerError, "Failed to update message status")
 	}
 
-	response := SuccessResponse{
-		Message: "Message status updated",
+	return "Message status updated", nil
+}
+
+func HeartbeatHandler(c *fiber.Ctx) error {
+	device, err := authenticateDevice(c)
+	if device == nil {
+		return err
 	}
 
-	return c.JSON(response)
+	var req HeartbeatRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ReturnBadRequest(c, "Invalid request body")
+	}
+
+	if err := applyHeartbeat(device.ID, req); err != nil {
+		return ReturnError(c, err)
+	}
+
+	return c.JSON(SuccessResponse{
+		Message: "Heartbeat recorded",
+	})
+}
+
+func applyHeartbeat(deviceID uint, req HeartbeatRequest) error {
+	if req.BatteryLevel != nil && (*req.BatteryLevel < 0 || *req.BatteryLevel > 100) {
+		return fiber.NewError(fiber.StatusBadRequest, "battery_level must be between 0 and 100")
+	}
+
+	if !validSignalStrength(req.SignalStrength) {
+		return fiber.NewError(fiber.StatusBadRequest, "signal_strength must be between -150 and 0 dBm")
+	}
+
+	simSlots := make([]db.SimSlot, len(req.SimSlots))
+	for i, slot := range req.SimSlots {
+		if slot.Slot < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "sim_slots slot must not be negative")
+		}
+		if !validSignalStrength(slot.SignalStrength) {
+			return fiber.NewError(fiber.StatusBadRequest, "sim_slots signal_strength must be between -150 and 0 dBm")
+		}
+		simSlots[i] = db.SimSlot(slot)
+	}
+	if req.SimSlots == nil {
+		simSlots = nil
+	}
+
+	_, err := db.RecordHeartbeat(deviceID, db.HeartbeatParams{
+		BatteryLevel:    req.BatteryLevel,
+		Charging:        req.Charging,
+		SignalStrength:  req.SignalStrength,
+		NetworkOperator: req.NetworkOperator,
+		SimSlots:        simSlots,
+		AppVersion:      req.AppVersion,
+	})
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Failed to record heartbeat")
+	}
+
+	return nil
+}
+
+func validSignalStrength(dbm *int) bool {
+	return dbm == nil || (*dbm >= -150 && *dbm <= 0)
 }
@@ -3,18 +3,27 @@ package rest
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http/httptest"
+	"os"
 	"sms-gateway-api/db"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
+const pollBatchSizeForTest = 10
+
 func setupGatewayTestApp() *fiber.App {
 	app := fiber.New()
 	app.Get("/gateway/poll", PollMessagesHandler)
 	app.Put("/gateway/status/:messageId", UpdateMessageStatusHandler)
+	app.Post("/gateway/heartbeat", HeartbeatHandler)
+	app.Get("/admin/devices/:id", GetDeviceHandler)
+	app.Get("/admin/devices/:id/heartbeats", ListDeviceHeartbeatsHandler)
 	return app
 }
 
@@ -33,6 +42,43 @@ func setupGatewayTestDB(t *testing.T) {
 	}
 }
 
+// leaseToDevice assigns a message to a device as if the device had polled it.
+func leaseToDevice(t *testing.T, messageID string, deviceID uint) {
+	err := db.GetDB().Model(&db.Message{}).Where("id = ?", messageID).Updates(map[string]interface{}{
+		"assigned_device_id": deviceID,
+		"leased_until":       time.Now().UTC().Add(time.Minute),
+	}).Error
+	if err != nil {
+		t.Fatalf("Failed to lease message: %v", err)
+	}
+}
+
+// reportStatus reports a status from a test device, leasing the message to it
+// first when no device holds it.
+func reportStatus(messageID, status string, reason *string) error {
+	device, err := db.GetDeviceByKey("status_test_device")
+	if err != nil {
+		return err
+	}
+	if device == nil {
+		if device, err = db.CreateDevice("status_test_device", nil); err != nil {
+			return err
+		}
+	}
+
+	err = db.GetDB().Model(&db.Message{}).
+		Where("id = ? AND assigned_device_id IS NULL", messageID).
+		Updates(map[string]interface{}{
+			"assigned_device_id": device.ID,
+			"leased_until":       time.Now().UTC().Add(time.Minute),
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	return db.UpdateMessageStatus(device.ID, messageID, status, reason)
+}
+
 func TestPollMessagesHandler(t *testing.T) {
 	setupGatewayTestDB(t)
 	defer teardownTestDB()
@@ -124,19 +170,238 @@ func TestPollMessagesHandler(t *testing.T) {
 	}
 }
 
+func TestPollMessagesHandler_Leasing(t *testing.T) {
+	setupGatewayTestDB(t)
+	defer teardownTestDB()
+
+	app := setupGatewayTestApp()
+
+	for _, key := range []string{"lease_device_a", "lease_device_b"} {
+		device, err := db.CreateDevice(key, nil)
+		if err != nil {
+			t.Fatalf("Failed to create test device: %v", err)
+		}
+		if err := db.SetDeviceTopics(device.ID, []string{"otp"}); err != nil {
+			t.Fatalf("Failed to set device topics: %v", err)
+		}
+	}
+	msg, err := db.CreateMessage("otp", "+1234567890", "Your OTP is 123456")
+	if err != nil {
+		t.Fatalf("Failed to create test message: %v", err)
+	}
+
+	poll := func(t *testing.T, deviceKey string) PollResponse {
+		req := httptest.NewRequest("GET", "/gateway/poll", nil)
+		req.Header.Set("X-Device-Key", deviceKey)
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to perform request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Failed to read response body: %v", err)
+		}
+		if resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("Expected status %d, got %d. Response: %s", fiber.StatusOK, resp.StatusCode, string(body))
+		}
+
+		var response PollResponse
+		if err := json.Unmarshal(body, &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		return response
+	}
+
+	t.Run("First device claims the message", func(t *testing.T) {
+		response := poll(t, "lease_device_a")
+		if len(response.Messages) != 1 {
+			t.Fatalf("Expected 1 message, got %d", len(response.Messages))
+		}
+		if !response.Messages[0].LeasedUntil.After(time.Now()) {
+			t.Error("Expected leased_until to be in the future")
+		}
+	})
+
+	t.Run("Leased message is not handed out again", func(t *testing.T) {
+		if response := poll(t, "lease_device_b"); len(response.Messages) != 0 {
+			t.Errorf("Expected 0 messages for second device, got %d", len(response.Messages))
+		}
+		if response := poll(t, "lease_device_a"); len(response.Messages) != 0 {
+			t.Errorf("Expected 0 messages on repeated poll, got %d", len(response.Messages))
+		}
+	})
+
+	t.Run("Expired lease is released to another device", func(t *testing.T) {
+		expired := time.Now().UTC().Add(-time.Second)
+		db.GetDB().Model(&db.Message{}).Where("id = ?", msg.ID).Update("leased_until", expired)
+
+		response := poll(t, "lease_device_b")
+		if len(response.Messages) != 1 {
+			t.Fatalf("Expected 1 message, got %d", len(response.Messages))
+		}
+
+		device, err := db.GetDeviceByKey("lease_device_b")
+		if err != nil {
+			t.Fatalf("Failed to get device: %v", err)
+		}
+		message, err := db.GetMessageByID(msg.ID)
+		if err != nil {
+			t.Fatalf("Failed to get message: %v", err)
+		}
+		if message.AssignedDeviceID == nil || *message.AssignedDeviceID != device.ID {
+			t.Error("Expected message to be assigned to the second device")
+		}
+	})
+
+	t.Run("Status report from the previous holder is rejected", func(t *testing.T) {
+		device, err := db.GetDeviceByKey("lease_device_a")
+		if err != nil {
+			t.Fatalf("Failed to get device: %v", err)
+		}
+		err = db.UpdateMessageStatus(device.ID, msg.ID, "sent", nil)
+		if !errors.Is(err, db.ErrMessageNotAssigned) {
+			t.Errorf("Expected ErrMessageNotAssigned, got %v", err)
+		}
+	})
+
+	t.Run("Status report clears the lease", func(t *testing.T) {
+		device, err := db.GetDeviceByKey("lease_device_b")
+		if err != nil {
+			t.Fatalf("Failed to get device: %v", err)
+		}
+		if err := db.UpdateMessageStatus(device.ID, msg.ID, "sent", nil); err != nil {
+			t.Fatalf("Failed to update message status: %v", err)
+		}
+		message, err := db.GetMessageByID(msg.ID)
+		if err != nil {
+			t.Fatalf("Failed to get message: %v", err)
+		}
+		if message.LeasedUntil != nil {
+			t.Error("Expected leased_until to be cleared")
+		}
+	})
+}
+
+func TestPollMessagesHandler_Priority(t *testing.T) {
+	setupGatewayTestDB(t)
+	defer teardownTestDB()
+
+	device, err := db.CreateDevice("priority_device", nil)
+	if err != nil {
+		t.Fatalf("Failed to create test device: %v", err)
+	}
+	if err := db.SetDeviceTopics(device.ID, []string{"otp", "alerts", "marketing"}); err != nil {
+		t.Fatalf("Failed to set device topics: %v", err)
+	}
+
+	// Each case uses its own topic so earlier messages do not compete.
+	topic := "otp"
+	create := func(t *testing.T, toNumber, priority string) *db.Message {
+		msg, err := db.CreateMessageWithParams(db.MessageParams{
+			Topic:    topic,
+			ToNumber: toNumber,
+			Body:     "Message for " + toNumber,
+			Priority: priority,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create test message: %v", err)
+		}
+		return msg
+	}
+
+	age := func(t *testing.T, msg *db.Message, column string, at time.Time) {
+		if err := db.GetDB().Model(&db.Message{}).Where("id = ?", msg.ID).Update(column, at).Error; err != nil {
+			t.Fatalf("Failed to age test message: %v", err)
+		}
+	}
+
+	poll := func(t *testing.T) []db.PollMessage {
+		messages, err := db.GetPendingMessagesForDevice(device.ID, []string{topic}, db.PollBatchSize)
+		if err != nil {
+			t.Fatalf("Failed to poll messages: %v", err)
+		}
+		return messages
+	}
+
+	t.Run("Aged messages move up one lane", func(t *testing.T) {
+		aged := create(t, "+1000000000", "bulk")
+		age(t, aged, "created_at", time.Now().Add(-time.Hour))
+		for i := 1; i <= pollBatchSizeForTest; i++ {
+			create(t, fmt.Sprintf("+100000%04d", i), "bulk")
+		}
+		normal := create(t, "+2000000000", "normal")
+		high := create(t, "+3000000000", "high")
+
+		messages := poll(t)
+		if len(messages) != pollBatchSizeForTest {
+			t.Fatalf("Expected %d messages, got %d", pollBatchSizeForTest, len(messages))
+		}
+
+		if messages[0].ID != high.ID {
+			t.Errorf("Expected high priority message first, got %s", messages[0].ID)
+		}
+		if messages[1].ID != aged.ID {
+			t.Errorf("Expected aged bulk message second, got %s", messages[1].ID)
+		}
+		if messages[2].ID != normal.ID {
+			t.Errorf("Expected normal priority message third, got %s", messages[2].ID)
+		}
+	})
+
+	t.Run("High priority is not starved by an aged backlog", func(t *testing.T) {
+		topic = "alerts"
+		for i := 1; i <= pollBatchSizeForTest+5; i++ {
+			msg := create(t, fmt.Sprintf("+400000%04d", i), "bulk")
+			age(t, msg, "created_at", time.Now().Add(-20*time.Minute))
+		}
+		high := create(t, "+5000000000", "high")
+
+		messages := poll(t)
+		if len(messages) == 0 || messages[0].ID != high.ID {
+			t.Errorf("Expected the fresh high priority message first, got %+v", messages)
+		}
+	})
+
+	t.Run("Aging counts from send_at", func(t *testing.T) {
+		topic = "marketing"
+		scheduled := create(t, "+6000000000", "bulk")
+		age(t, scheduled, "created_at", time.Now().Add(-time.Hour))
+		age(t, scheduled, "send_at", time.Now().Add(-time.Second))
+		normal := create(t, "+7000000000", "normal")
+
+		messages := poll(t)
+		if len(messages) != 2 || messages[0].ID != normal.ID {
+			t.Errorf("Expected the normal message before the just-due bulk message, got %+v", messages)
+		}
+	})
+}
+
 func TestUpdateMessageStatusHandler(t *testing.T) {
 	setupGatewayTestDB(t)
 	defer teardownTestDB()
 
 	app := setupGatewayTestApp()
 
-	if _, err := db.CreateDevice("test_device_key_status", nil); err != nil {
+	device, err := db.CreateDevice("test_device_key_status", nil)
+	if err != nil {
+		t.Fatalf("Failed to create test device: %v", err)
+	}
+	if _, err := db.CreateDevice("test_device_key_other", nil); err != nil {
 		t.Fatalf("Failed to create test device: %v", err)
 	}
 	msg, err := db.CreateMessage("otp", "+1234567890", "Your OTP is 123456")
 	if err != nil {
 		t.Fatalf("Failed to create test message: %v", err)
 	}
+	leaseToDevice(t, msg.ID, device.ID)
+	failing, err := db.CreateMessage("otp", "+1234567891", "Your OTP is 654321")
+	if err != nil {
+		t.Fatalf("Failed to create test message: %v", err)
+	}
+	leaseToDevice(t, failing.ID, device.ID)
 
 	tests := []struct {
 		name           string
@@ -170,6 +435,14 @@ func TestUpdateMessageStatusHandler(t *testing.T) {
 			expectedStatus: fiber.StatusNotFound,
 			checkResponse:  nil,
 		},
+		{
+			name:           "Message assigned to another device",
+			deviceKey:      "test_device_key_other",
+			messageID:      msg.ID,
+			payload:        StatusUpdateRequest{Status: "sent"},
+			expectedStatus: fiber.StatusForbidden,
+			checkResponse:  nil,
+		},
 		{
 			name:      "Valid request - mark as sent",
 			deviceKey: "test_device_key_status",
@@ -202,14 +475,14 @@ func TestUpdateMessageStatusHandler(t *testing.T) {
 		{
 			name:      "Valid request - mark as failed",
 			deviceKey: "test_device_key_status",
-			messageID: msg.ID,
+			messageID: failing.ID,
 			payload: StatusUpdateRequest{
 				Status: "failed",
 				Reason: strPtr("Network error"),
 			},
 			expectedStatus: fiber.StatusOK,
 			checkResponse: func(t *testing.T, body []byte) {
-				message, err := db.GetMessageByID(msg.ID)
+				message, err := db.GetMessageByID(failing.ID)
 				if err != nil {
 					t.Fatalf("Failed to get message: %v", err)
 				}
@@ -292,6 +565,742 @@ func TestUpdateMessageStatusHandler(t *testing.T) {
 	}
 }
 
+func TestUpdateMessageStatusHandler_Retry(t *testing.T) {
+	os.Setenv("RETRY_MAX_ATTEMPTS", "2")
+	defer os.Unsetenv("RETRY_MAX_ATTEMPTS")
+
+	setupGatewayTestDB(t)
+	defer teardownTestDB()
+
+	app := setupGatewayTestApp()
+
+	device, err := db.CreateDevice("retry_device", nil)
+	if err != nil {
+		t.Fatalf("Failed to create test device: %v", err)
+	}
+	if err := db.SetDeviceTopics(device.ID, []string{"otp"}); err != nil {
+		t.Fatalf("Failed to set device topics: %v", err)
+	}
+
+	reportFailureFrom := func(t *testing.T, deviceKey, messageID, reason string) (int, string) {
+		bodyBytes, err := json.Marshal(StatusUpdateRequest{Status: "failed", Reason: &reason})
+		if err != nil {
+			t.Fatalf("Failed to marshal payload: %v", err)
+		}
+
+		req := httptest.NewRequest("PUT", "/gateway/status/"+messageID, bytes.NewReader(bodyBytes))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Device-Key", deviceKey)
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to perform request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	reportFailure := func(t *testing.T, messageID, reason string) {
+		if status, body := reportFailureFrom(t, "retry_device", messageID, reason); status != fiber.StatusOK {
+			t.Fatalf("Expected status %d, got %d. Response: %s", fiber.StatusOK, status, body)
+		}
+	}
+
+	claim := func(t *testing.T) []db.PollMessage {
+		messages, err := db.GetPendingMessagesForDevice(device.ID, []string{"otp"}, db.PollBatchSize)
+		if err != nil {
+			t.Fatalf("Failed to poll messages: %v", err)
+		}
+		return messages
+	}
+
+	msg, err := db.CreateMessage("otp", "+1234567890", "Your OTP is 123456")
+	if err != nil {
+		t.Fatalf("Failed to create test message: %v", err)
+	}
+
+	t.Run("Transient failure is rescheduled", func(t *testing.T) {
+		if messages := claim(t); len(messages) != 1 {
+			t.Fatalf("Expected 1 message, got %d", len(messages))
+		}
+		reportFailure(t, msg.ID, "No signal")
+
+		message, err := db.GetMessageByID(msg.ID)
+		if err != nil {
+			t.Fatalf("Failed to get message: %v", err)
+		}
+		if message.Status != "pending" {
+			t.Errorf("Expected status 'pending', got '%s'", message.Status)
+		}
+		if message.NextAttemptAt == nil || !message.NextAttemptAt.After(time.Now()) {
+			t.Error("Expected next_attempt_at to be in the future")
+		}
+		if message.FailedAt != nil {
+			t.Error("Expected failed_at to be unset while retrying")
+		}
+
+		var attempts []db.MessageAttempt
+		db.GetDB().Where("message_id = ?", msg.ID).Find(&attempts)
+		if len(attempts) != 1 || attempts[0].Status != "failed" || attempts[0].Reason == nil || *attempts[0].Reason != "No signal" {
+			t.Errorf("Expected one failed attempt with reason 'No signal', got %+v", attempts)
+		}
+	})
+
+	t.Run("Message is held back until the backoff elapses", func(t *testing.T) {
+		if messages := claim(t); len(messages) != 0 {
+			t.Errorf("Expected 0 messages during backoff, got %d", len(messages))
+		}
+	})
+
+	t.Run("Failure after the last attempt is terminal", func(t *testing.T) {
+		past := time.Now().UTC().Add(-time.Second)
+		db.GetDB().Model(&db.Message{}).Where("id = ?", msg.ID).Update("next_attempt_at", past)
+
+		if messages := claim(t); len(messages) != 1 {
+			t.Fatalf("Expected 1 message after backoff, got %d", len(messages))
+		}
+		reportFailure(t, msg.ID, "No signal")
+
+		message, err := db.GetMessageByID(msg.ID)
+		if err != nil {
+			t.Fatalf("Failed to get message: %v", err)
+		}
+		if message.Status != "failed" {
+			t.Errorf("Expected status 'failed', got '%s'", message.Status)
+		}
+		if message.Attempts != 2 {
+			t.Errorf("Expected 2 attempts, got %d", message.Attempts)
+		}
+	})
+
+	t.Run("Never-retry reason is terminal", func(t *testing.T) {
+		invalid, err := db.CreateMessage("otp", "+1999", "Your OTP is 654321")
+		if err != nil {
+			t.Fatalf("Failed to create test message: %v", err)
+		}
+		if messages := claim(t); len(messages) != 1 {
+			t.Fatalf("Expected 1 message, got %d", len(messages))
+		}
+		reportFailure(t, invalid.ID, "Invalid number")
+
+		message, err := db.GetMessageByID(invalid.ID)
+		if err != nil {
+			t.Fatalf("Failed to get message: %v", err)
+		}
+		if message.Status != "failed" {
+			t.Errorf("Expected status 'failed', got '%s'", message.Status)
+		}
+	})
+
+	expireLease := func(t *testing.T, messageID string) {
+		past := time.Now().UTC().Add(-time.Second)
+		if err := db.GetDB().Model(&db.Message{}).Where("id = ?", messageID).Update("leased_until", past).Error; err != nil {
+			t.Fatalf("Failed to expire lease: %v", err)
+		}
+	}
+
+	t.Run("Failure after the lease expired is ignored", func(t *testing.T) {
+		late, err := db.CreateMessage("otp", "+1777", "Your OTP is 777777")
+		if err != nil {
+			t.Fatalf("Failed to create test message: %v", err)
+		}
+		if messages := claim(t); len(messages) != 1 {
+			t.Fatalf("Expected 1 message, got %d", len(messages))
+		}
+		expireLease(t, late.ID)
+		reportFailure(t, late.ID, "No signal")
+
+		message, err := db.GetMessageByID(late.ID)
+		if err != nil {
+			t.Fatalf("Failed to get message: %v", err)
+		}
+		if message.Status != "pending" || message.NextAttemptAt != nil {
+			t.Errorf("Expected the message to stay pending without a retry, got status '%s' next_attempt_at %v", message.Status, message.NextAttemptAt)
+		}
+	})
+
+	t.Run("Failure from the previous holder does not touch the new lease", func(t *testing.T) {
+		other, err := db.CreateDevice("retry_device_b", nil)
+		if err != nil {
+			t.Fatalf("Failed to create test device: %v", err)
+		}
+		if err := db.SetDeviceTopics(other.ID, []string{"otp"}); err != nil {
+			t.Fatalf("Failed to set device topics: %v", err)
+		}
+
+		moved, err := db.CreateMessage("otp", "+1888", "Your OTP is 888888")
+		if err != nil {
+			t.Fatalf("Failed to create test message: %v", err)
+		}
+		db.GetDB().Model(&db.Message{}).Where("id <> ? AND status = ?", moved.ID, "pending").Update("status", "cancelled")
+
+		if messages := claim(t); len(messages) != 1 {
+			t.Fatalf("Expected 1 message, got %d", len(messages))
+		}
+		expireLease(t, moved.ID)
+		if messages, err := db.GetPendingMessagesForDevice(other.ID, []string{"otp"}, db.PollBatchSize); err != nil || len(messages) != 1 {
+			t.Fatalf("Expected the second device to claim the message, got %d (%v)", len(messages), err)
+		}
+
+		if status, body := reportFailureFrom(t, "retry_device", moved.ID, "No signal"); status != fiber.StatusForbidden {
+			t.Errorf("Expected status %d, got %d. Response: %s", fiber.StatusForbidden, status, body)
+		}
+
+		message, err := db.GetMessageByID(moved.ID)
+		if err != nil {
+			t.Fatalf("Failed to get message: %v", err)
+		}
+		if message.LeasedUntil == nil || message.NextAttemptAt != nil {
+			t.Errorf("Expected the second device to keep its lease, got leased_until %v next_attempt_at %v", message.LeasedUntil, message.NextAttemptAt)
+		}
+
+		var open int64
+		db.GetDB().Model(&db.MessageAttempt{}).Where("message_id = ? AND device_id = ? AND status = ?", moved.ID, other.ID, "leased").Count(&open)
+		if open != 1 {
+			t.Errorf("Expected the second device's attempt to stay open, got %d", open)
+		}
+	})
+}
+
+func TestUpdateMessageStatusHandler_DeliveryReports(t *testing.T) {
+	setupGatewayTestDB(t)
+	defer teardownTestDB()
+
+	app := setupGatewayTestApp()
+
+	device, err := db.CreateDevice("dlr_device", nil)
+	if err != nil {
+		t.Fatalf("Failed to create test device: %v", err)
+	}
+	msg, err := db.CreateMessage("otp", "+1234567890", "Your OTP is 123456")
+	if err != nil {
+		t.Fatalf("Failed to create test message: %v", err)
+	}
+	leaseToDevice(t, msg.ID, device.ID)
+	cancelled, err := db.CreateMessage("otp", "+1987654321", "Your OTP is 654321")
+	if err != nil {
+		t.Fatalf("Failed to create test message: %v", err)
+	}
+	if _, err := db.CancelMessage(cancelled.ID); err != nil {
+		t.Fatalf("Failed to cancel message: %v", err)
+	}
+	leaseToDevice(t, cancelled.ID, device.ID)
+
+	tests := []struct {
+		name           string
+		messageID      string
+		payload        StatusUpdateRequest
+		expectedStatus int
+		expectedState  string
+	}{
+		{
+			name:           "Device reports sent",
+			messageID:      msg.ID,
+			payload:        StatusUpdateRequest{Status: "sent"},
+			expectedStatus: fiber.StatusOK,
+			expectedState:  "sent",
+		},
+		{
+			name:           "Late failure after sent is rejected",
+			messageID:      msg.ID,
+			payload:        StatusUpdateRequest{Status: "failed", Reason: strPtr("Network error")},
+			expectedStatus: fiber.StatusConflict,
+			expectedState:  "sent",
+		},
+		{
+			name:           "Carrier confirms delivery",
+			messageID:      msg.ID,
+			payload:        StatusUpdateRequest{Status: "delivered"},
+			expectedStatus: fiber.StatusOK,
+			expectedState:  "delivered",
+		},
+		{
+			name:           "Late sent report does not regress status",
+			messageID:      msg.ID,
+			payload:        StatusUpdateRequest{Status: "sent"},
+			expectedStatus: fiber.StatusOK,
+			expectedState:  "delivered",
+		},
+		{
+			name:           "Conflicting undelivered report is rejected",
+			messageID:      msg.ID,
+			payload:        StatusUpdateRequest{Status: "undelivered", Reason: strPtr("Absent subscriber")},
+			expectedStatus: fiber.StatusConflict,
+			expectedState:  "delivered",
+		},
+		{
+			name:           "Delivery report for cancelled message is rejected",
+			messageID:      cancelled.ID,
+			payload:        StatusUpdateRequest{Status: "delivered"},
+			expectedStatus: fiber.StatusConflict,
+			expectedState:  "cancelled",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bodyBytes, err := json.Marshal(tt.payload)
+			if err != nil {
+				t.Fatalf("Failed to marshal payload: %v", err)
+			}
+
+			req := httptest.NewRequest("PUT", "/gateway/status/"+tt.messageID, bytes.NewReader(bodyBytes))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Device-Key", "dlr_device")
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Failed to perform request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.expectedStatus {
+				body, _ := io.ReadAll(resp.Body)
+				t.Errorf("Expected status %d, got %d. Response: %s", tt.expectedStatus, resp.StatusCode, string(body))
+			}
+
+			message, err := db.GetMessageByID(tt.messageID)
+			if err != nil {
+				t.Fatalf("Failed to get message: %v", err)
+			}
+			if message.Status != tt.expectedState {
+				t.Errorf("Expected message status '%s', got '%s'", tt.expectedState, message.Status)
+			}
+		})
+	}
+
+	message, err := db.GetMessageByID(msg.ID)
+	if err != nil {
+		t.Fatalf("Failed to get message: %v", err)
+	}
+	if message.SentAt == nil || message.DeliveredAt == nil {
+		t.Errorf("Expected sent_at and delivered_at to be set, got %v and %v", message.SentAt, message.DeliveredAt)
+	}
+}
+
 func strPtr(s string) *string {
 	return &s
 }
+
+func TestHeartbeatHandler(t *testing.T) {
+	setupGatewayTestDB(t)
+	defer teardownTestDB()
+
+	app := setupGatewayTestApp()
+
+	if _, err := db.CreateDevice("heartbeat_device", nil); err != nil {
+		t.Fatalf("Failed to create test device: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		deviceKey      string
+		payload        interface{}
+		expectedStatus int
+		checkResponse  func(t *testing.T, body []byte)
+	}{
+		{
+			name:           "Missing device key",
+			method:         "POST",
+			path:           "/gateway/heartbeat",
+			payload:        map[string]interface{}{"battery_level": 80},
+			expectedStatus: fiber.StatusUnauthorized,
+		},
+		{
+			name:           "Device without heartbeat has no telemetry",
+			method:         "GET",
+			path:           "/admin/devices/1",
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response DeviceDetail
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.Telemetry != nil || response.LastHeartbeatAt != nil {
+					t.Errorf("Expected no telemetry, got %+v", response)
+				}
+			},
+		},
+		{
+			name:      "Full heartbeat",
+			method:    "POST",
+			path:      "/gateway/heartbeat",
+			deviceKey: "heartbeat_device",
+			payload: map[string]interface{}{
+				"battery_level":    64,
+				"charging":         true,
+				"signal_strength":  -85,
+				"network_operator": "Vodacom",
+				"sim_slots": []map[string]interface{}{
+					{"slot": 0, "operator": "Vodacom", "phone_number": "+258841234567", "signal_strength": -85},
+					{"slot": 1, "operator": "Movitel"},
+				},
+				"app_version": "2.4.1",
+			},
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Low battery heartbeat",
+			method:         "POST",
+			path:           "/gateway/heartbeat",
+			deviceKey:      "heartbeat_device",
+			payload:        map[string]interface{}{"battery_level": 12, "charging": false, "app_version": "2.4.1"},
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Battery level out of range",
+			method:         "POST",
+			path:           "/gateway/heartbeat",
+			deviceKey:      "heartbeat_device",
+			payload:        map[string]interface{}{"battery_level": 101},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Signal strength out of range",
+			method:         "POST",
+			path:           "/gateway/heartbeat",
+			deviceKey:      "heartbeat_device",
+			payload:        map[string]interface{}{"signal_strength": 20},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Device shows latest telemetry",
+			method:         "GET",
+			path:           "/admin/devices/1",
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response DeviceDetail
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				telemetry := response.Telemetry
+				if telemetry == nil || response.LastHeartbeatAt == nil {
+					t.Fatalf("Expected telemetry, got %+v", response)
+				}
+				if telemetry.BatteryLevel == nil || *telemetry.BatteryLevel != 12 || !telemetry.LowBattery {
+					t.Errorf("Expected low battery at 12%%, got %+v", telemetry)
+				}
+				if telemetry.NetworkOperator != nil || len(telemetry.SimSlots) != 0 {
+					t.Errorf("Expected latest state to replace the previous one, got %+v", telemetry)
+				}
+			},
+		},
+		{
+			name:           "Heartbeat history",
+			method:         "GET",
+			path:           "/admin/devices/1/heartbeats",
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response DeviceHeartbeatsListResponse
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.Pagination.Total != 2 {
+					t.Fatalf("Expected 2 heartbeats, got %d", response.Pagination.Total)
+				}
+				oldest := response.Data[1]
+				if len(oldest.SimSlots) != 2 || oldest.SimSlots[0].PhoneNumber == nil || oldest.LowBattery {
+					t.Errorf("Unexpected first heartbeat: %+v", oldest)
+				}
+				if oldest.AppVersion == nil || *oldest.AppVersion != "2.4.1" {
+					t.Errorf("Expected app version 2.4.1, got %v", oldest.AppVersion)
+				}
+			},
+		},
+		{
+			name:           "Heartbeat history since the future",
+			method:         "GET",
+			path:           "/admin/devices/1/heartbeats?since=" + time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response DeviceHeartbeatsListResponse
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.Pagination.Total != 0 {
+					t.Errorf("Expected no heartbeats, got %d", response.Pagination.Total)
+				}
+			},
+		},
+		{
+			name:           "Heartbeats of unknown device",
+			method:         "GET",
+			path:           "/admin/devices/99/heartbeats",
+			expectedStatus: fiber.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bodyReader io.Reader
+			if tt.payload != nil {
+				bodyBytes, err := json.Marshal(tt.payload)
+				if err != nil {
+					t.Fatalf("Failed to marshal payload: %v", err)
+				}
+				bodyReader = bytes.NewReader(bodyBytes)
+			}
+
+			req := httptest.NewRequest(tt.method, tt.path, bodyReader)
+			req.Header.Set("Content-Type", "application/json")
+			if tt.deviceKey != "" {
+				req.Header.Set("X-Device-Key", tt.deviceKey)
+			}
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Failed to perform request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read response body: %v", err)
+			}
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d. Response: %s", tt.expectedStatus, resp.StatusCode, string(body))
+			}
+
+			if tt.checkResponse != nil {
+				tt.checkResponse(t, body)
+			}
+		})
+	}
+}
+
+func TestDeviceOfflineDetection(t *testing.T) {
+	setupGatewayTestDB(t)
+	defer teardownTestDB()
+
+	app := setupGatewayTestApp()
+
+	poll := func(t *testing.T, deviceKey string) PollResponse {
+		req := httptest.NewRequest("GET", "/gateway/poll", nil)
+		req.Header.Set("X-Device-Key", deviceKey)
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to perform request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var response PollResponse
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return response
+	}
+
+	webhookEvents := func(t *testing.T) []string {
+		deliveries, _, err := db.GetWebhookDeliveries("", 100, 0)
+		if err != nil {
+			t.Fatalf("Failed to get webhook deliveries: %v", err)
+		}
+		events := make([]string, len(deliveries))
+		for i, delivery := range deliveries {
+			events[len(deliveries)-1-i] = delivery.Event
+		}
+		return events
+	}
+
+	for _, key := range []string{"silent_device", "healthy_device"} {
+		device, err := db.CreateDevice(key, nil)
+		if err != nil {
+			t.Fatalf("Failed to create test device: %v", err)
+		}
+		if err := db.SetDeviceTopics(device.ID, []string{"otp"}); err != nil {
+			t.Fatalf("Failed to set device topics: %v", err)
+		}
+	}
+	if _, err := db.CreateDevice("never_polled_device", nil); err != nil {
+		t.Fatalf("Failed to create test device: %v", err)
+	}
+	if _, err := db.CreateWebhookSubscription("otp", "http://example.com/hooks", []string{db.WebhookDeviceOffline, db.WebhookDeviceOnline}); err != nil {
+		t.Fatalf("Failed to create webhook subscription: %v", err)
+	}
+
+	message, err := db.CreateMessage("otp", "+258841234567", "Your OTP is 123456")
+	if err != nil {
+		t.Fatalf("Failed to create message: %v", err)
+	}
+
+	if got := poll(t, "silent_device"); len(got.Messages) != 1 {
+		t.Fatalf("Expected silent device to lease the message, got %d messages", len(got.Messages))
+	}
+
+	t.Run("Recently polled devices stay online", func(t *testing.T) {
+		marked, err := db.MarkOfflineDevices()
+		if err != nil {
+			t.Fatalf("Failed to mark offline devices: %v", err)
+		}
+		if len(marked) != 0 {
+			t.Errorf("Expected no offline devices, got %d", len(marked))
+		}
+	})
+
+	t.Run("Silent device is marked offline and its messages are released", func(t *testing.T) {
+		silence := time.Now().UTC().Add(-10 * time.Minute)
+		if err := db.DB.Model(&db.Device{}).Where("device_key = ?", "silent_device").Update("last_poll_at", silence).Error; err != nil {
+			t.Fatalf("Failed to backdate last poll: %v", err)
+		}
+
+		marked, err := db.MarkOfflineDevices()
+		if err != nil {
+			t.Fatalf("Failed to mark offline devices: %v", err)
+		}
+		if len(marked) != 1 || marked[0].DeviceKey != "silent_device" {
+			t.Fatalf("Expected only the silent device to go offline, got %+v", marked)
+		}
+
+		stored, err := db.GetMessageByID(message.ID)
+		if err != nil {
+			t.Fatalf("Failed to get message: %v", err)
+		}
+		if stored.AssignedDeviceID != nil || stored.LeasedUntil != nil {
+			t.Errorf("Expected message to be unassigned, got device %v until %v", stored.AssignedDeviceID, stored.LeasedUntil)
+		}
+		if stored.Attempts != 0 {
+			t.Errorf("Expected the released attempt not to count, got %d attempts", stored.Attempts)
+		}
+
+		if got := poll(t, "healthy_device"); len(got.Messages) != 1 || got.Messages[0].ID != message.ID {
+			t.Errorf("Expected healthy device to pick up the released message, got %+v", got.Messages)
+		}
+
+		events := webhookEvents(t)
+		if len(events) != 1 || events[0] != db.WebhookDeviceOffline {
+			t.Errorf("Expected a device.offline webhook, got %v", events)
+		}
+	})
+
+	t.Run("Offline device is only reported once", func(t *testing.T) {
+		marked, err := db.MarkOfflineDevices()
+		if err != nil {
+			t.Fatalf("Failed to mark offline devices: %v", err)
+		}
+		if len(marked) != 0 {
+			t.Errorf("Expected no newly offline devices, got %d", len(marked))
+		}
+	})
+
+	t.Run("Polling again brings the device back online", func(t *testing.T) {
+		poll(t, "silent_device")
+
+		device, err := db.GetDeviceByKey("silent_device")
+		if err != nil {
+			t.Fatalf("Failed to get device: %v", err)
+		}
+		if device.OfflineSince != nil || !device.IsOnline() {
+			t.Errorf("Expected device to be online, got offline since %v", device.OfflineSince)
+		}
+
+		events := webhookEvents(t)
+		if len(events) != 2 || events[1] != db.WebhookDeviceOnline {
+			t.Errorf("Expected a device.online webhook, got %v", events)
+		}
+	})
+}
+
+func TestPollMessagesHandler_LongPoll(t *testing.T) {
+	setupGatewayTestDB(t)
+	defer teardownTestDB()
+
+	app := setupGatewayTestApp()
+
+	device, err := db.CreateDevice("long_poll_device", nil)
+	if err != nil {
+		t.Fatalf("Failed to create test device: %v", err)
+	}
+	if err := db.SetDeviceTopics(device.ID, []string{"otp"}); err != nil {
+		t.Fatalf("Failed to set device topics: %v", err)
+	}
+
+	poll := func(t *testing.T, query string) (int, PollResponse, time.Duration) {
+		req := httptest.NewRequest("GET", "/gateway/poll"+query, nil)
+		req.Header.Set("X-Device-Key", "long_poll_device")
+
+		started := time.Now()
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("Failed to perform request: %v", err)
+		}
+		defer resp.Body.Close()
+		elapsed := time.Since(started)
+
+		var response PollResponse
+		if resp.StatusCode == fiber.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+		}
+		return resp.StatusCode, response, elapsed
+	}
+
+	t.Run("Invalid wait", func(t *testing.T) {
+		if status, _, _ := poll(t, "?wait=soon"); status != fiber.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", status)
+		}
+	})
+
+	t.Run("Times out without messages", func(t *testing.T) {
+		status, response, elapsed := poll(t, "?wait=300ms")
+		if status != fiber.StatusOK || len(response.Messages) != 0 {
+			t.Fatalf("Expected an empty response, got %d with %d messages", status, len(response.Messages))
+		}
+		if elapsed < 300*time.Millisecond {
+			t.Errorf("Expected the request to wait, returned after %v", elapsed)
+		}
+	})
+
+	t.Run("Messages for other topics do not wake the device", func(t *testing.T) {
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			db.CreateMessage("marketing", "+258841234567", "Weekend sale!")
+		}()
+
+		_, response, elapsed := poll(t, "?wait=400ms")
+		if len(response.Messages) != 0 || elapsed < 400*time.Millisecond {
+			t.Errorf("Expected to time out empty, got %d messages after %v", len(response.Messages), elapsed)
+		}
+	})
+
+	t.Run("Returns as soon as a message is queued", func(t *testing.T) {
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			db.CreateMessage("otp", "+258841234567", "Your OTP is 123456")
+		}()
+
+		status, response, elapsed := poll(t, "?wait=5")
+		if status != fiber.StatusOK || len(response.Messages) != 1 {
+			t.Fatalf("Expected 1 message, got %d with %d messages", status, len(response.Messages))
+		}
+		if elapsed >= 2*time.Second {
+			t.Errorf("Expected the request to return early, took %v", elapsed)
+		}
+	})
+
+	t.Run("Pending messages are returned without waiting", func(t *testing.T) {
+		if _, err := db.CreateMessage("otp", "+258841234568", "Your OTP is 654321"); err != nil {
+			t.Fatalf("Failed to create message: %v", err)
+		}
+
+		_, response, elapsed := poll(t, "?wait=5s")
+		if len(response.Messages) != 1 || elapsed >= time.Second {
+			t.Errorf("Expected 1 message immediately, got %d after %v", len(response.Messages), elapsed)
+		}
+	})
+
+	t.Run("Wait is capped by LONG_POLL_MAX_WAIT_SECONDS", func(t *testing.T) {
+		t.Setenv("LONG_POLL_MAX_WAIT_SECONDS", "1")
+
+		_, _, elapsed := poll(t, "?wait=30s")
+		if elapsed >= 3*time.Second {
+			t.Errorf("Expected the wait to be capped at 1s, took %v", elapsed)
+		}
+	})
+}
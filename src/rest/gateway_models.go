@@ -1,9 +1,12 @@
 package rest
 
+import "time"
+
 type PollMessage struct {
-	ID       string `json:"id"`
-	ToNumber string `json:"to_number"`
-	Body     string `json:"body"`
+	ID          string    `json:"id"`
+	ToNumber    string    `json:"to_number"`
+	Body        string    `json:"body"`
+	LeasedUntil time.Time `json:"leased_until"`
 }
 
 type PollResponse struct {
@@ -14,3 +17,41 @@ type StatusUpdateRequest struct {
 	Status string  `json:"status" validate:"required"`
 	Reason *string `json:"reason,omitempty"`
 }
+
+type SimSlot struct {
+	Slot           int     `json:"slot"`
+	Operator       *string `json:"operator,omitempty"`
+	PhoneNumber    *string `json:"phone_number,omitempty"`
+	SignalStrength *int    `json:"signal_strength,omitempty"`
+}
+
+type HeartbeatRequest struct {
+	BatteryLevel    *int      `json:"battery_level,omitempty"`
+	Charging        *bool     `json:"charging,omitempty"`
+	SignalStrength  *int      `json:"signal_strength,omitempty"`
+	NetworkOperator *string   `json:"network_operator,omitempty"`
+	SimSlots        []SimSlot `json:"sim_slots,omitempty"`
+	AppVersion      *string   `json:"app_version,omitempty"`
+}
+
+// GatewayFrame is the envelope of every frame a device sends over
+// /gateway/ws. The remaining fields are those of the matching REST request.
+type GatewayFrame struct {
+	Type      string `json:"type"`
+	Ref       string `json:"ref,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+}
+
+type GatewayMessagesFrame struct {
+	Type     string        `json:"type"`
+	Messages []PollMessage `json:"messages"`
+}
+
+type GatewayResultFrame struct {
+	Type    string      `json:"type"`
+	Ref     string      `json:"ref,omitempty"`
+	Status  int         `json:"status"`
+	Message string      `json:"message,omitempty"`
+	Error   string      `json:"error,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
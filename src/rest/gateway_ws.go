@@ -0,0 +1,354 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sms-gateway-api/db"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	gatewayDeviceLocal = "gatewayDevice"
+
+	// A new batch is claimed whenever fewer messages than this are waiting
+	// for a status from the device.
+	gatewayMaxInFlight = 10
+
+	gatewayWriteTimeout = 10 * time.Second
+)
+
+var errDeviceRevoked = errors.New("device was disabled, deleted or its key was rotated")
+
+func getGatewayPingInterval() time.Duration {
+	secondsStr := os.Getenv("GATEWAY_WS_PING_SECONDS")
+	if secondsStr == "" {
+		return 30 * time.Second
+	}
+
+	seconds, err := strconv.Atoi(secondsStr)
+	if err != nil || seconds <= 0 {
+		return 30 * time.Second
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// GatewayWebSocketUpgrade authenticates the device before the upgrade so a
+// rejected device gets a regular HTTP error response.
+func GatewayWebSocketUpgrade(c *fiber.Ctx) error {
+	device, err := authenticateDevice(c)
+	if device == nil {
+		return err
+	}
+
+	if !websocket.IsWebSocketUpgrade(c) {
+		return c.Status(fiber.StatusUpgradeRequired).JSON(fiber.Map{
+			"error": "WebSocket upgrade required",
+		})
+	}
+
+	c.Locals(gatewayDeviceLocal, device)
+	return c.Next()
+}
+
+// GatewayWebSocketHandler pushes messages to a connected device and accepts
+// status, heartbeat and inbound frames from it. Messages are claimed exactly
+// as GET /gateway/poll claims them.
+func GatewayWebSocketHandler(conn *websocket.Conn) {
+	device := conn.Locals(gatewayDeviceLocal).(*db.Device)
+
+	session := &gatewaySession{
+		conn:     conn,
+		device:   *device,
+		inFlight: map[string]time.Time{},
+	}
+
+	err := session.run()
+	if err != nil && !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		log.Printf("Gateway connection for device %d closed: %v", device.ID, err)
+	}
+}
+
+type gatewaySession struct {
+	conn   *websocket.Conn
+	device db.Device
+	topics []string
+
+	// inFlight holds the lease expiry of every message pushed to the device
+	// that has not been reported yet.
+	inFlight map[string]time.Time
+	claim    bool
+}
+
+func (s *gatewaySession) run() error {
+	pingInterval := getGatewayPingInterval()
+
+	topics, err := db.GetDeviceTopics(s.device.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get device topics: %w", err)
+	}
+	s.topics = topics
+
+	if err := db.UpdateDeviceLastPoll(s.device.ID); err != nil {
+		return fmt.Errorf("failed to update device poll time: %w", err)
+	}
+
+	// Messages leased to the device on an earlier connection are sent again
+	// first, so a reconnect neither loses them nor hands them to another
+	// device while the lease is still held.
+	leased, err := db.GetLeasedMessagesForDevice(s.device.ID)
+	if err != nil {
+		return err
+	}
+	if err := s.sendMessages(leased); err != nil {
+		return err
+	}
+
+	readDeadline := func() error {
+		return s.conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+	}
+	if err := readDeadline(); err != nil {
+		return err
+	}
+	s.conn.SetPongHandler(func(string) error {
+		return readDeadline()
+	})
+
+	incoming := make(chan []byte)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	readerStopped := make(chan struct{})
+
+	// The connection is released once the handler returns, so the reader
+	// must have stopped by then.
+	defer func() {
+		close(done)
+		s.conn.Close()
+		<-readerStopped
+	}()
+
+	go func() {
+		defer close(readerStopped)
+		for {
+			_, data, err := s.conn.ReadMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			if err := readDeadline(); err != nil {
+				readErr <- err
+				return
+			}
+
+			select {
+			case incoming <- data:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	s.claim = true
+	for {
+		// Subscribe before claiming so a message queued in between still
+		// wakes the connection.
+		ready, stop := db.WaitForMessages(s.topics)
+
+		if err := s.claimMessages(); err != nil {
+			stop()
+			return err
+		}
+
+		select {
+		case <-ready:
+			s.claim = true
+		case data := <-incoming:
+			err = s.handleFrame(data)
+		case err = <-readErr:
+		case <-ticker.C:
+			err = s.refresh()
+		}
+		stop()
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *gatewaySession) claimMessages() error {
+	if !s.claim || len(s.inFlight) >= gatewayMaxInFlight {
+		return nil
+	}
+	s.claim = false
+
+	// Disabling a device releases its messages, which wakes this session
+	// before the next refresh would notice.
+	if err := s.checkDevice(); err != nil {
+		return err
+	}
+
+	// Only top the device up to gatewayMaxInFlight unreported messages.
+	messages, err := db.GetPendingMessagesForDevice(s.device.ID, s.topics, gatewayMaxInFlight-len(s.inFlight))
+	if err != nil {
+		return err
+	}
+
+	return s.sendMessages(messages)
+}
+
+func (s *gatewaySession) sendMessages(messages []db.PollMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	for _, msg := range messages {
+		s.inFlight[msg.ID] = msg.LeasedUntil
+	}
+
+	return s.send(GatewayMessagesFrame{
+		Type:     "messages",
+		Messages: toPollMessages(messages),
+	})
+}
+
+// refresh runs on every ping. It keeps the device online, picks up topic
+// changes and ends the session once the device can no longer authenticate.
+func (s *gatewaySession) refresh() error {
+	if err := s.checkDevice(); err != nil {
+		return err
+	}
+
+	topics, err := db.GetDeviceTopics(s.device.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get device topics: %w", err)
+	}
+	s.topics = topics
+
+	if err := db.UpdateDeviceLastPoll(s.device.ID); err != nil {
+		return fmt.Errorf("failed to update device poll time: %w", err)
+	}
+
+	// Expired leases may already belong to another device.
+	now := time.Now()
+	for id, leasedUntil := range s.inFlight {
+		if !leasedUntil.After(now) {
+			delete(s.inFlight, id)
+		}
+	}
+	s.claim = true
+
+	return s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(gatewayWriteTimeout))
+}
+
+func (s *gatewaySession) checkDevice() error {
+	device, err := db.GetDeviceByID(s.device.ID)
+	if err != nil {
+		return err
+	}
+	if device == nil || device.Disabled || device.DeviceKey != s.device.DeviceKey {
+		s.close(websocket.ClosePolicyViolation, "Device is disabled or its key is no longer valid")
+		return errDeviceRevoked
+	}
+	return nil
+}
+
+func (s *gatewaySession) handleFrame(data []byte) error {
+	result := GatewayResultFrame{Type: "result", Status: fiber.StatusOK}
+
+	if err := s.dispatchFrame(data, &result); err != nil {
+		var fiberErr *fiber.Error
+		if !errors.As(err, &fiberErr) {
+			fiberErr = fiber.ErrInternalServerError
+		}
+		result.Status = fiberErr.Code
+		result.Message = ""
+		result.Error = fiberErr.Message
+		result.Data = nil
+	}
+
+	return s.send(result)
+}
+
+func (s *gatewaySession) dispatchFrame(data []byte, result *GatewayResultFrame) error {
+	var frame GatewayFrame
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid frame")
+	}
+	result.Ref = frame.Ref
+
+	switch frame.Type {
+	case "status":
+		if frame.MessageID == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "message_id is required")
+		}
+
+		var req StatusUpdateRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+
+		message, err := applyStatusUpdate(s.device.ID, frame.MessageID, req)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := s.inFlight[frame.MessageID]; ok {
+			delete(s.inFlight, frame.MessageID)
+			s.claim = true
+		}
+		result.Message = message
+
+	case "heartbeat":
+		var req HeartbeatRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+
+		if err := applyHeartbeat(s.device.ID, req); err != nil {
+			return err
+		}
+		result.Message = "Heartbeat recorded"
+
+	case "inbound":
+		var req InboundSMSRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+
+		response, err := storeInboundMessage(s.device.ID, req)
+		if err != nil {
+			return err
+		}
+		result.Status = fiber.StatusCreated
+		result.Message = response.Message
+		result.Data = response
+
+	default:
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid frame type. Must be one of: status, heartbeat, inbound")
+	}
+
+	return nil
+}
+
+func (s *gatewaySession) send(frame interface{}) error {
+	if err := s.conn.SetWriteDeadline(time.Now().Add(gatewayWriteTimeout)); err != nil {
+		return err
+	}
+	return s.conn.WriteJSON(frame)
+}
+
+func (s *gatewaySession) close(code int, text string) {
+	deadline := time.Now().Add(gatewayWriteTimeout)
+	_ = s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), deadline)
+}
@@ -0,0 +1,315 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sms-gateway-api/db"
+	"testing"
+	"time"
+
+	fastws "github.com/fasthttp/websocket"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+type gatewayTestFrame struct {
+	Type     string          `json:"type"`
+	Ref      string          `json:"ref"`
+	Status   int             `json:"status"`
+	Message  string          `json:"message"`
+	Error    string          `json:"error"`
+	Messages []PollMessage   `json:"messages"`
+	Data     json.RawMessage `json:"data"`
+}
+
+func TestGatewayWebSocket(t *testing.T) {
+	setupGatewayTestDB(t)
+	defer teardownTestDB()
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/gateway/poll", PollMessagesHandler)
+	app.Get("/gateway/ws", GatewayWebSocketUpgrade, websocket.New(GatewayWebSocketHandler))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go app.Listener(listener)
+	defer app.Shutdown()
+
+	url := "ws://" + listener.Addr().String() + "/gateway/ws"
+
+	var otherDevice *db.Device
+	for _, key := range []string{"ws_device", "ws_other_device"} {
+		device, err := db.CreateDevice(key, nil)
+		if err != nil {
+			t.Fatalf("Failed to create test device: %v", err)
+		}
+		if err := db.SetDeviceTopics(device.ID, []string{"otp"}); err != nil {
+			t.Fatalf("Failed to set device topics: %v", err)
+		}
+		otherDevice = device
+	}
+
+	dial := func(key string) (*fastws.Conn, *http.Response, error) {
+		header := http.Header{}
+		header.Set("X-Device-Key", key)
+		return fastws.DefaultDialer.Dial(url, header)
+	}
+
+	connect := func(t *testing.T) *fastws.Conn {
+		conn, _, err := dial("ws_device")
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		return conn
+	}
+
+	read := func(t *testing.T, conn *fastws.Conn) gatewayTestFrame {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var frame gatewayTestFrame
+		if err := conn.ReadJSON(&frame); err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		return frame
+	}
+
+	request := func(t *testing.T, conn *fastws.Conn, frame map[string]interface{}) gatewayTestFrame {
+		if err := conn.WriteJSON(frame); err != nil {
+			t.Fatalf("Failed to write frame: %v", err)
+		}
+		response := read(t, conn)
+		if response.Type != "result" || response.Ref != frame["ref"] {
+			t.Fatalf("Expected result for ref %v, got %+v", frame["ref"], response)
+		}
+		return response
+	}
+
+	t.Run("Rejects unknown device key", func(t *testing.T) {
+		_, resp, err := dial("unknown_device")
+		if err == nil {
+			t.Fatal("Expected the handshake to fail")
+		}
+		if resp == nil || resp.StatusCode != fiber.StatusUnauthorized {
+			t.Errorf("Expected status 401, got %v", resp)
+		}
+	})
+
+	t.Run("Rejects requests without an upgrade", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/gateway/ws", nil)
+		req.Header.Set("X-Device-Key", "ws_device")
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to perform request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != fiber.StatusUpgradeRequired {
+			t.Errorf("Expected status 426, got %d", resp.StatusCode)
+		}
+	})
+
+	pending, err := db.CreateMessage("otp", "+258841234567", "Your OTP is 123456")
+	if err != nil {
+		t.Fatalf("Failed to create message: %v", err)
+	}
+
+	foreign, err := db.CreateMessage("otp", "+258841234569", "Your OTP is 999999")
+	if err != nil {
+		t.Fatalf("Failed to create message: %v", err)
+	}
+	leaseToDevice(t, foreign.ID, otherDevice.ID)
+
+	conn := connect(t)
+
+	t.Run("Pushes pending messages on connect", func(t *testing.T) {
+		frame := read(t, conn)
+		if frame.Type != "messages" || len(frame.Messages) != 1 || frame.Messages[0].ID != pending.ID {
+			t.Fatalf("Expected the pending message, got %+v", frame)
+		}
+	})
+
+	var queuedID string
+	t.Run("Pushes newly queued messages", func(t *testing.T) {
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			db.CreateMessage("otp", "+258841234568", "Your OTP is 654321")
+		}()
+
+		frame := read(t, conn)
+		if frame.Type != "messages" || len(frame.Messages) != 1 || frame.Messages[0].ToNumber != "+258841234568" {
+			t.Fatalf("Expected the queued message, got %+v", frame)
+		}
+		queuedID = frame.Messages[0].ID
+	})
+
+	t.Run("Status update", func(t *testing.T) {
+		response := request(t, conn, map[string]interface{}{
+			"type": "status", "ref": "1", "message_id": pending.ID, "status": "sent",
+		})
+		if response.Status != fiber.StatusOK {
+			t.Fatalf("Expected status 200, got %+v", response)
+		}
+
+		message, err := db.GetMessageByID(pending.ID)
+		if err != nil {
+			t.Fatalf("Failed to get message: %v", err)
+		}
+		if message.Status != "sent" {
+			t.Errorf("Expected status 'sent', got '%s'", message.Status)
+		}
+	})
+
+	t.Run("Invalid status", func(t *testing.T) {
+		response := request(t, conn, map[string]interface{}{
+			"type": "status", "ref": "2", "message_id": pending.ID, "status": "bogus",
+		})
+		if response.Status != fiber.StatusBadRequest || response.Error == "" {
+			t.Errorf("Expected a 400 result, got %+v", response)
+		}
+	})
+
+	t.Run("Status for a message leased to another device", func(t *testing.T) {
+		response := request(t, conn, map[string]interface{}{
+			"type": "status", "ref": "6", "message_id": foreign.ID, "status": "failed", "reason": "No signal",
+		})
+		if response.Status != fiber.StatusForbidden || response.Error == "" {
+			t.Fatalf("Expected a 403 result, got %+v", response)
+		}
+
+		message, err := db.GetMessageByID(foreign.ID)
+		if err != nil {
+			t.Fatalf("Failed to get message: %v", err)
+		}
+		if message.Status != "pending" || message.AssignedDeviceID == nil || *message.AssignedDeviceID != otherDevice.ID {
+			t.Errorf("Expected the message to stay leased to the other device, got status '%s' device %v", message.Status, message.AssignedDeviceID)
+		}
+	})
+
+	t.Run("Heartbeat", func(t *testing.T) {
+		response := request(t, conn, map[string]interface{}{
+			"type": "heartbeat", "ref": "3", "battery_level": 55, "charging": true,
+		})
+		if response.Status != fiber.StatusOK {
+			t.Fatalf("Expected status 200, got %+v", response)
+		}
+
+		device, err := db.GetDeviceByKey("ws_device")
+		if err != nil {
+			t.Fatalf("Failed to get device: %v", err)
+		}
+		if device.Telemetry.BatteryLevel == nil || *device.Telemetry.BatteryLevel != 55 {
+			t.Errorf("Expected battery level 55, got %v", device.Telemetry.BatteryLevel)
+		}
+	})
+
+	t.Run("Inbound message", func(t *testing.T) {
+		response := request(t, conn, map[string]interface{}{
+			"type": "inbound", "ref": "4", "from_number": "+258841234567", "body": "Thanks",
+		})
+		if response.Status != fiber.StatusCreated {
+			t.Fatalf("Expected status 201, got %+v", response)
+		}
+
+		var inbound InboundSMSResponse
+		if err := json.Unmarshal(response.Data, &inbound); err != nil {
+			t.Fatalf("Failed to unmarshal result data: %v", err)
+		}
+		if inbound.ID == "" {
+			t.Error("Expected the stored inbound message id")
+		}
+	})
+
+	t.Run("Unknown frame type", func(t *testing.T) {
+		response := request(t, conn, map[string]interface{}{"type": "bogus", "ref": "5"})
+		if response.Status != fiber.StatusBadRequest {
+			t.Errorf("Expected status 400, got %+v", response)
+		}
+	})
+
+	t.Run("Reconnect redelivers unreported messages", func(t *testing.T) {
+		conn.Close()
+
+		req := httptest.NewRequest("GET", "/gateway/poll", nil)
+		req.Header.Set("X-Device-Key", "ws_other_device")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to perform request: %v", err)
+		}
+		var poll PollResponse
+		if err := json.NewDecoder(resp.Body).Decode(&poll); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		resp.Body.Close()
+		if len(poll.Messages) != 0 {
+			t.Fatalf("Expected the leased message to stay with the device, got %+v", poll.Messages)
+		}
+
+		conn = connect(t)
+		defer conn.Close()
+
+		frame := read(t, conn)
+		if frame.Type != "messages" || len(frame.Messages) != 1 || frame.Messages[0].ID != queuedID {
+			t.Fatalf("Expected the unreported message again, got %+v", frame)
+		}
+
+		message, err := db.GetMessageByID(queuedID)
+		if err != nil {
+			t.Fatalf("Failed to get message: %v", err)
+		}
+		if message.Attempts != 1 {
+			t.Errorf("Expected the message to keep its lease, got %d attempts", message.Attempts)
+		}
+	})
+
+	t.Run("Claims no more than the in-flight limit", func(t *testing.T) {
+		device, err := db.CreateDevice("ws_busy_device", nil)
+		if err != nil {
+			t.Fatalf("Failed to create test device: %v", err)
+		}
+		if err := db.SetDeviceTopics(device.ID, []string{"bulk"}); err != nil {
+			t.Fatalf("Failed to set device topics: %v", err)
+		}
+		if _, err := db.CreateMessage("bulk", "+258842000000", "Store opens at 9am"); err != nil {
+			t.Fatalf("Failed to create message: %v", err)
+		}
+
+		conn, _, err := dial("ws_busy_device")
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer conn.Close()
+
+		if frame := read(t, conn); len(frame.Messages) != 1 {
+			t.Fatalf("Expected the pending message, got %+v", frame)
+		}
+
+		var params []db.MessageParams
+		for i := 1; i <= 2*gatewayMaxInFlight; i++ {
+			params = append(params, db.MessageParams{Topic: "bulk", ToNumber: fmt.Sprintf("+25884200%04d", i), Body: "Store opens at 9am"})
+		}
+		if _, err := db.CreateMessagesBatch(params); err != nil {
+			t.Fatalf("Failed to create messages: %v", err)
+		}
+
+		frame := read(t, conn)
+		if frame.Type != "messages" || len(frame.Messages) != gatewayMaxInFlight-1 {
+			t.Fatalf("Expected %d more messages, got %d", gatewayMaxInFlight-1, len(frame.Messages))
+		}
+
+		response := request(t, conn, map[string]interface{}{
+			"type": "status", "ref": "6", "message_id": frame.Messages[0].ID, "status": "sent",
+		})
+		if response.Status != fiber.StatusOK {
+			t.Fatalf("Expected status 200, got %+v", response)
+		}
+
+		if frame := read(t, conn); frame.Type != "messages" || len(frame.Messages) != 1 {
+			t.Errorf("Expected one message to replace the reported one, got %d", len(frame.Messages))
+		}
+	})
+}
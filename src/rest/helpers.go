@@ -1,6 +1,8 @@
 package rest
 
 import (
+	"errors"
+
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -21,3 +23,28 @@ func ReturnInternalError(c *fiber.Ctx, message string) error {
 		"error": message,
 	})
 }
+
+func ReturnRecipientSuppressed(c *fiber.Ctx, err error) error {
+	return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+		"error": err.Error(),
+		"code":  "recipient_suppressed",
+	})
+}
+
+func ReturnForbidden(c *fiber.Ctx, message string) error {
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"error": message,
+	})
+}
+
+// ReturnError writes a *fiber.Error with its own status and message. Any
+// other error is reported as an internal error.
+func ReturnError(c *fiber.Ctx, err error) error {
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return c.Status(fiberErr.Code).JSON(fiber.Map{
+			"error": fiberErr.Message,
+		})
+	}
+	return ReturnInternalError(c, err.Error())
+}
@@ -0,0 +1,157 @@
+package rest
+
+import (
+	"math"
+	"sms-gateway-api/db"
+	"sms-gateway-api/sms"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func ReceiveInboundHandler(c *fiber.Ctx) error {
+	device, err := authenticateDevice(c)
+	if device == nil {
+		return err
+	}
+
+	var req InboundSMSRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ReturnBadRequest(c, "Invalid request body")
+	}
+
+	response, err := storeInboundMessage(device.ID, req)
+	if err != nil {
+		return ReturnError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+func storeInboundMessage(deviceID uint, req InboundSMSRequest) (*InboundSMSResponse, error) {
+	if req.FromNumber == "" {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "from_number is required")
+	}
+
+	if req.Body == "" {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Body is required")
+	}
+
+	if req.SimSlot != nil && *req.SimSlot < 0 {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "sim_slot must not be negative")
+	}
+
+	receivedAt := time.Now().UTC()
+	if req.ReceivedAt != "" {
+		var err error
+		receivedAt, err = time.Parse(time.RFC3339, req.ReceivedAt)
+		if err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid received_at format. Use ISO 8601 format (e.g., 2026-01-29T04:30:00Z)")
+		}
+	}
+
+	inbound, err := db.CreateInboundMessage(db.InboundParams{
+		DeviceID:   deviceID,
+		FromNumber: normalizeSender(req.FromNumber),
+		Body:       req.Body,
+		SimSlot:    req.SimSlot,
+		ReceivedAt: receivedAt,
+	})
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "Failed to store inbound message")
+	}
+
+	return &InboundSMSResponse{
+		Message:          "Inbound message stored",
+		ID:               inbound.ID,
+		Topic:            inbound.Topic,
+		ReplyToMessageID: inbound.ReplyToMessageID,
+		OptOut:           inbound.OptOut,
+	}, nil
+}
+
+func ListInboundHandler(c *fiber.Ctx) error {
+	page := c.QueryInt("page", 1)
+	if page < 1 {
+		page = 1
+	}
+
+	limit := c.QueryInt("limit", 20)
+	if limit < 1 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	deviceID := c.QueryInt("device_id", 0)
+	if deviceID < 0 {
+		return ReturnBadRequest(c, "Invalid device_id value")
+	}
+
+	topic := c.Query("topic")
+	if topic != "" && !topicAllowed(c, topic) {
+		return returnTopicForbidden(c, topic)
+	}
+
+	filters := db.InboundFilters{
+		Topic:      topic,
+		Topics:     allowedTopics(c),
+		FromNumber: normalizeSender(c.Query("from_number")),
+		Keyword:    c.Query("keyword"),
+		DeviceID:   uint(deviceID),
+		Limit:      limit,
+		Offset:     (page - 1) * limit,
+	}
+
+	messages, err := db.GetInboundMessages(filters)
+	if err != nil {
+		return ReturnInternalError(c, "Failed to retrieve inbound messages")
+	}
+
+	total, err := db.CountInboundMessages(filters)
+	if err != nil {
+		return ReturnInternalError(c, "Failed to count inbound messages")
+	}
+
+	details := make([]InboundMessageDetail, len(messages))
+	for i, msg := range messages {
+		details[i] = InboundMessageDetail{
+			ID:               msg.ID,
+			FromNumber:       msg.FromNumber,
+			Body:             msg.Body,
+			DeviceID:         msg.DeviceID,
+			SimSlot:          msg.SimSlot,
+			Topic:            msg.Topic,
+			ReplyToMessageID: msg.ReplyToMessageID,
+			OptOut:           msg.OptOut,
+			ReceivedAt:       msg.ReceivedAt,
+			CreatedAt:        msg.CreatedAt,
+		}
+	}
+
+	response := InboundListResponse{
+		Data: details,
+		Pagination: PaginationInfo{
+			Page:       page,
+			Limit:      limit,
+			Total:      total,
+			TotalPages: int(math.Ceil(float64(total) / float64(limit))),
+		},
+	}
+
+	return c.JSON(response)
+}
+
+// Alphanumeric sender IDs (e.g. "MPESA") are not phone numbers and are
+// kept as received.
+func normalizeSender(from string) string {
+	if from == "" {
+		return from
+	}
+	normalized, err := sms.NormalizePhoneNumber(from)
+	if err != nil {
+		return from
+	}
+	return normalized
+}
@@ -0,0 +1,222 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"sms-gateway-api/db"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func setupInboundTestApp() *fiber.App {
+	app := fiber.New()
+	app.Post("/gateway/inbound", ReceiveInboundHandler)
+	app.Get("/inbound", ListInboundHandler)
+	return app
+}
+
+func TestReceiveInboundHandler(t *testing.T) {
+	setupTestDB(t)
+	defer teardownTestDB()
+
+	app := setupInboundTestApp()
+
+	if _, err := db.CreateDevice("inbound_device", nil); err != nil {
+		t.Fatalf("Failed to create test device: %v", err)
+	}
+	reminder, err := db.CreateMessage("reminders", "+258841234567", "Reply YES to confirm your appointment")
+	if err != nil {
+		t.Fatalf("Failed to create test message: %v", err)
+	}
+	if err := reportStatus(reminder.ID, "sent", nil); err != nil {
+		t.Fatalf("Failed to update message status: %v", err)
+	}
+	if _, err := db.CreateWebhookSubscription("reminders", "https://example.com/hooks/replies", []string{"message.received"}); err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		deviceKey      string
+		payload        interface{}
+		expectedStatus int
+		checkResponse  func(t *testing.T, body []byte)
+	}{
+		{
+			name:           "Missing device key",
+			deviceKey:      "",
+			payload:        InboundSMSRequest{FromNumber: "+258841234567", Body: "YES"},
+			expectedStatus: fiber.StatusUnauthorized,
+		},
+		{
+			name:           "Invalid device key",
+			deviceKey:      "invalid_key",
+			payload:        InboundSMSRequest{FromNumber: "+258841234567", Body: "YES"},
+			expectedStatus: fiber.StatusUnauthorized,
+		},
+		{
+			name:           "Missing from_number",
+			deviceKey:      "inbound_device",
+			payload:        InboundSMSRequest{Body: "YES"},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Invalid received_at",
+			deviceKey:      "inbound_device",
+			payload:        InboundSMSRequest{FromNumber: "+258841234567", Body: "YES", ReceivedAt: "yesterday"},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:      "Reply is routed to the topic of the last message sent to the number",
+			deviceKey: "inbound_device",
+			payload: InboundSMSRequest{
+				FromNumber: "+258841234567",
+				Body:       "YES",
+				ReceivedAt: "2026-01-29T04:30:00Z",
+				SimSlot:    intPtr(1),
+			},
+			expectedStatus: fiber.StatusCreated,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response InboundSMSResponse
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.Topic == nil || *response.Topic != "reminders" {
+					t.Errorf("Expected topic 'reminders', got %v", response.Topic)
+				}
+				if response.ReplyToMessageID == nil || *response.ReplyToMessageID != reminder.ID {
+					t.Errorf("Expected reply to %s, got %v", reminder.ID, response.ReplyToMessageID)
+				}
+
+				deliveries, _, err := db.GetWebhookDeliveries("pending", 10, 0)
+				if err != nil {
+					t.Fatalf("Failed to get webhook deliveries: %v", err)
+				}
+				if len(deliveries) != 1 || deliveries[0].Event != "message.received" {
+					t.Errorf("Expected 1 message.received webhook, got %+v", deliveries)
+				}
+			},
+		},
+		{
+			name:           "Message from unknown sender is stored without topic",
+			deviceKey:      "inbound_device",
+			payload:        InboundSMSRequest{FromNumber: "+258829999999", Body: "Hello"},
+			expectedStatus: fiber.StatusCreated,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response InboundSMSResponse
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.Topic != nil || response.ReplyToMessageID != nil {
+					t.Errorf("Expected no routing, got %+v", response)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bodyBytes, err := json.Marshal(tt.payload)
+			if err != nil {
+				t.Fatalf("Failed to marshal payload: %v", err)
+			}
+
+			req := httptest.NewRequest("POST", "/gateway/inbound", bytes.NewReader(bodyBytes))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.deviceKey != "" {
+				req.Header.Set("X-Device-Key", tt.deviceKey)
+			}
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Failed to perform request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read response body: %v", err)
+			}
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d. Response: %s", tt.expectedStatus, resp.StatusCode, string(body))
+			}
+
+			if tt.checkResponse != nil {
+				tt.checkResponse(t, body)
+			}
+		})
+	}
+}
+
+func TestListInboundHandler(t *testing.T) {
+	setupTestDB(t)
+	defer teardownTestDB()
+
+	app := setupInboundTestApp()
+
+	device, err := db.CreateDevice("inbound_device", nil)
+	if err != nil {
+		t.Fatalf("Failed to create test device: %v", err)
+	}
+	reminder, err := db.CreateMessage("reminders", "+258841234567", "Reply YES to confirm your appointment")
+	if err != nil {
+		t.Fatalf("Failed to create test message: %v", err)
+	}
+	if err := reportStatus(reminder.ID, "sent", nil); err != nil {
+		t.Fatalf("Failed to update message status: %v", err)
+	}
+	for _, params := range []db.InboundParams{
+		{DeviceID: device.ID, FromNumber: "+258841234567", Body: "YES"},
+		{DeviceID: device.ID, FromNumber: "+258829999999", Body: "Who is this?"},
+	} {
+		params.ReceivedAt = time.Now()
+		if _, err := db.CreateInboundMessage(params); err != nil {
+			t.Fatalf("Failed to create inbound message: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name          string
+		queryParams   string
+		expectedTotal int
+	}{
+		{name: "All inbound messages", queryParams: "", expectedTotal: 2},
+		{name: "Filter by topic", queryParams: "?topic=reminders", expectedTotal: 1},
+		{name: "Filter by from_number", queryParams: "?from_number=%2B258829999999", expectedTotal: 1},
+		{name: "Filter by keyword", queryParams: "?keyword=yes", expectedTotal: 1},
+		{name: "Filter by unknown device", queryParams: "?device_id=999", expectedTotal: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/inbound"+tt.queryParams, nil)
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Failed to perform request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != fiber.StatusOK {
+				body, _ := io.ReadAll(resp.Body)
+				t.Fatalf("Expected status %d, got %d. Response: %s", fiber.StatusOK, resp.StatusCode, string(body))
+			}
+
+			var response InboundListResponse
+			if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if response.Pagination.Total != tt.expectedTotal || len(response.Data) != tt.expectedTotal {
+				t.Errorf("Expected %d inbound messages, got %d (%d returned)", tt.expectedTotal, response.Pagination.Total, len(response.Data))
+			}
+		})
+	}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
@@ -0,0 +1,36 @@
+package rest
+
+import "time"
+
+type InboundSMSRequest struct {
+	FromNumber string `json:"from_number" validate:"required"`
+	Body       string `json:"body" validate:"required"`
+	ReceivedAt string `json:"received_at,omitempty"`
+	SimSlot    *int   `json:"sim_slot,omitempty"`
+}
+
+type InboundSMSResponse struct {
+	Message          string  `json:"message"`
+	ID               string  `json:"id"`
+	Topic            *string `json:"topic,omitempty"`
+	ReplyToMessageID *string `json:"reply_to_message_id,omitempty"`
+	OptOut           bool    `json:"opt_out"`
+}
+
+type InboundMessageDetail struct {
+	ID               string    `json:"id"`
+	FromNumber       string    `json:"from_number"`
+	Body             string    `json:"body"`
+	DeviceID         *uint     `json:"device_id"`
+	SimSlot          *int      `json:"sim_slot,omitempty"`
+	Topic            *string   `json:"topic,omitempty"`
+	ReplyToMessageID *string   `json:"reply_to_message_id,omitempty"`
+	OptOut           bool      `json:"opt_out"`
+	ReceivedAt       time.Time `json:"received_at"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+type InboundListResponse struct {
+	Data       []InboundMessageDetail `json:"data"`
+	Pagination PaginationInfo         `json:"pagination"`
+}
@@ -1,6 +1,9 @@
 package rest
 
 import (
+	"sms-gateway-api/db"
+
+	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/log"
 )
@@ -8,15 +11,59 @@ import (
 func Init(app *fiber.App) {
 	SetupSwagger(app)
 
-	app.Post("/messages", QueueSMSHandler)
-	app.Get("/messages", ListMessagesHandler)
-	app.Get("/reports", GetReportsHandler)
+	send := RequireAPIKey(db.ScopeSend)
+	read := RequireAPIKey(db.ScopeRead)
+	report := RequireAPIKey(db.ScopeReport)
+	admin := RequireAPIKey(db.ScopeAdmin)
+
+	app.Post("/messages", send, QueueSMSHandler)
+	app.Post("/messages/batch", send, QueueSMSBatchHandler)
+	app.Get("/messages", read, ListMessagesHandler)
+	app.Get("/messages/stream", read, StreamMessagesHandler)
+	app.Get("/messages/:id", read, GetMessageHandler)
+	app.Patch("/messages/:id", send, UpdateMessageHandler)
+	app.Delete("/messages/:id", send, CancelMessageHandler)
+	app.Get("/reports", report, GetReportsHandler)
+	app.Get("/inbound", read, ListInboundHandler)
+
+	app.Post("/templates", admin, CreateTemplateHandler)
+	app.Get("/templates", read, ListTemplatesHandler)
+	app.Get("/templates/:topic/:name", read, GetTemplateHandler)
+	app.Put("/templates/:topic/:name", admin, UpdateTemplateHandler)
+	app.Delete("/templates/:topic/:name", admin, DeleteTemplateHandler)
+
+	app.Get("/suppressions", admin, ListSuppressionsHandler)
+	app.Post("/suppressions", admin, CreateSuppressionHandler)
+	app.Delete("/suppressions/:id", admin, DeleteSuppressionHandler)
+
+	app.Post("/webhooks", admin, CreateWebhookHandler)
+	app.Get("/webhooks", admin, ListWebhooksHandler)
+	app.Delete("/webhooks/:id", admin, DeleteWebhookHandler)
+	app.Get("/webhooks/deliveries", admin, ListWebhookDeliveriesHandler)
+	app.Post("/webhooks/deliveries/:id/retry", admin, RetryWebhookDeliveryHandler)
+
+	app.Post("/api-keys", admin, CreateAPIKeyHandler)
+	app.Get("/api-keys", admin, ListAPIKeysHandler)
+	app.Delete("/api-keys/:id", admin, RevokeAPIKeyHandler)
+
+	app.Post("/admin/devices", admin, EnrollDeviceHandler)
+	app.Get("/admin/devices", admin, ListDevicesHandler)
+	app.Post("/admin/devices/pairing-codes", admin, CreatePairingCodeHandler)
+	app.Get("/admin/devices/:id", admin, GetDeviceHandler)
+	app.Patch("/admin/devices/:id", admin, UpdateDeviceHandler)
+	app.Delete("/admin/devices/:id", admin, DeleteDeviceHandler)
+	app.Post("/admin/devices/:id/rotate-key", admin, RotateDeviceKeyHandler)
+	app.Get("/admin/devices/:id/heartbeats", admin, ListDeviceHeartbeatsHandler)
 
 	app.Get("/devices", GetDeviceTopicsHandler)
 	app.Put("/devices", UpdateDeviceTopicsHandler)
 
+	app.Post("/gateway/pair", PairDeviceHandler)
 	app.Get("/gateway/poll", PollMessagesHandler)
 	app.Put("/gateway/status/:messageId", UpdateMessageStatusHandler)
+	app.Post("/gateway/inbound", ReceiveInboundHandler)
+	app.Post("/gateway/heartbeat", HeartbeatHandler)
+	app.Get("/gateway/ws", GatewayWebSocketUpgrade, websocket.New(GatewayWebSocketHandler))
 
 	log.Info("REST API started")
 }
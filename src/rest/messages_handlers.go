@@ -1,11 +1,19 @@
 package rest
 
 import (
+	"errors"
+	"fmt"
 	"math"
+	"os"
+	"slices"
 	"sms-gateway-api/db"
+	"sms-gateway-api/sms"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 func QueueSMSHandler(c *fiber.Ctx) error {
@@ -14,21 +22,55 @@ func QueueSMSHandler(c *fiber.Ctx) error {
 		return ReturnBadRequest(c, "Invalid request body")
 	}
 
-	if req.Topic == "" {
-		return ReturnBadRequest(c, "Topic is required")
+	if !topicAllowed(c, req.Topic) {
+		return returnTopicForbidden(c, req.Topic)
 	}
 
-	if req.ToNumber == "" {
-		return ReturnBadRequest(c, "to_number is required")
+	params, err := buildMessageParams(req)
+	if err != nil {
+		return ReturnBadRequest(c, err.Error())
 	}
 
-	if req.Body == "" {
-		return ReturnBadRequest(c, "Body is required")
+	idempotencyKey := c.Get("Idempotency-Key")
+	if len(idempotencyKey) > 255 {
+		return ReturnBadRequest(c, "Idempotency-Key must be at most 255 characters")
+	}
+
+	// Keys are scoped to the API key so one client can never replay
+	// another client's message.
+	var apiKeyID uint
+	if key := requestAPIKey(c); key != nil {
+		apiKeyID = key.ID
+	}
+
+	if idempotencyKey != "" {
+		existing, err := db.FindMessageByIdempotencyKey(apiKeyID, idempotencyKey)
+		if err != nil {
+			return ReturnInternalError(c, "Failed to queue message")
+		}
+
+		if existing != nil {
+			return replayIdempotentRequest(c, existing, params)
+		}
+
+		params.IdempotencyKey = idempotencyKey
+		params.IdempotencyOwner = apiKeyID
 	}
 
-	message, err := db.CreateMessage(req.Topic, req.ToNumber, req.Body)
+	message, err := db.CreateMessageWithParams(params)
 	if err != nil {
-		if strings.Contains(err.Error(), "duplicate message") {
+		// A concurrent request with the same key was stored first.
+		if errors.Is(err, db.ErrIdempotencyKeyExists) {
+			existing, err := db.FindMessageByIdempotencyKey(apiKeyID, idempotencyKey)
+			if err != nil || existing == nil {
+				return ReturnInternalError(c, "Failed to queue message")
+			}
+			return replayIdempotentRequest(c, existing, params)
+		}
+		if errors.Is(err, db.ErrRecipientSuppressed) {
+			return ReturnRecipientSuppressed(c, err)
+		}
+		if errors.Is(err, db.ErrDuplicateMessage) {
 			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
 				"error": err.Error(),
 			})
@@ -36,19 +78,341 @@ func QueueSMSHandler(c *fiber.Ctx) error {
 		return ReturnInternalError(c, "Failed to queue message")
 	}
 
-	response := QueueSMSResponse{
-		Message: "Message queued successfully",
-		ID:      message.ID,
+	return c.Status(fiber.StatusCreated).JSON(queueSMSResponse(message))
+}
+
+func replayIdempotentRequest(c *fiber.Ctx, existing *db.Message, params db.MessageParams) error {
+	if existing.Topic != params.Topic || existing.ToNumber != params.ToNumber || existing.Body != params.Body {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Idempotency-Key was already used for a different request",
+		})
+	}
+
+	c.Set("Idempotent-Replayed", "true")
+	return c.Status(fiber.StatusCreated).JSON(queueSMSResponse(existing))
+}
+
+func queueSMSResponse(message *db.Message) QueueSMSResponse {
+	return QueueSMSResponse{
+		Message:    "Message queued successfully",
+		ID:         message.ID,
+		Encoding:   message.Encoding,
+		Segments:   message.Segments,
+		Characters: message.Characters,
+		SendAt:     message.SendAt,
+		ExpiresAt:  message.ExpiresAt,
+	}
+}
+
+func QueueSMSBatchHandler(c *fiber.Ctx) error {
+	var req BatchSMSRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ReturnBadRequest(c, "Invalid request body")
+	}
+
+	items := req.Messages
+	if len(req.ToNumbers) > 0 {
+		if len(items) > 0 {
+			return ReturnBadRequest(c, "messages and to_numbers are mutually exclusive")
+		}
+		items = make([]QueueSMSRequest, len(req.ToNumbers))
+		for i, toNumber := range req.ToNumbers {
+			items[i] = req.QueueSMSRequest
+			items[i].ToNumber = toNumber
+		}
+	}
+
+	if len(items) == 0 {
+		return ReturnBadRequest(c, "messages or to_numbers is required")
+	}
+
+	maxSize := getBatchMaxSize()
+	if len(items) > maxSize {
+		return ReturnBadRequest(c, fmt.Sprintf("Batch too large. Maximum is %d messages", maxSize))
+	}
+
+	for _, item := range items {
+		if !topicAllowed(c, item.Topic) {
+			return returnTopicForbidden(c, item.Topic)
+		}
+	}
+
+	results := make([]BatchItemResult, len(items))
+	var params []db.MessageParams
+	var paramIndexes []int
+
+	for i, item := range items {
+		results[i] = BatchItemResult{Index: i, ToNumber: item.ToNumber}
+
+		p, err := buildMessageParams(item)
+		if err != nil {
+			results[i].Status = "invalid"
+			results[i].Error = err.Error()
+			continue
+		}
+
+		results[i].ToNumber = p.ToNumber
+		params = append(params, p)
+		paramIndexes = append(paramIndexes, i)
+	}
+
+	created, err := db.CreateMessagesBatch(params)
+	if err != nil {
+		return ReturnInternalError(c, "Failed to queue messages")
+	}
+
+	for j, result := range created {
+		i := paramIndexes[j]
+		if errors.Is(result.Err, db.ErrRecipientSuppressed) {
+			results[i].Status = "suppressed"
+			results[i].Error = result.Err.Error()
+			continue
+		}
+		if result.Err != nil {
+			results[i].Status = "duplicate"
+			results[i].Error = result.Err.Error()
+			continue
+		}
+		results[i].Status = "created"
+		results[i].ID = result.Message.ID
+	}
+
+	response := BatchSMSResponse{Results: results}
+	for _, result := range results {
+		switch result.Status {
+		case "created":
+			response.Created++
+		case "duplicate":
+			response.Duplicates++
+		case "invalid":
+			response.Invalid++
+		case "suppressed":
+			response.Suppressed++
+		}
+	}
+
+	return c.JSON(response)
+}
+
+func getBatchMaxSize() int {
+	sizeStr := os.Getenv("BATCH_MAX_SIZE")
+	if sizeStr == "" {
+		return 1000
+	}
+
+	size, err := strconv.Atoi(sizeStr)
+	if err != nil || size <= 0 {
+		return 1000
+	}
+
+	return size
+}
+
+func prepareBody(body string) (string, error) {
+	if sms.TransliterationEnabled() {
+		body = sms.Transliterate(body)
+	}
+
+	stats := sms.AnalyzeBody(body)
+	if maxSegments := sms.GetMaxSegments(); stats.Segments > maxSegments {
+		return "", fmt.Errorf("Body is too long: %d %s segments exceeds the maximum of %d", stats.Segments, stats.Encoding, maxSegments)
+	}
+
+	return body, nil
+}
+
+const invalidToNumberMessage = "Invalid to_number. Use E.164 format (e.g., +258841234567)"
+
+func buildMessageParams(req QueueSMSRequest) (db.MessageParams, error) {
+	if req.Topic == "" {
+		return db.MessageParams{}, errors.New("Topic is required")
+	}
+
+	if req.ToNumber == "" {
+		return db.MessageParams{}, errors.New("to_number is required")
+	}
+
+	toNumber, err := sms.NormalizePhoneNumber(req.ToNumber)
+	if err != nil {
+		return db.MessageParams{}, errors.New(invalidToNumberMessage)
+	}
+
+	if req.Body != "" && req.Template != "" {
+		return db.MessageParams{}, errors.New("body and template are mutually exclusive")
+	}
+
+	locale, err := sms.NormalizeLocale(req.Locale)
+	if err != nil {
+		return db.MessageParams{}, errors.New("Invalid locale. Use a language tag such as pt or pt-MZ")
+	}
+
+	var template *db.MessageTemplate
+	if req.Template != "" {
+		template, err = db.FindTemplate(req.Topic, req.Template, locale, req.TemplateVersion)
+		if err != nil {
+			return db.MessageParams{}, errors.New("Failed to load template")
+		}
+		if template == nil {
+			return db.MessageParams{}, fmt.Errorf("Template '%s' not found for topic '%s'", req.Template, req.Topic)
+		}
+
+		rendered, missing := sms.RenderTemplate(template.Body, req.Variables)
+		if len(missing) > 0 {
+			return db.MessageParams{}, fmt.Errorf("Missing template variables: %s", strings.Join(missing, ", "))
+		}
+		req.Body = rendered
+		locale = template.Locale
+	}
+
+	if req.Body == "" {
+		return db.MessageParams{}, errors.New("Body is required")
+	}
+
+	body, err := prepareBody(req.Body)
+	if err != nil {
+		return db.MessageParams{}, err
+	}
+
+	if req.Priority != "" && !isValidPriority(req.Priority) {
+		return db.MessageParams{}, errors.New("Invalid priority. Must be one of: high, normal, bulk")
+	}
+
+	sendAt, err := parseSendAt(req.SendAt, req.Timezone)
+	if err != nil {
+		return db.MessageParams{}, err
+	}
+
+	expiresAt, err := parseExpiry(req.ExpiresAt, req.TTLSeconds, sendAt)
+	if err != nil {
+		return db.MessageParams{}, err
+	}
+
+	var callbackURL *string
+	if req.CallbackURL != "" {
+		if !isValidWebhookURL(req.CallbackURL) {
+			return db.MessageParams{}, errors.New("callback_url must be an absolute https URL on a public host")
+		}
+		if db.GetWebhookSigningSecret() == "" {
+			return db.MessageParams{}, errors.New("callback_url is not available until WEBHOOK_SIGNING_SECRET is set")
+		}
+		callbackURL = &req.CallbackURL
+	}
+
+	params := db.MessageParams{
+		Topic:       req.Topic,
+		ToNumber:    toNumber,
+		Body:        body,
+		SendAt:      sendAt,
+		ExpiresAt:   expiresAt,
+		Priority:    req.Priority,
+		CallbackURL: callbackURL,
+		Locale:      locale,
+	}
+	if template != nil {
+		params.TemplateName = template.Name
+		params.TemplateVersion = template.Version
 	}
 
-	return c.Status(fiber.StatusCreated).JSON(response)
+	return params, nil
+}
+
+func parseSendAt(sendAt, timezone string) (*time.Time, error) {
+	if sendAt == "" {
+		if timezone != "" {
+			return nil, fmt.Errorf("timezone requires send_at")
+		}
+		return nil, nil
+	}
+
+	location := time.UTC
+	if timezone != "" {
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone: %s", timezone)
+		}
+		location = loc
+	}
+
+	if t, err := time.Parse(time.RFC3339, sendAt); err == nil {
+		return &t, nil
+	}
+
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, sendAt, location); err == nil {
+			return &t, nil
+		}
+	}
+
+	return nil, fmt.Errorf("invalid send_at format: use ISO 8601 format (e.g., 2026-01-01T09:00:00Z, or 2026-01-01T09:00:00 with timezone)")
+}
+
+func parseExpiry(expiresAt string, ttlSeconds int, sendAt *time.Time) (*time.Time, error) {
+	if expiresAt != "" && ttlSeconds != 0 {
+		return nil, fmt.Errorf("expires_at and ttl_seconds are mutually exclusive")
+	}
+
+	if ttlSeconds < 0 {
+		return nil, fmt.Errorf("ttl_seconds must be positive")
+	}
+
+	var expiry time.Time
+	if ttlSeconds > 0 {
+		start := time.Now()
+		if sendAt != nil && sendAt.After(start) {
+			start = *sendAt
+		}
+		expiry = start.Add(time.Duration(ttlSeconds) * time.Second)
+	} else if expiresAt != "" {
+		t, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expires_at format: use ISO 8601 format (e.g., 2026-01-01T09:05:00Z)")
+		}
+		expiry = t
+	} else {
+		return nil, nil
+	}
+
+	if !expiry.After(time.Now()) {
+		return nil, fmt.Errorf("expires_at must be in the future")
+	}
+
+	if sendAt != nil && !expiry.After(*sendAt) {
+		return nil, fmt.Errorf("expires_at must be after send_at")
+	}
+
+	return &expiry, nil
+}
+
+func isValidPriority(priority string) bool {
+	return priority == "high" || priority == "normal" || priority == "bulk"
+}
+
+var messageStatuses = []string{"pending", "scheduled", "sent", "delivered", "undelivered", "failed", "expired", "cancelled"}
+
+func isValidStatusFilter(status string) bool {
+	return slices.Contains(messageStatuses, status)
+}
+
+func messageStatus(msg db.Message) string {
+	if msg.Status == "pending" && msg.SendAt != nil && msg.SendAt.After(time.Now()) {
+		return "scheduled"
+	}
+	return msg.Status
 }
 
 func ListMessagesHandler(c *fiber.Ctx) error {
 	topic := c.Query("topic")
 	toNumber := c.Query("to_number")
+	if toNumber != "" {
+		normalized, err := sms.NormalizePhoneNumber(toNumber)
+		if err != nil {
+			return ReturnBadRequest(c, invalidToNumberMessage)
+		}
+		toNumber = normalized
+	}
 	keyword := c.Query("keyword")
 	status := c.Query("status")
+	priority := c.Query("priority")
 
 	page := c.QueryInt("page", 1)
 	if page < 1 {
@@ -63,17 +427,27 @@ func ListMessagesHandler(c *fiber.Ctx) error {
 		limit = 100
 	}
 
-	if status != "" && status != "pending" && status != "sent" && status != "failed" {
-		return ReturnBadRequest(c, "Invalid status value. Must be one of: pending, sent, failed")
+	if status != "" && !isValidStatusFilter(status) {
+		return ReturnBadRequest(c, "Invalid status value. Must be one of: "+strings.Join(messageStatuses, ", "))
+	}
+
+	if priority != "" && !isValidPriority(priority) {
+		return ReturnBadRequest(c, "Invalid priority value. Must be one of: high, normal, bulk")
+	}
+
+	if topic != "" && !topicAllowed(c, topic) {
+		return returnTopicForbidden(c, topic)
 	}
 
 	offset := (page - 1) * limit
 
 	filters := db.MessageFilters{
 		Topic:    topic,
+		Topics:   allowedTopics(c),
 		ToNumber: toNumber,
 		Keyword:  keyword,
 		Status:   status,
+		Priority: priority,
 		Limit:    limit,
 		Offset:   offset,
 	}
@@ -92,17 +466,7 @@ func ListMessagesHandler(c *fiber.Ctx) error {
 
 	messageDetails := make([]MessageDetail, len(messages))
 	for i, msg := range messages {
-		messageDetails[i] = MessageDetail{
-			ID:            msg.ID,
-			Topic:         msg.Topic,
-			ToNumber:      msg.ToNumber,
-			Body:          msg.Body,
-			Status:        msg.Status,
-			CreatedAt:     msg.CreatedAt,
-			SentAt:        msg.SentAt,
-			FailedAt:      msg.FailedAt,
-			FailureReason: msg.FailureReason,
-		}
+		messageDetails[i] = toMessageDetail(msg)
 	}
 
 	response := MessagesListResponse{
@@ -117,3 +481,185 @@ func ListMessagesHandler(c *fiber.Ctx) error {
 
 	return c.JSON(response)
 }
+
+func GetMessageHandler(c *fiber.Ctx) error {
+	messageID := c.Params("id")
+
+	message, err := db.GetMessageWithHistory(messageID)
+	if err != nil {
+		return ReturnInternalError(c, "Failed to retrieve message")
+	}
+	if message == nil || !topicAllowed(c, message.Topic) {
+		return ReturnNotFound(c, "Message not found")
+	}
+
+	response := MessageLifecycleResponse{
+		MessageDetail:  toMessageDetail(*message),
+		LeasedUntil:    message.LeasedUntil,
+		AttemptHistory: make([]MessageAttemptInfo, len(message.AttemptHistory)),
+		Events:         make([]MessageEventInfo, len(message.Events)),
+	}
+
+	if message.AssignedDevice != nil {
+		response.AssignedDevice = &AssignedDeviceInfo{
+			ID:   message.AssignedDevice.ID,
+			Name: message.AssignedDevice.Name,
+		}
+	}
+
+	for i, attempt := range message.AttemptHistory {
+		response.AttemptHistory[i] = MessageAttemptInfo{
+			Attempt:    attempt.Attempt,
+			DeviceID:   attempt.DeviceID,
+			Status:     attempt.Status,
+			Reason:     attempt.Reason,
+			StartedAt:  attempt.StartedAt,
+			FinishedAt: attempt.FinishedAt,
+		}
+	}
+
+	for i, event := range message.Events {
+		response.Events[i] = MessageEventInfo{
+			ID:        event.ID,
+			Type:      event.Type,
+			DeviceID:  event.DeviceID,
+			Detail:    event.Detail,
+			CreatedAt: event.CreatedAt,
+		}
+	}
+
+	return c.JSON(response)
+}
+
+func CancelMessageHandler(c *fiber.Ctx) error {
+	messageID := c.Params("id")
+
+	if requestAPIKey(c) != nil {
+		existing, err := db.GetMessageByID(messageID)
+		if err != nil {
+			return ReturnInternalError(c, "Failed to retrieve message")
+		}
+		if existing == nil || !topicAllowed(c, existing.Topic) {
+			return ReturnNotFound(c, "Message not found")
+		}
+	}
+
+	message, err := db.CancelMessage(messageID)
+	if err != nil {
+		return returnMessageEditError(c, err, "Failed to cancel message")
+	}
+
+	return c.JSON(toMessageDetail(*message))
+}
+
+func UpdateMessageHandler(c *fiber.Ctx) error {
+	messageID := c.Params("id")
+
+	var req UpdateMessageRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ReturnBadRequest(c, "Invalid request body")
+	}
+
+	if req.ToNumber != nil && *req.ToNumber == "" {
+		return ReturnBadRequest(c, "to_number cannot be empty")
+	}
+
+	if req.ToNumber != nil {
+		toNumber, err := sms.NormalizePhoneNumber(*req.ToNumber)
+		if err != nil {
+			return ReturnBadRequest(c, invalidToNumberMessage)
+		}
+		req.ToNumber = &toNumber
+	}
+
+	if req.Body != nil && *req.Body == "" {
+		return ReturnBadRequest(c, "Body cannot be empty")
+	}
+
+	if req.Body != nil {
+		body, err := prepareBody(*req.Body)
+		if err != nil {
+			return ReturnBadRequest(c, err.Error())
+		}
+		req.Body = &body
+	}
+
+	existing, err := db.GetMessageByID(messageID)
+	if err != nil {
+		return ReturnInternalError(c, "Failed to retrieve message")
+	}
+	if existing == nil || !topicAllowed(c, existing.Topic) {
+		return ReturnNotFound(c, "Message not found")
+	}
+
+	changes := db.MessageChanges{
+		ToNumber: req.ToNumber,
+		Body:     req.Body,
+	}
+
+	if req.SendAt != nil {
+		sendAt, err := parseSendAt(*req.SendAt, req.Timezone)
+		if err != nil {
+			return ReturnBadRequest(c, err.Error())
+		}
+		if sendAt != nil && existing.ExpiresAt != nil && !existing.ExpiresAt.After(*sendAt) {
+			return ReturnBadRequest(c, "send_at must be before expires_at")
+		}
+		changes.SetSendAt = true
+		changes.SendAt = sendAt
+	} else if req.Timezone != "" {
+		return ReturnBadRequest(c, "timezone requires send_at")
+	}
+
+	message, err := db.UpdatePendingMessage(messageID, changes)
+	if err != nil {
+		return returnMessageEditError(c, err, "Failed to update message")
+	}
+
+	return c.JSON(toMessageDetail(*message))
+}
+
+func returnMessageEditError(c *fiber.Ctx, err error, message string) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ReturnNotFound(c, "Message not found")
+	case errors.Is(err, db.ErrMessageNotPending), errors.Is(err, db.ErrMessageLeased):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, db.ErrRecipientSuppressed):
+		return ReturnRecipientSuppressed(c, err)
+	default:
+		return ReturnInternalError(c, message)
+	}
+}
+
+func toMessageDetail(msg db.Message) MessageDetail {
+	return MessageDetail{
+		ID:            msg.ID,
+		Topic:         msg.Topic,
+		ToNumber:      msg.ToNumber,
+		Body:          msg.Body,
+		Encoding:      msg.Encoding,
+		Segments:      msg.Segments,
+		Characters:    msg.Characters,
+		Status:        messageStatus(msg),
+		Priority:      msg.Priority,
+		CreatedAt:     msg.CreatedAt,
+		SendAt:        msg.SendAt,
+		ExpiresAt:     msg.ExpiresAt,
+		SentAt:        msg.SentAt,
+		DeliveredAt:   msg.DeliveredAt,
+		FailedAt:      msg.FailedAt,
+		FailureReason: msg.FailureReason,
+		ExpiredAt:     msg.ExpiredAt,
+		CancelledAt:   msg.CancelledAt,
+		Attempts:      msg.Attempts,
+		NextAttemptAt: msg.NextAttemptAt,
+		CallbackURL:   msg.CallbackURL,
+
+		Template:        msg.TemplateName,
+		TemplateVersion: msg.TemplateVersion,
+		Locale:          msg.Locale,
+	}
+}
@@ -3,10 +3,14 @@ package rest
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"net/http"
 	"net/http/httptest"
 	"os"
 	"sms-gateway-api/db"
+	"strings"
 	"testing"
 	"time"
 
@@ -16,7 +20,11 @@ import (
 func setupTestApp() *fiber.App {
 	app := fiber.New()
 	app.Post("/messages", QueueSMSHandler)
+	app.Post("/messages/batch", QueueSMSBatchHandler)
 	app.Get("/messages", ListMessagesHandler)
+	app.Get("/messages/:id", GetMessageHandler)
+	app.Patch("/messages/:id", UpdateMessageHandler)
+	app.Delete("/messages/:id", CancelMessageHandler)
 	return app
 }
 
@@ -51,6 +59,26 @@ func TestQueueSMSHandler(t *testing.T) {
 		expectedStatus int
 		checkResponse  func(t *testing.T, body []byte)
 	}{
+		{
+			name: "Invalid callback_url",
+			payload: QueueSMSRequest{
+				Topic:       "otp",
+				ToNumber:    "+1234567890",
+				Body:        "Your OTP code is 123456",
+				CallbackURL: "ftp://example.com/hooks",
+			},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name: "Private callback_url",
+			payload: QueueSMSRequest{
+				Topic:       "otp",
+				ToNumber:    "+1234567890",
+				Body:        "Your OTP code is 123456",
+				CallbackURL: "https://192.168.1.10/hooks",
+			},
+			expectedStatus: fiber.StatusBadRequest,
+		},
 		{
 			name: "Valid request",
 			payload: QueueSMSRequest{
@@ -250,6 +278,29 @@ func TestListMessagesHandler(t *testing.T) {
 			expectedStatus: fiber.StatusBadRequest,
 			checkResponse:  nil,
 		},
+		{
+			name:           "Filter by priority",
+			queryParams:    "?priority=normal",
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response MessagesListResponse
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if len(response.Data) != 2 {
+					t.Errorf("Expected 2 normal priority messages, got %d", len(response.Data))
+				}
+				if len(response.Data) > 0 && response.Data[0].Priority != "normal" {
+					t.Errorf("Expected priority 'normal', got '%s'", response.Data[0].Priority)
+				}
+			},
+		},
+		{
+			name:           "Invalid priority",
+			queryParams:    "?priority=urgent",
+			expectedStatus: fiber.StatusBadRequest,
+			checkResponse:  nil,
+		},
 		{
 			name:           "Pagination - page 1",
 			queryParams:    "?page=1&limit=1",
@@ -481,3 +532,1071 @@ func TestQueueSMSHandler_Deduplication(t *testing.T) {
 		}
 	})
 }
+
+func TestQueueSMSHandler_Scheduling(t *testing.T) {
+	setupTestDB(t)
+	defer teardownTestDB()
+
+	app := setupTestApp()
+
+	postMessage := func(t *testing.T, payload QueueSMSRequest) (int, []byte) {
+		bodyBytes, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatalf("Failed to marshal payload: %v", err)
+		}
+
+		req := httptest.NewRequest("POST", "/messages", bytes.NewReader(bodyBytes))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to perform request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Failed to read response body: %v", err)
+		}
+		return resp.StatusCode, body
+	}
+
+	sendAt := time.Now().UTC().Add(48 * time.Hour).Truncate(time.Second)
+	localSendAt := sendAt.In(time.FixedZone("", 2*60*60))
+
+	t.Run("Scheduled message in recipient timezone", func(t *testing.T) {
+		status, body := postMessage(t, QueueSMSRequest{
+			Topic:    "reminders",
+			ToNumber: "+1234567890",
+			Body:     "Reminder: your appointment is tomorrow",
+			SendAt:   localSendAt.Format("2006-01-02T15:04:05"),
+			Timezone: "Africa/Maputo",
+		})
+		if status != fiber.StatusCreated {
+			t.Fatalf("Expected status %d, got %d. Response: %s", fiber.StatusCreated, status, string(body))
+		}
+
+		var response QueueSMSResponse
+		if err := json.Unmarshal(body, &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		if response.SendAt == nil || !response.SendAt.Equal(sendAt) {
+			t.Errorf("Expected send_at %v, got %v", sendAt, response.SendAt)
+		}
+	})
+
+	t.Run("Scheduled message is listed as scheduled", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/messages?status=scheduled", nil)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to perform request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var response MessagesListResponse
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if len(response.Data) != 1 {
+			t.Fatalf("Expected 1 scheduled message, got %d", len(response.Data))
+		}
+		if response.Data[0].Status != "scheduled" {
+			t.Errorf("Expected status 'scheduled', got '%s'", response.Data[0].Status)
+		}
+	})
+
+	t.Run("Scheduled message is not handed out early", func(t *testing.T) {
+		device, err := db.CreateDevice("scheduling_device", nil)
+		if err != nil {
+			t.Fatalf("Failed to create test device: %v", err)
+		}
+		messages, err := db.GetPendingMessagesForDevice(device.ID, []string{"reminders"}, db.PollBatchSize)
+		if err != nil {
+			t.Fatalf("Failed to poll messages: %v", err)
+		}
+		if len(messages) != 0 {
+			t.Errorf("Expected 0 messages before send_at, got %d", len(messages))
+		}
+	})
+
+	invalidTests := []struct {
+		name    string
+		payload QueueSMSRequest
+	}{
+		{
+			name:    "Invalid send_at",
+			payload: QueueSMSRequest{Topic: "reminders", ToNumber: "+1234567890", Body: "Invalid send_at", SendAt: "tomorrow"},
+		},
+		{
+			name:    "Invalid timezone",
+			payload: QueueSMSRequest{Topic: "reminders", ToNumber: "+1234567890", Body: "Invalid timezone", SendAt: "2026-02-01T09:00:00", Timezone: "Mars/Olympus"},
+		},
+		{
+			name:    "Timezone without send_at",
+			payload: QueueSMSRequest{Topic: "reminders", ToNumber: "+1234567890", Body: "Timezone only", Timezone: "Africa/Maputo"},
+		},
+	}
+
+	for _, tt := range invalidTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, body := postMessage(t, tt.payload); status != fiber.StatusBadRequest {
+				t.Errorf("Expected status %d, got %d. Response: %s", fiber.StatusBadRequest, status, string(body))
+			}
+		})
+	}
+}
+
+func TestQueueSMSHandler_Expiry(t *testing.T) {
+	setupTestDB(t)
+	defer teardownTestDB()
+
+	app := setupTestApp()
+
+	tests := []struct {
+		name           string
+		payload        QueueSMSRequest
+		expectedStatus int
+	}{
+		{
+			name:           "Valid ttl_seconds",
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "+1234567890", Body: "Your OTP is 111111", TTLSeconds: 300},
+			expectedStatus: fiber.StatusCreated,
+		},
+		{
+			name:           "Valid expires_at",
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "+1234567890", Body: "Your OTP is 222222", ExpiresAt: time.Now().Add(time.Hour).UTC().Format(time.RFC3339)},
+			expectedStatus: fiber.StatusCreated,
+		},
+		{
+			name:           "Both expires_at and ttl_seconds",
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "+1234567890", Body: "Your OTP is 333333", TTLSeconds: 300, ExpiresAt: time.Now().Add(time.Hour).UTC().Format(time.RFC3339)},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "expires_at in the past",
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "+1234567890", Body: "Your OTP is 444444", ExpiresAt: time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "expires_at before send_at",
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "+1234567890", Body: "Your OTP is 555555", SendAt: time.Now().Add(2 * time.Hour).UTC().Format(time.RFC3339), ExpiresAt: time.Now().Add(time.Hour).UTC().Format(time.RFC3339)},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bodyBytes, err := json.Marshal(tt.payload)
+			if err != nil {
+				t.Fatalf("Failed to marshal payload: %v", err)
+			}
+
+			req := httptest.NewRequest("POST", "/messages", bytes.NewReader(bodyBytes))
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Failed to perform request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.expectedStatus {
+				body, _ := io.ReadAll(resp.Body)
+				t.Errorf("Expected status %d, got %d. Response: %s", tt.expectedStatus, resp.StatusCode, string(body))
+			}
+		})
+	}
+
+	t.Run("Expired message is never handed out and gets swept", func(t *testing.T) {
+		expiresAt := time.Now().Add(time.Minute)
+		msg, err := db.CreateMessageWithParams(db.MessageParams{
+			Topic:     "expiring",
+			ToNumber:  "+1234567890",
+			Body:      "Your OTP is 666666",
+			ExpiresAt: &expiresAt,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create test message: %v", err)
+		}
+		past := time.Now().UTC().Add(-time.Second)
+		db.GetDB().Model(&db.Message{}).Where("id = ?", msg.ID).Update("expires_at", past)
+
+		device, err := db.CreateDevice("expiry_device", nil)
+		if err != nil {
+			t.Fatalf("Failed to create test device: %v", err)
+		}
+		messages, err := db.GetPendingMessagesForDevice(device.ID, []string{"expiring"}, db.PollBatchSize)
+		if err != nil {
+			t.Fatalf("Failed to poll messages: %v", err)
+		}
+		if len(messages) != 0 {
+			t.Errorf("Expected 0 messages after expiry, got %d", len(messages))
+		}
+
+		expired, err := db.ExpireMessages()
+		if err != nil {
+			t.Fatalf("Failed to expire messages: %v", err)
+		}
+		if expired != 1 {
+			t.Errorf("Expected 1 expired message, got %d", expired)
+		}
+
+		message, err := db.GetMessageByID(msg.ID)
+		if err != nil {
+			t.Fatalf("Failed to get message: %v", err)
+		}
+		if message.Status != "expired" || message.ExpiredAt == nil {
+			t.Errorf("Expected status 'expired' with expired_at set, got '%s'", message.Status)
+		}
+	})
+
+	t.Run("Expired message can be queued again", func(t *testing.T) {
+		bodyBytes, err := json.Marshal(QueueSMSRequest{Topic: "expiring", ToNumber: "+1234567890", Body: "Your OTP is 666666"})
+		if err != nil {
+			t.Fatalf("Failed to marshal payload: %v", err)
+		}
+
+		req := httptest.NewRequest("POST", "/messages", bytes.NewReader(bodyBytes))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to perform request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != fiber.StatusCreated {
+			body, _ := io.ReadAll(resp.Body)
+			t.Errorf("Expected status %d, got %d. Response: %s", fiber.StatusCreated, resp.StatusCode, string(body))
+		}
+	})
+}
+
+func TestQueueSMSBatchHandler(t *testing.T) {
+	os.Setenv("BATCH_MAX_SIZE", "3")
+	defer os.Unsetenv("BATCH_MAX_SIZE")
+
+	setupTestDB(t)
+	defer teardownTestDB()
+
+	app := setupTestApp()
+
+	if _, err := db.CreateMessage("alerts", "+1111111111", "Alert: Login detected"); err != nil {
+		t.Fatalf("Failed to create test message: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		payload        interface{}
+		expectedStatus int
+		checkResponse  func(t *testing.T, body []byte)
+	}{
+		{
+			name: "Mixed results per item",
+			payload: BatchSMSRequest{
+				Messages: []QueueSMSRequest{
+					{Topic: "alerts", ToNumber: "+1234567890", Body: "Alert: Login detected"},
+					{Topic: "alerts", ToNumber: "+1111111111", Body: "Alert: Login detected"},
+					{Topic: "alerts", Body: "Alert: Missing number"},
+				},
+			},
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response BatchSMSResponse
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.Created != 1 || response.Duplicates != 1 || response.Invalid != 1 {
+					t.Errorf("Expected 1 created, 1 duplicate, 1 invalid, got %+v", response)
+				}
+				if len(response.Results) != 3 {
+					t.Fatalf("Expected 3 results, got %d", len(response.Results))
+				}
+				expected := []string{"created", "duplicate", "invalid"}
+				for i, result := range response.Results {
+					if result.Index != i || result.Status != expected[i] {
+						t.Errorf("Expected result %d to be '%s', got %+v", i, expected[i], result)
+					}
+				}
+				if response.Results[0].ID == "" {
+					t.Error("Expected created item to have an id")
+				}
+			},
+		},
+		{
+			name: "One body to many recipients",
+			payload: BatchSMSRequest{
+				QueueSMSRequest: QueueSMSRequest{Topic: "marketing", Body: "Store opens at 9am", Priority: "bulk"},
+				ToNumbers:       []string{"+2000000001", "+2000000002", "+2000000001"},
+			},
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response BatchSMSResponse
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.Created != 2 || response.Duplicates != 1 {
+					t.Errorf("Expected 2 created and 1 duplicate, got %+v", response)
+				}
+
+				messages, err := db.GetMessages(db.MessageFilters{Topic: "marketing"})
+				if err != nil {
+					t.Fatalf("Failed to get messages: %v", err)
+				}
+				if len(messages) != 2 {
+					t.Errorf("Expected 2 stored messages, got %d", len(messages))
+				}
+				for _, msg := range messages {
+					if msg.Priority != "bulk" {
+						t.Errorf("Expected priority 'bulk', got '%s'", msg.Priority)
+					}
+				}
+			},
+		},
+		{
+			name:           "Empty batch",
+			payload:        BatchSMSRequest{},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name: "Batch too large",
+			payload: BatchSMSRequest{
+				QueueSMSRequest: QueueSMSRequest{Topic: "marketing", Body: "Too many"},
+				ToNumbers:       []string{"+3000000001", "+3000000002", "+3000000003", "+3000000004"},
+			},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name: "Messages and to_numbers together",
+			payload: BatchSMSRequest{
+				QueueSMSRequest: QueueSMSRequest{Topic: "marketing", Body: "Ambiguous"},
+				ToNumbers:       []string{"+3000000001"},
+				Messages:        []QueueSMSRequest{{Topic: "alerts", ToNumber: "+3000000002", Body: "Ambiguous"}},
+			},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Invalid JSON",
+			payload:        "invalid json",
+			expectedStatus: fiber.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bodyBytes []byte
+			var err error
+
+			if str, ok := tt.payload.(string); ok {
+				bodyBytes = []byte(str)
+			} else {
+				bodyBytes, err = json.Marshal(tt.payload)
+				if err != nil {
+					t.Fatalf("Failed to marshal payload: %v", err)
+				}
+			}
+
+			req := httptest.NewRequest("POST", "/messages/batch", bytes.NewReader(bodyBytes))
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Failed to perform request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read response body: %v", err)
+			}
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d. Response: %s", tt.expectedStatus, resp.StatusCode, string(body))
+			}
+
+			if tt.checkResponse != nil {
+				tt.checkResponse(t, body)
+			}
+		})
+	}
+}
+
+func TestQueueSMSHandler_IdempotencyKey(t *testing.T) {
+	setupTestDB(t)
+	defer teardownTestDB()
+
+	app := setupTestApp()
+
+	send := func(t *testing.T, app *fiber.App, apiKey, key string, payload QueueSMSRequest) (*http.Response, QueueSMSResponse) {
+		bodyBytes, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatalf("Failed to marshal payload: %v", err)
+		}
+
+		req := httptest.NewRequest("POST", "/messages", bytes.NewReader(bodyBytes))
+		req.Header.Set("Content-Type", "application/json")
+		if apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+apiKey)
+		}
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to perform request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var response QueueSMSResponse
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Failed to read response body: %v", err)
+		}
+		json.Unmarshal(body, &response)
+		return resp, response
+	}
+
+	post := func(t *testing.T, key string, payload QueueSMSRequest) (*http.Response, QueueSMSResponse) {
+		return send(t, app, "", key, payload)
+	}
+
+	payload := QueueSMSRequest{
+		Topic:    "otp",
+		ToNumber: "+1234567890",
+		Body:     "Your OTP code is 123456",
+	}
+
+	var firstID string
+
+	t.Run("First request creates the message", func(t *testing.T) {
+		resp, response := post(t, "retry-key-1", payload)
+		if resp.StatusCode != fiber.StatusCreated {
+			t.Fatalf("Expected status %d, got %d", fiber.StatusCreated, resp.StatusCode)
+		}
+		firstID = response.ID
+	})
+
+	t.Run("Retried request returns the original response", func(t *testing.T) {
+		resp, response := post(t, "retry-key-1", payload)
+		if resp.StatusCode != fiber.StatusCreated {
+			t.Fatalf("Expected status %d, got %d", fiber.StatusCreated, resp.StatusCode)
+		}
+		if response.ID != firstID {
+			t.Errorf("Expected replayed id '%s', got '%s'", firstID, response.ID)
+		}
+		if resp.Header.Get("Idempotent-Replayed") != "true" {
+			t.Error("Expected Idempotent-Replayed header")
+		}
+
+		count, err := db.CountMessages(db.MessageFilters{})
+		if err != nil {
+			t.Fatalf("Failed to count messages: %v", err)
+		}
+		if count != 1 {
+			t.Errorf("Expected 1 stored message, got %d", count)
+		}
+	})
+
+	t.Run("New key bypasses content deduplication", func(t *testing.T) {
+		resp, response := post(t, "retry-key-2", payload)
+		if resp.StatusCode != fiber.StatusCreated {
+			t.Fatalf("Expected status %d, got %d", fiber.StatusCreated, resp.StatusCode)
+		}
+		if response.ID == firstID {
+			t.Error("Expected a new message for a new key")
+		}
+	})
+
+	t.Run("Reused key with different body is rejected", func(t *testing.T) {
+		different := payload
+		different.Body = "Your OTP code is 654321"
+		if resp, _ := post(t, "retry-key-1", different); resp.StatusCode != fiber.StatusUnprocessableEntity {
+			t.Errorf("Expected status %d, got %d", fiber.StatusUnprocessableEntity, resp.StatusCode)
+		}
+	})
+
+	t.Run("Expired key no longer replays", func(t *testing.T) {
+		os.Setenv("IDEMPOTENCY_KEY_TTL_MINUTES", "1")
+		defer os.Unsetenv("IDEMPOTENCY_KEY_TTL_MINUTES")
+
+		db.GetDB().Model(&db.Message{}).Where("id = ?", firstID).Update("created_at", time.Now().Add(-2*time.Minute))
+
+		different := payload
+		different.Body = "Your OTP code is 777777"
+		resp, response := post(t, "retry-key-1", different)
+		if resp.StatusCode != fiber.StatusCreated {
+			t.Fatalf("Expected status %d, got %d", fiber.StatusCreated, resp.StatusCode)
+		}
+		if response.ID == firstID {
+			t.Error("Expected a new message after the key expired")
+		}
+	})
+
+	t.Run("Key stored by a concurrent request is detected on insert", func(t *testing.T) {
+		params := db.MessageParams{
+			Topic:          "otp",
+			ToNumber:       "+1234567891",
+			Body:           "Your OTP code is 246810",
+			IdempotencyKey: "retry-key-3",
+		}
+		if _, err := db.CreateMessageWithParams(params); err != nil {
+			t.Fatalf("Failed to create message: %v", err)
+		}
+		if _, err := db.CreateMessageWithParams(params); !errors.Is(err, db.ErrIdempotencyKeyExists) {
+			t.Errorf("Expected ErrIdempotencyKeyExists, got %v", err)
+		}
+	})
+
+	t.Run("Keys are scoped to the API key", func(t *testing.T) {
+		keyed := setupAPIKeysTestApp()
+
+		_, firstKey, err := db.CreateAPIKey("first-service", []string{db.ScopeSend}, nil)
+		if err != nil {
+			t.Fatalf("Failed to create API key: %v", err)
+		}
+		_, secondKey, err := db.CreateAPIKey("second-service", []string{db.ScopeSend}, nil)
+		if err != nil {
+			t.Fatalf("Failed to create API key: %v", err)
+		}
+
+		first := QueueSMSRequest{Topic: "otp", ToNumber: "+1234567892", Body: "Your OTP code is 135791"}
+		resp, original := send(t, keyed, firstKey, "shared-key", first)
+		if resp.StatusCode != fiber.StatusCreated {
+			t.Fatalf("Expected status %d, got %d", fiber.StatusCreated, resp.StatusCode)
+		}
+
+		second := QueueSMSRequest{Topic: "otp", ToNumber: "+1234567893", Body: "Your OTP code is 975312"}
+		resp, response := send(t, keyed, secondKey, "shared-key", second)
+		if resp.StatusCode != fiber.StatusCreated {
+			t.Fatalf("Expected status %d, got %d", fiber.StatusCreated, resp.StatusCode)
+		}
+		if response.ID == original.ID || resp.Header.Get("Idempotent-Replayed") != "" {
+			t.Error("Expected a new message for another API key")
+		}
+
+		resp, response = send(t, keyed, firstKey, "shared-key", first)
+		if resp.StatusCode != fiber.StatusCreated || response.ID != original.ID {
+			t.Errorf("Expected the original message to be replayed, got %d '%s'", resp.StatusCode, response.ID)
+		}
+	})
+}
+
+func TestCancelAndUpdateMessageHandlers(t *testing.T) {
+	setupTestDB(t)
+	defer teardownTestDB()
+
+	app := setupTestApp()
+
+	pending, err := db.CreateMessage("reminders", "+1234567890", "Reminder: appointment tomorrow")
+	if err != nil {
+		t.Fatalf("Failed to create test message: %v", err)
+	}
+	leased, err := db.CreateMessage("otp", "+1234567890", "Your OTP is 123456")
+	if err != nil {
+		t.Fatalf("Failed to create test message: %v", err)
+	}
+	device, err := db.CreateDevice("cancel_device", nil)
+	if err != nil {
+		t.Fatalf("Failed to create test device: %v", err)
+	}
+	if _, err := db.GetPendingMessagesForDevice(device.ID, []string{"otp"}, db.PollBatchSize); err != nil {
+		t.Fatalf("Failed to poll messages: %v", err)
+	}
+	sent, err := db.CreateMessage("alerts", "+1234567890", "Alert: Login detected")
+	if err != nil {
+		t.Fatalf("Failed to create test message: %v", err)
+	}
+	if err := reportStatus(sent.ID, "sent", nil); err != nil {
+		t.Fatalf("Failed to update message status: %v", err)
+	}
+
+	sendAt := time.Now().UTC().Add(24 * time.Hour).Truncate(time.Second)
+
+	tests := []struct {
+		name           string
+		method         string
+		messageID      string
+		payload        interface{}
+		expectedStatus int
+		checkResponse  func(t *testing.T, body []byte)
+	}{
+		{
+			name:      "Edit pending message",
+			method:    "PATCH",
+			messageID: pending.ID,
+			payload: map[string]interface{}{
+				"body":    "Reminder: appointment moved to Friday",
+				"send_at": sendAt.Format(time.RFC3339),
+			},
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response MessageDetail
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.Body != "Reminder: appointment moved to Friday" {
+					t.Errorf("Expected updated body, got '%s'", response.Body)
+				}
+				if response.Status != "scheduled" {
+					t.Errorf("Expected status 'scheduled', got '%s'", response.Status)
+				}
+				if response.SendAt == nil || !response.SendAt.Equal(sendAt) {
+					t.Errorf("Expected send_at %v, got %v", sendAt, response.SendAt)
+				}
+			},
+		},
+		{
+			name:           "Edit with empty body",
+			method:         "PATCH",
+			messageID:      pending.ID,
+			payload:        map[string]interface{}{"body": ""},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Edit unknown message",
+			method:         "PATCH",
+			messageID:      "msg_unknown",
+			payload:        map[string]interface{}{"body": "New body"},
+			expectedStatus: fiber.StatusNotFound,
+		},
+		{
+			name:           "Edit sent message",
+			method:         "PATCH",
+			messageID:      sent.ID,
+			payload:        map[string]interface{}{"body": "New body"},
+			expectedStatus: fiber.StatusConflict,
+		},
+		{
+			name:           "Cancel leased message",
+			method:         "DELETE",
+			messageID:      leased.ID,
+			expectedStatus: fiber.StatusConflict,
+		},
+		{
+			name:           "Cancel sent message",
+			method:         "DELETE",
+			messageID:      sent.ID,
+			expectedStatus: fiber.StatusConflict,
+		},
+		{
+			name:           "Cancel unknown message",
+			method:         "DELETE",
+			messageID:      "msg_unknown",
+			expectedStatus: fiber.StatusNotFound,
+		},
+		{
+			name:           "Cancel pending message",
+			method:         "DELETE",
+			messageID:      pending.ID,
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response MessageDetail
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.Status != "cancelled" || response.CancelledAt == nil {
+					t.Errorf("Expected status 'cancelled' with cancelled_at, got '%s'", response.Status)
+				}
+
+				db.GetDB().Model(&db.Message{}).Where("id = ?", pending.ID).Update("send_at", nil)
+				messages, err := db.GetPendingMessagesForDevice(device.ID, []string{"reminders"}, db.PollBatchSize)
+				if err != nil {
+					t.Fatalf("Failed to poll messages: %v", err)
+				}
+				if len(messages) != 0 {
+					t.Errorf("Expected cancelled message not to be handed out, got %d", len(messages))
+				}
+			},
+		},
+		{
+			name:           "Cancel already cancelled message",
+			method:         "DELETE",
+			messageID:      pending.ID,
+			expectedStatus: fiber.StatusConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reqBody io.Reader
+			if tt.payload != nil {
+				bodyBytes, err := json.Marshal(tt.payload)
+				if err != nil {
+					t.Fatalf("Failed to marshal payload: %v", err)
+				}
+				reqBody = bytes.NewReader(bodyBytes)
+			}
+
+			req := httptest.NewRequest(tt.method, "/messages/"+tt.messageID, reqBody)
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Failed to perform request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read response body: %v", err)
+			}
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d. Response: %s", tt.expectedStatus, resp.StatusCode, string(body))
+			}
+
+			if tt.checkResponse != nil {
+				tt.checkResponse(t, body)
+			}
+		})
+	}
+}
+
+func TestGetMessageHandler(t *testing.T) {
+	setupTestDB(t)
+	defer teardownTestDB()
+
+	app := setupTestApp()
+
+	msg, err := db.CreateMessage("otp", "+1234567890", "Your OTP is 123456")
+	if err != nil {
+		t.Fatalf("Failed to create test message: %v", err)
+	}
+	device, err := db.CreateDevice("detail_device", strPtr("Front desk phone"))
+	if err != nil {
+		t.Fatalf("Failed to create test device: %v", err)
+	}
+	if _, err := db.GetPendingMessagesForDevice(device.ID, []string{"otp"}, db.PollBatchSize); err != nil {
+		t.Fatalf("Failed to poll messages: %v", err)
+	}
+	if err := db.UpdateMessageStatus(device.ID, msg.ID, "sent", nil); err != nil {
+		t.Fatalf("Failed to update message status: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		messageID      string
+		expectedStatus int
+		checkResponse  func(t *testing.T, body []byte)
+	}{
+		{
+			name:           "Sent message with full lifecycle",
+			messageID:      msg.ID,
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response MessageLifecycleResponse
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.Status != "sent" {
+					t.Errorf("Expected status 'sent', got '%s'", response.Status)
+				}
+				if response.SentAt == nil {
+					t.Error("Expected sent_at to be set")
+				}
+				if response.AssignedDevice == nil || response.AssignedDevice.ID != device.ID {
+					t.Fatalf("Expected assigned device %d, got %+v", device.ID, response.AssignedDevice)
+				}
+				if response.AssignedDevice.Name == nil || *response.AssignedDevice.Name != "Front desk phone" {
+					t.Errorf("Expected device name 'Front desk phone', got %v", response.AssignedDevice.Name)
+				}
+				if len(response.AttemptHistory) != 1 || response.AttemptHistory[0].Status != "sent" {
+					t.Errorf("Expected 1 sent attempt, got %+v", response.AttemptHistory)
+				}
+
+				expectedEvents := []string{"queued", "leased", "sent"}
+				if len(response.Events) != len(expectedEvents) {
+					t.Fatalf("Expected %d events, got %+v", len(expectedEvents), response.Events)
+				}
+				for i, eventType := range expectedEvents {
+					if response.Events[i].Type != eventType {
+						t.Errorf("Expected event %d to be '%s', got '%s'", i, eventType, response.Events[i].Type)
+					}
+				}
+				if response.Events[1].DeviceID == nil || *response.Events[1].DeviceID != device.ID {
+					t.Errorf("Expected leased event for device %d, got %v", device.ID, response.Events[1].DeviceID)
+				}
+			},
+		},
+		{
+			name:           "Unknown message",
+			messageID:      "msg_unknown",
+			expectedStatus: fiber.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/messages/"+tt.messageID, nil)
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Failed to perform request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read response body: %v", err)
+			}
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d. Response: %s", tt.expectedStatus, resp.StatusCode, string(body))
+			}
+
+			if tt.checkResponse != nil {
+				tt.checkResponse(t, body)
+			}
+		})
+	}
+}
+
+func TestQueueSMSHandler_PhoneNormalization(t *testing.T) {
+	os.Setenv("DEFAULT_COUNTRY_CODE", "258")
+	defer os.Unsetenv("DEFAULT_COUNTRY_CODE")
+
+	setupTestDB(t)
+	defer teardownTestDB()
+
+	app := setupTestApp()
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		payload        interface{}
+		expectedStatus int
+		checkResponse  func(t *testing.T, body []byte)
+	}{
+		{
+			name:           "Formatted number is stored as E.164",
+			method:         "POST",
+			path:           "/messages",
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "+258 84 123-4567", Body: "Your OTP is 123456"},
+			expectedStatus: fiber.StatusCreated,
+		},
+		{
+			name:           "Number without plus is a duplicate of the stored number",
+			method:         "POST",
+			path:           "/messages",
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "258841234567", Body: "Your OTP is 123456"},
+			expectedStatus: fiber.StatusConflict,
+		},
+		{
+			name:           "National number is a duplicate of the stored number",
+			method:         "POST",
+			path:           "/messages",
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "084 123 4567", Body: "Your OTP is 123456"},
+			expectedStatus: fiber.StatusConflict,
+		},
+		{
+			name:           "Local number gets the default country code",
+			method:         "POST",
+			path:           "/messages",
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "829876543", Body: "Your OTP is 654321"},
+			expectedStatus: fiber.StatusCreated,
+		},
+		{
+			name:           "Malformed number",
+			method:         "POST",
+			path:           "/messages",
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "+258-abc", Body: "Your OTP is 123456"},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Too short number",
+			method:         "POST",
+			path:           "/messages",
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "+1999", Body: "Your OTP is 123456"},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Filter accepts any format of the number",
+			method:         "GET",
+			path:           "/messages?to_number=0829876543",
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response MessagesListResponse
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if len(response.Data) != 1 || response.Data[0].ToNumber != "+258829876543" {
+					t.Errorf("Expected 1 message to +258829876543, got %+v", response.Data)
+				}
+			},
+		},
+		{
+			name:           "Invalid filter number",
+			method:         "GET",
+			path:           "/messages?to_number=abc",
+			expectedStatus: fiber.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bodyReader io.Reader
+			if tt.payload != nil {
+				bodyBytes, err := json.Marshal(tt.payload)
+				if err != nil {
+					t.Fatalf("Failed to marshal payload: %v", err)
+				}
+				bodyReader = bytes.NewReader(bodyBytes)
+			}
+
+			req := httptest.NewRequest(tt.method, tt.path, bodyReader)
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Failed to perform request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read response body: %v", err)
+			}
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d. Response: %s", tt.expectedStatus, resp.StatusCode, string(body))
+			}
+
+			if tt.checkResponse != nil {
+				tt.checkResponse(t, body)
+			}
+		})
+	}
+}
+
+func TestQueueSMSHandler_Encoding(t *testing.T) {
+	os.Setenv("MAX_SEGMENTS_PER_MESSAGE", "2")
+	defer os.Unsetenv("MAX_SEGMENTS_PER_MESSAGE")
+
+	setupTestDB(t)
+	defer teardownTestDB()
+
+	app := setupTestApp()
+
+	tests := []struct {
+		name               string
+		transliterate      bool
+		body               string
+		expectedStatus     int
+		expectedEncoding   string
+		expectedSegments   int
+		expectedCharacters int
+		expectedBody       string
+	}{
+		{
+			name:               "Plain text is GSM-7",
+			body:               "Your OTP is 123456",
+			expectedStatus:     fiber.StatusCreated,
+			expectedEncoding:   "gsm7",
+			expectedSegments:   1,
+			expectedCharacters: 18,
+		},
+		{
+			name:               "Extension characters take two septets",
+			body:               "€" + strings.Repeat("a", 159),
+			expectedStatus:     fiber.StatusCreated,
+			expectedEncoding:   "gsm7",
+			expectedSegments:   2,
+			expectedCharacters: 160,
+		},
+		{
+			name:               "Long GSM-7 text is split into 153 character segments",
+			body:               strings.Repeat("b", 306),
+			expectedStatus:     fiber.StatusCreated,
+			expectedEncoding:   "gsm7",
+			expectedSegments:   2,
+			expectedCharacters: 306,
+		},
+		{
+			name:               "Accented text is UCS-2",
+			body:               "Olá, a sua encomenda chegou",
+			expectedStatus:     fiber.StatusCreated,
+			expectedEncoding:   "ucs2",
+			expectedSegments:   1,
+			expectedCharacters: 27,
+		},
+		{
+			name:               "Emoji count as two UCS-2 units",
+			body:               strings.Repeat("😀", 36),
+			expectedStatus:     fiber.StatusCreated,
+			expectedEncoding:   "ucs2",
+			expectedSegments:   2,
+			expectedCharacters: 36,
+		},
+		{
+			name:           "Body over the segment limit",
+			body:           strings.Repeat("c", 307),
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:               "Transliteration keeps the message in GSM-7",
+			transliterate:      true,
+			body:               "“Olá” – a sua encomenda chegou…",
+			expectedStatus:     fiber.StatusCreated,
+			expectedEncoding:   "gsm7",
+			expectedSegments:   1,
+			expectedCharacters: 33,
+			expectedBody:       "\"Ola\" - a sua encomenda chegou...",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.transliterate {
+				os.Setenv("SMS_TRANSLITERATE", "true")
+				defer os.Unsetenv("SMS_TRANSLITERATE")
+			}
+
+			payload := QueueSMSRequest{Topic: "otp", ToNumber: fmt.Sprintf("+25884000000%d", i), Body: tt.body}
+			bodyBytes, err := json.Marshal(payload)
+			if err != nil {
+				t.Fatalf("Failed to marshal payload: %v", err)
+			}
+
+			req := httptest.NewRequest("POST", "/messages", bytes.NewReader(bodyBytes))
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Failed to perform request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read response body: %v", err)
+			}
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d. Response: %s", tt.expectedStatus, resp.StatusCode, string(body))
+			}
+			if tt.expectedStatus != fiber.StatusCreated {
+				return
+			}
+
+			var response QueueSMSResponse
+			if err := json.Unmarshal(body, &response); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			if response.Encoding != tt.expectedEncoding || response.Segments != tt.expectedSegments || response.Characters != tt.expectedCharacters {
+				t.Errorf("Expected %s/%d segments/%d characters, got %s/%d/%d", tt.expectedEncoding, tt.expectedSegments, tt.expectedCharacters,
+					response.Encoding, response.Segments, response.Characters)
+			}
+
+			if tt.expectedBody != "" {
+				message, err := db.GetMessageByID(response.ID)
+				if err != nil || message == nil {
+					t.Fatalf("Failed to get message: %v", err)
+				}
+				if message.Body != tt.expectedBody {
+					t.Errorf("Expected body %q, got %q", tt.expectedBody, message.Body)
+				}
+			}
+		})
+	}
+}
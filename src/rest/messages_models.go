@@ -3,14 +3,58 @@ package rest
 import "time"
 
 type QueueSMSRequest struct {
-	Topic    string `json:"topic" validate:"required"`
-	ToNumber string `json:"to_number" validate:"required"`
-	Body     string `json:"body" validate:"required"`
+	Topic           string            `json:"topic" validate:"required"`
+	ToNumber        string            `json:"to_number" validate:"required"`
+	Body            string            `json:"body,omitempty"`
+	Template        string            `json:"template,omitempty"`
+	TemplateVersion int               `json:"template_version,omitempty"`
+	Variables       map[string]string `json:"variables,omitempty"`
+	Locale          string            `json:"locale,omitempty"`
+	SendAt          string            `json:"send_at,omitempty"`
+	Timezone        string            `json:"timezone,omitempty"`
+	ExpiresAt       string            `json:"expires_at,omitempty"`
+	TTLSeconds      int               `json:"ttl_seconds,omitempty"`
+	Priority        string            `json:"priority,omitempty"`
+	CallbackURL     string            `json:"callback_url,omitempty"`
 }
 
 type QueueSMSResponse struct {
-	Message string `json:"message"`
-	ID      string `json:"id"`
+	Message    string     `json:"message"`
+	ID         string     `json:"id"`
+	Encoding   string     `json:"encoding"`
+	Segments   int        `json:"segments"`
+	Characters int        `json:"characters"`
+	SendAt     *time.Time `json:"send_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+type UpdateMessageRequest struct {
+	ToNumber *string `json:"to_number,omitempty"`
+	Body     *string `json:"body,omitempty"`
+	SendAt   *string `json:"send_at,omitempty"`
+	Timezone string  `json:"timezone,omitempty"`
+}
+
+type BatchSMSRequest struct {
+	QueueSMSRequest
+	ToNumbers []string          `json:"to_numbers,omitempty"`
+	Messages  []QueueSMSRequest `json:"messages,omitempty"`
+}
+
+type BatchItemResult struct {
+	Index    int    `json:"index"`
+	ToNumber string `json:"to_number"`
+	Status   string `json:"status"`
+	ID       string `json:"id,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type BatchSMSResponse struct {
+	Created    int               `json:"created"`
+	Duplicates int               `json:"duplicates"`
+	Invalid    int               `json:"invalid"`
+	Suppressed int               `json:"suppressed"`
+	Results    []BatchItemResult `json:"results"`
 }
 
 type MessageDetail struct {
@@ -18,11 +62,27 @@ type MessageDetail struct {
 	Topic         string     `json:"topic"`
 	ToNumber      string     `json:"to_number"`
 	Body          string     `json:"body"`
+	Encoding      string     `json:"encoding"`
+	Segments      int        `json:"segments"`
+	Characters    int        `json:"characters"`
 	Status        string     `json:"status"`
+	Priority      string     `json:"priority"`
 	CreatedAt     time.Time  `json:"created_at"`
+	SendAt        *time.Time `json:"send_at,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
 	SentAt        *time.Time `json:"sent_at,omitempty"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
 	FailedAt      *time.Time `json:"failed_at,omitempty"`
 	FailureReason *string    `json:"failure_reason,omitempty"`
+	ExpiredAt     *time.Time `json:"expired_at,omitempty"`
+	CancelledAt   *time.Time `json:"cancelled_at,omitempty"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	CallbackURL   *string    `json:"callback_url,omitempty"`
+
+	Template        *string `json:"template,omitempty"`
+	TemplateVersion *int    `json:"template_version,omitempty"`
+	Locale          *string `json:"locale,omitempty"`
 }
 
 type PaginationInfo struct {
@@ -36,3 +96,39 @@ type MessagesListResponse struct {
 	Data       []MessageDetail `json:"data"`
 	Pagination PaginationInfo  `json:"pagination"`
 }
+
+type AssignedDeviceInfo struct {
+	ID   uint    `json:"id"`
+	Name *string `json:"name,omitempty"`
+}
+
+type MessageAttemptInfo struct {
+	Attempt    int        `json:"attempt"`
+	DeviceID   *uint      `json:"device_id,omitempty"`
+	Status     string     `json:"status"`
+	Reason     *string    `json:"reason,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+type MessageEventInfo struct {
+	ID        uint      `json:"id"`
+	Type      string    `json:"type"`
+	DeviceID  *uint     `json:"device_id,omitempty"`
+	Detail    *string   `json:"detail,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type MessageStreamEvent struct {
+	MessageID string `json:"message_id"`
+	Topic     string `json:"topic"`
+	MessageEventInfo
+}
+
+type MessageLifecycleResponse struct {
+	MessageDetail
+	AssignedDevice *AssignedDeviceInfo  `json:"assigned_device,omitempty"`
+	LeasedUntil    *time.Time           `json:"leased_until,omitempty"`
+	AttemptHistory []MessageAttemptInfo `json:"attempt_history"`
+	Events         []MessageEventInfo   `json:"events"`
+}
@@ -0,0 +1,220 @@
+package rest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sms-gateway-api/db"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	streamBatchSize     = 100
+	maxStreamMessageIDs = 100
+)
+
+// activeStreams counts the stream writers that have not returned yet. The
+// server does not wait for them on shutdown.
+var activeStreams sync.WaitGroup
+
+func getStreamKeepAlive() time.Duration {
+	secondsStr := os.Getenv("MESSAGE_STREAM_KEEPALIVE_SECONDS")
+	if secondsStr == "" {
+		return 15 * time.Second
+	}
+
+	seconds, err := strconv.Atoi(secondsStr)
+	if err != nil || seconds <= 0 {
+		return 15 * time.Second
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+func getStreamRescanWindow() time.Duration {
+	secondsStr := os.Getenv("MESSAGE_STREAM_RESCAN_SECONDS")
+	if secondsStr == "" {
+		return 30 * time.Second
+	}
+
+	seconds, err := strconv.Atoi(secondsStr)
+	if err != nil || seconds < 0 {
+		return 30 * time.Second
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// StreamMessagesHandler sends message events as Server-Sent Events. Each
+// event id is the id of the persisted message event, so a client resuming
+// with Last-Event-ID receives everything it missed from the event log.
+func StreamMessagesHandler(c *fiber.Ctx) error {
+	topic := c.Query("topic")
+	if topic != "" && !topicAllowed(c, topic) {
+		return returnTopicForbidden(c, topic)
+	}
+
+	var messageIDs []string
+	for _, id := range strings.Split(c.Query("message_ids"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			messageIDs = append(messageIDs, id)
+		}
+	}
+	if len(messageIDs) > maxStreamMessageIDs {
+		return ReturnBadRequest(c, fmt.Sprintf("message_ids must not list more than %d ids", maxStreamMessageIDs))
+	}
+
+	// Browsers send the header when an EventSource reconnects; the query
+	// parameter allows resuming from a fresh connection.
+	lastEventID := c.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+
+	var afterID uint
+	if lastEventID != "" {
+		id, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			return ReturnBadRequest(c, "Invalid Last-Event-ID. Must be the id of a previous event")
+		}
+		afterID = uint(id)
+	} else {
+		id, err := db.GetLatestMessageEventID()
+		if err != nil {
+			return ReturnInternalError(c, "Failed to open message stream")
+		}
+		afterID = id
+	}
+
+	filters := db.MessageEventFilters{
+		AfterID:    afterID,
+		Topic:      topic,
+		Topics:     allowedTopics(c),
+		MessageIDs: messageIDs,
+		Types:      db.StatusEventTypes,
+		Limit:      streamBatchSize,
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	// Done is closed when the server shuts down. It has to be read here, the
+	// server drops it once shutdown completes.
+	done := c.Context().Done()
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		activeStreams.Add(1)
+		defer activeStreams.Done()
+		streamMessageEvents(w, filters, done)
+	})
+
+	return nil
+}
+
+// streamMessageEvents runs until the client goes away or done is closed.
+// Events recorded by other API instances do not wake the stream and are
+// picked up at the next keep-alive.
+//
+// Event ids are assigned on insert but only become visible on commit, so an
+// event may show up after one with a higher id was already sent. Every scan
+// therefore starts below the events of the last rescan window and skips the
+// ids it has sent; filters.AfterID only moves past events older than that.
+func streamMessageEvents(w *bufio.Writer, filters db.MessageEventFilters, done <-chan struct{}) {
+	notifications, stop := db.SubscribeMessageEvents()
+	defer stop()
+
+	rescanWindow := getStreamRescanWindow()
+	sent := map[uint]time.Time{}
+
+	keepAlive := time.NewTicker(getStreamKeepAlive())
+	defer keepAlive.Stop()
+
+	// Nothing reaches the client, not even the headers, until the first
+	// flush of a non-empty body.
+	if _, err := w.WriteString(": connected\n\n"); err != nil {
+		return
+	}
+
+	for {
+		scan := filters
+		for {
+			events, err := db.GetMessageEvents(scan)
+			if err != nil {
+				log.Printf("Warning: Failed to read message events: %v", err)
+				return
+			}
+
+			for _, event := range events {
+				scan.AfterID = event.ID
+				if _, ok := sent[event.ID]; ok {
+					continue
+				}
+				if err := writeMessageEvent(w, event); err != nil {
+					return
+				}
+				sent[event.ID] = event.CreatedAt
+			}
+
+			if err := w.Flush(); err != nil {
+				return
+			}
+
+			if len(events) < scan.Limit {
+				break
+			}
+		}
+
+		settled := time.Now().Add(-rescanWindow)
+		for id, createdAt := range sent {
+			if !createdAt.After(settled) && id > filters.AfterID {
+				filters.AfterID = id
+			}
+		}
+		for id := range sent {
+			if id <= filters.AfterID {
+				delete(sent, id)
+			}
+		}
+
+		select {
+		case <-done:
+			return
+		case <-notifications:
+		case <-keepAlive.C:
+			if _, err := w.WriteString(": keep-alive\n\n"); err != nil {
+				return
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func writeMessageEvent(w *bufio.Writer, event db.MessageEvent) error {
+	data, err := json.Marshal(MessageStreamEvent{
+		MessageID: event.MessageID,
+		Topic:     event.Topic,
+		MessageEventInfo: MessageEventInfo{
+			ID:        event.ID,
+			Type:      event.Type,
+			DeviceID:  event.DeviceID,
+			Detail:    event.Detail,
+			CreatedAt: event.CreatedAt,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
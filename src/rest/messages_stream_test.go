@@ -0,0 +1,214 @@
+package rest
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sms-gateway-api/db"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type sseEvent struct {
+	ID    string
+	Event string
+	Data  MessageStreamEvent
+}
+
+func TestStreamMessagesHandler(t *testing.T) {
+	setupTestDB(t)
+	defer teardownTestDB()
+
+	// Events inserted without waking the stream are picked up at the next
+	// keep-alive.
+	t.Setenv("MESSAGE_STREAM_KEEPALIVE_SECONDS", "1")
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/messages/stream", StreamMessagesHandler)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go app.Listener(listener)
+	// The stream writers outlive the shutdown; wait for them before the
+	// database is closed.
+	defer activeStreams.Wait()
+	defer app.Shutdown()
+
+	baseURL := "http://" + listener.Addr().String() + "/messages/stream"
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	open := func(t *testing.T, query string, lastEventID string) (*http.Response, *bufio.Reader) {
+		req, err := http.NewRequest("GET", baseURL+query, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to open stream: %v", err)
+		}
+		if resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+		}
+		return resp, bufio.NewReader(resp.Body)
+	}
+
+	next := func(t *testing.T, reader *bufio.Reader) sseEvent {
+		var event sseEvent
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Failed to read stream: %v", err)
+			}
+			line = strings.TrimRight(line, "\n")
+
+			switch {
+			case line == "" && event.ID != "":
+				return event
+			case strings.HasPrefix(line, "id: "):
+				event.ID = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				event.Event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event.Data); err != nil {
+					t.Fatalf("Failed to unmarshal event data: %v", err)
+				}
+			}
+		}
+	}
+
+	earlier, err := db.CreateMessage("otp", "+258841234560", "Your OTP is 111111")
+	if err != nil {
+		t.Fatalf("Failed to create message: %v", err)
+	}
+
+	t.Run("Invalid Last-Event-ID", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/messages/stream", nil)
+		req.Header.Set("Last-Event-ID", "yesterday")
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to perform request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != fiber.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("Too many message ids", func(t *testing.T) {
+		ids := strings.Repeat("msg_x,", maxStreamMessageIDs+1)
+		req := httptest.NewRequest("GET", "/messages/stream?message_ids="+ids, nil)
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to perform request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != fiber.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", resp.StatusCode)
+		}
+	})
+
+	var message *db.Message
+	var queuedEventID string
+
+	t.Run("Streams new messages and status changes for the topic", func(t *testing.T) {
+		resp, reader := open(t, "?topic=otp", "")
+		defer resp.Body.Close()
+
+		if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+			t.Errorf("Expected Content-Type text/event-stream, got '%s'", contentType)
+		}
+
+		if _, err := db.CreateMessage("marketing", "+258841234567", "Weekend sale!"); err != nil {
+			t.Fatalf("Failed to create message: %v", err)
+		}
+		message, err = db.CreateMessage("otp", "+258841234567", "Your OTP is 123456")
+		if err != nil {
+			t.Fatalf("Failed to create message: %v", err)
+		}
+
+		event := next(t, reader)
+		if event.Event != db.EventQueued || event.Data.MessageID != message.ID || event.Data.Topic != "otp" {
+			t.Fatalf("Expected the queued event for %s, got %+v", message.ID, event)
+		}
+		queuedEventID = event.ID
+
+		if err := reportStatus(message.ID, "sent", nil); err != nil {
+			t.Fatalf("Failed to update message status: %v", err)
+		}
+
+		event = next(t, reader)
+		if event.Event != db.EventSent || event.Data.MessageID != message.ID {
+			t.Errorf("Expected the sent event for %s, got %+v", message.ID, event)
+		}
+	})
+
+	t.Run("Resumes after Last-Event-ID", func(t *testing.T) {
+		resp, reader := open(t, "?message_ids="+message.ID, queuedEventID)
+		defer resp.Body.Close()
+
+		event := next(t, reader)
+		if event.Event != db.EventSent || event.Data.MessageID != message.ID {
+			t.Errorf("Expected the missed sent event, got %+v", event)
+		}
+	})
+
+	t.Run("Replays the event log from the start", func(t *testing.T) {
+		resp, reader := open(t, "?last_event_id=0&message_ids="+earlier.ID, "")
+		defer resp.Body.Close()
+
+		event := next(t, reader)
+		if event.Event != db.EventQueued || event.Data.MessageID != earlier.ID {
+			t.Errorf("Expected the queued event for %s, got %+v", earlier.ID, event)
+		}
+	})
+
+	t.Run("Delivers events committed after a higher id once", func(t *testing.T) {
+		resp, reader := open(t, "?message_ids="+message.ID, "")
+		defer resp.Body.Close()
+
+		latest, err := db.GetLatestMessageEventID()
+		if err != nil {
+			t.Fatalf("Failed to get latest event id: %v", err)
+		}
+
+		// Events are inserted directly, without waking the stream, so each one
+		// is picked up at the next keep-alive.
+		record := func(t *testing.T, id uint, eventType string) {
+			event := db.MessageEvent{ID: id, MessageID: message.ID, Topic: message.Topic, Type: eventType}
+			if err := db.GetDB().Create(&event).Error; err != nil {
+				t.Fatalf("Failed to record event: %v", err)
+			}
+		}
+
+		record(t, latest+5, db.EventDelivered)
+		if event := next(t, reader); event.ID != strconv.FormatUint(uint64(latest+5), 10) {
+			t.Fatalf("Expected event %d, got %+v", latest+5, event)
+		}
+
+		record(t, latest+2, db.EventSent)
+		if event := next(t, reader); event.ID != strconv.FormatUint(uint64(latest+2), 10) {
+			t.Fatalf("Expected the late event %d, got %+v", latest+2, event)
+		}
+
+		record(t, latest+7, db.EventDelivered)
+		if event := next(t, reader); event.ID != strconv.FormatUint(uint64(latest+7), 10) {
+			t.Errorf("Expected event %d without repeats, got %+v", latest+7, event)
+		}
+	})
+}
@@ -1,7 +1,9 @@
 package rest
 
 import (
+	"math"
 	"sms-gateway-api/db"
+	"sms-gateway-api/sms"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -54,43 +56,56 @@ func GetReportsHandler(c *fiber.Ctx) error {
 		return ReturnBadRequest(c, "Invalid aggregation. Must be one of: daily, weekly, monthly")
 	}
 
+	locale, err := sms.NormalizeLocale(c.Query("locale"))
+	if err != nil {
+		return ReturnBadRequest(c, "Invalid locale. Use a language tag such as pt or pt-MZ")
+	}
+
 	topic := c.Query("topic")
+	if topic != "" && !topicAllowed(c, topic) {
+		return returnTopicForbidden(c, topic)
+	}
+
+	filters := db.ReportFilters{
+		Topic:    topic,
+		Topics:   allowedTopics(c),
+		Template: c.Query("template"),
+		Locale:   locale,
+	}
 
-	summary, err := db.GetReportSummary(startDate, endDate, topic)
+	summary, err := db.GetReportSummary(startDate, endDate, filters)
 	if err != nil {
 		return ReturnInternalError(c, "Failed to retrieve report summary")
 	}
 
-	topicStats, err := db.GetTopicStats(startDate, endDate, topic)
+	topicStats, err := db.GetTopicStats(startDate, endDate, filters)
 	if err != nil {
 		return ReturnInternalError(c, "Failed to retrieve topic statistics")
 	}
 
-	timeline, err := db.GetTimelineStats(startDate, endDate, aggregation, topic)
+	localeStats, err := db.GetLocaleStats(startDate, endDate, filters)
+	if err != nil {
+		return ReturnInternalError(c, "Failed to retrieve locale statistics")
+	}
+
+	timeline, err := db.GetTimelineStats(startDate, endDate, aggregation, filters)
 	if err != nil {
 		return ReturnInternalError(c, "Failed to retrieve timeline statistics")
 	}
 
 	restTopicStats := make([]TopicStats, len(topicStats))
 	for i, ts := range topicStats {
-		restTopicStats[i] = TopicStats{
-			Topic:   ts.Topic,
-			Total:   int(ts.Total),
-			Sent:    int(ts.Sent),
-			Failed:  int(ts.Failed),
-			Pending: int(ts.Pending),
-		}
+		restTopicStats[i] = TopicStats{Topic: ts.Topic, ReportStats: toReportStats(ts.ReportCounts)}
+	}
+
+	restLocaleStats := make([]LocaleStats, len(localeStats))
+	for i, ls := range localeStats {
+		restLocaleStats[i] = LocaleStats{Locale: ls.Locale, ReportStats: toReportStats(ls.ReportCounts)}
 	}
 
 	restTimeline := make([]TimelineEntry, len(timeline))
 	for i, te := range timeline {
-		restTimeline[i] = TimelineEntry{
-			Date:    te.Date,
-			Total:   int(te.Total),
-			Sent:    int(te.Sent),
-			Failed:  int(te.Failed),
-			Pending: int(te.Pending),
-		}
+		restTimeline[i] = TimelineEntry{Date: te.Date, ReportStats: toReportStats(te.ReportCounts)}
 	}
 
 	response := ReportResponse{
@@ -99,15 +114,36 @@ func GetReportsHandler(c *fiber.Ctx) error {
 			End:         endDate,
 			Aggregation: aggregation,
 		},
-		Summary: ReportSummary{
-			Total:   int(summary.Total),
-			Sent:    int(summary.Sent),
-			Failed:  int(summary.Failed),
-			Pending: int(summary.Pending),
-		},
+		Summary:  toReportStats(*summary),
 		ByTopic:  restTopicStats,
+		ByLocale: restLocaleStats,
 		Timeline: restTimeline,
 	}
 
 	return c.JSON(response)
 }
+
+func toReportStats(counts db.ReportCounts) ReportStats {
+	return ReportStats{
+		Total:        int(counts.Total),
+		Sent:         int(counts.Sent),
+		Delivered:    int(counts.Delivered),
+		Undelivered:  int(counts.Undelivered),
+		Failed:       int(counts.Failed),
+		Pending:      int(counts.Pending),
+		Expired:      int(counts.Expired),
+		Cancelled:    int(counts.Cancelled),
+		Segments:     int(counts.Segments),
+		SentSegments: int(counts.SentSegments),
+		DeliveryRate: deliveryRate(counts.Sent, counts.Delivered, counts.Undelivered),
+	}
+}
+
+func deliveryRate(sent, delivered, undelivered int64) float64 {
+	total := sent + delivered + undelivered
+	if total == 0 {
+		return 0
+	}
+
+	return math.Round(float64(delivered)/float64(total)*10000) / 100
+}
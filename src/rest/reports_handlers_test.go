@@ -2,10 +2,13 @@ package rest
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http/httptest"
 	"sms-gateway-api/db"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -30,10 +33,10 @@ func TestGetReportsHandler(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to create test message: %v", err)
 	}
-	if err := db.UpdateMessageStatus(msg1.ID, "sent", nil); err != nil {
+	if err := reportStatus(msg1.ID, "sent", nil); err != nil {
 		t.Fatalf("Failed to update message status: %v", err)
 	}
-	if err := db.UpdateMessageStatus(msg2.ID, "failed", strPtr("Network error")); err != nil {
+	if err := reportStatus(msg2.ID, "failed", strPtr("Network error")); err != nil {
 		t.Fatalf("Failed to update message status: %v", err)
 	}
 
@@ -156,3 +159,141 @@ func TestGetReportsHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestGetReportsHandler_Expired(t *testing.T) {
+	setupTestDB(t)
+	defer teardownTestDB()
+
+	app := setupReportsTestApp()
+
+	expiresAt := time.Now().Add(time.Minute)
+	msg, err := db.CreateMessageWithParams(db.MessageParams{
+		Topic:     "otp",
+		ToNumber:  "+1234567890",
+		Body:      "Your OTP is 123456",
+		ExpiresAt: &expiresAt,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test message: %v", err)
+	}
+	if _, err := db.CreateMessage("otp", "+9876543210", "Your OTP is 654321"); err != nil {
+		t.Fatalf("Failed to create test message: %v", err)
+	}
+	db.GetDB().Model(&db.Message{}).Where("id = ?", msg.ID).Update("expires_at", time.Now().UTC().Add(-time.Second))
+	if _, err := db.ExpireMessages(); err != nil {
+		t.Fatalf("Failed to expire messages: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/reports?start_date=2020-01-01&end_date=2030-12-31", nil)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Failed to perform request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var response ReportResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Summary.Expired != 1 {
+		t.Errorf("Expected expired 1, got %d", response.Summary.Expired)
+	}
+	if response.Summary.Pending != 1 {
+		t.Errorf("Expected pending 1, got %d", response.Summary.Pending)
+	}
+	if len(response.ByTopic) != 1 || response.ByTopic[0].Expired != 1 {
+		t.Errorf("Expected 1 expired message in topic stats, got %+v", response.ByTopic)
+	}
+}
+
+func TestGetReportsHandler_DeliveryRate(t *testing.T) {
+	setupTestDB(t)
+	defer teardownTestDB()
+
+	app := setupReportsTestApp()
+
+	reports := []string{"delivered", "delivered", "undelivered", "sent"}
+	for i, status := range reports {
+		msg, err := db.CreateMessage("otp", fmt.Sprintf("+123456789%d", i), "Your OTP is 123456")
+		if err != nil {
+			t.Fatalf("Failed to create test message: %v", err)
+		}
+		if err := reportStatus(msg.ID, "sent", nil); err != nil {
+			t.Fatalf("Failed to update message status: %v", err)
+		}
+		if status != "sent" {
+			if err := reportStatus(msg.ID, status, strPtr("Absent subscriber")); err != nil {
+				t.Fatalf("Failed to update message status: %v", err)
+			}
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/reports?start_date=2020-01-01&end_date=2030-12-31", nil)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Failed to perform request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var response ReportResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Summary.Delivered != 2 || response.Summary.Undelivered != 1 || response.Summary.Sent != 1 {
+		t.Errorf("Expected 2 delivered, 1 undelivered and 1 sent, got %+v", response.Summary)
+	}
+	if response.Summary.DeliveryRate != 50 {
+		t.Errorf("Expected delivery rate 50, got %v", response.Summary.DeliveryRate)
+	}
+	if len(response.ByTopic) != 1 || response.ByTopic[0].DeliveryRate != 50 {
+		t.Errorf("Expected topic delivery rate 50, got %+v", response.ByTopic)
+	}
+	if len(response.Timeline) != 1 || response.Timeline[0].Delivered != 2 {
+		t.Errorf("Expected 2 delivered in timeline, got %+v", response.Timeline)
+	}
+}
+
+func TestGetReportsHandler_Segments(t *testing.T) {
+	setupTestDB(t)
+	defer teardownTestDB()
+
+	app := setupReportsTestApp()
+
+	bodies := []string{
+		"Your OTP is 123456",
+		strings.Repeat("Long promotional message. ", 7),
+		"Olá, a sua encomenda chegou",
+	}
+	for i, body := range bodies {
+		msg, err := db.CreateMessage("otp", fmt.Sprintf("+123456789%d", i), body)
+		if err != nil {
+			t.Fatalf("Failed to create test message: %v", err)
+		}
+		if i > 0 {
+			if err := reportStatus(msg.ID, "sent", nil); err != nil {
+				t.Fatalf("Failed to update message status: %v", err)
+			}
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/reports?start_date=2020-01-01&end_date=2030-12-31", nil)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Failed to perform request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var response ReportResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Summary.Total != 3 || response.Summary.Segments != 4 || response.Summary.SentSegments != 3 {
+		t.Errorf("Expected 3 messages, 4 segments and 3 sent segments, got %+v", response.Summary)
+	}
+	if len(response.ByTopic) != 1 || response.ByTopic[0].Segments != 4 {
+		t.Errorf("Expected 4 segments for topic, got %+v", response.ByTopic)
+	}
+	if len(response.Timeline) != 1 || response.Timeline[0].SentSegments != 3 {
+		t.Errorf("Expected 3 sent segments in timeline, got %+v", response.Timeline)
+	}
+}
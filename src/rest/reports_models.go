@@ -8,32 +8,39 @@ type ReportPeriod struct {
 	Aggregation string    `json:"aggregation"`
 }
 
-type ReportSummary struct {
-	Total   int `json:"total"`
-	Sent    int `json:"sent"`
-	Failed  int `json:"failed"`
-	Pending int `json:"pending"`
+type ReportStats struct {
+	Total        int     `json:"total"`
+	Sent         int     `json:"sent"`
+	Delivered    int     `json:"delivered"`
+	Undelivered  int     `json:"undelivered"`
+	Failed       int     `json:"failed"`
+	Pending      int     `json:"pending"`
+	Expired      int     `json:"expired"`
+	Cancelled    int     `json:"cancelled"`
+	Segments     int     `json:"segments"`
+	SentSegments int     `json:"sent_segments"`
+	DeliveryRate float64 `json:"delivery_rate"`
 }
 
 type TopicStats struct {
-	Topic   string `json:"topic"`
-	Total   int    `json:"total"`
-	Sent    int    `json:"sent"`
-	Failed  int    `json:"failed"`
-	Pending int    `json:"pending"`
+	Topic string `json:"topic"`
+	ReportStats
+}
+
+type LocaleStats struct {
+	Locale string `json:"locale"`
+	ReportStats
 }
 
 type TimelineEntry struct {
-	Date    string `json:"date"`
-	Total   int    `json:"total"`
-	Sent    int    `json:"sent"`
-	Failed  int    `json:"failed"`
-	Pending int    `json:"pending"`
+	Date string `json:"date"`
+	ReportStats
 }
 
 type ReportResponse struct {
 	Period   ReportPeriod    `json:"period"`
-	Summary  ReportSummary   `json:"summary"`
+	Summary  ReportStats     `json:"summary"`
 	ByTopic  []TopicStats    `json:"by_topic"`
+	ByLocale []LocaleStats   `json:"by_locale"`
 	Timeline []TimelineEntry `json:"timeline"`
 }
@@ -0,0 +1,116 @@
+package rest
+
+import (
+	"math"
+	"sms-gateway-api/db"
+	"sms-gateway-api/sms"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+func CreateSuppressionHandler(c *fiber.Ctx) error {
+	var req CreateSuppressionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ReturnBadRequest(c, "Invalid request body")
+	}
+
+	if req.PhoneNumber == "" {
+		return ReturnBadRequest(c, "phone_number is required")
+	}
+
+	phoneNumber, err := sms.NormalizePhoneNumber(req.PhoneNumber)
+	if err != nil {
+		return ReturnBadRequest(c, "Invalid phone_number. Use E.164 format (e.g., +258841234567)")
+	}
+
+	if req.Reason == "" {
+		req.Reason = "manual"
+	}
+
+	suppression, err := db.AddSuppression(phoneNumber, req.Topic, req.Reason)
+	if err != nil {
+		return ReturnInternalError(c, "Failed to add suppression")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(toSuppressionDetail(*suppression))
+}
+
+func ListSuppressionsHandler(c *fiber.Ctx) error {
+	page := c.QueryInt("page", 1)
+	if page < 1 {
+		page = 1
+	}
+
+	limit := c.QueryInt("limit", 20)
+	if limit < 1 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	phoneNumber := c.Query("phone_number")
+	if phoneNumber != "" {
+		normalized, err := sms.NormalizePhoneNumber(phoneNumber)
+		if err != nil {
+			return ReturnBadRequest(c, "Invalid phone_number. Use E.164 format (e.g., +258841234567)")
+		}
+		phoneNumber = normalized
+	}
+
+	suppressions, total, err := db.GetSuppressions(db.SuppressionFilters{
+		PhoneNumber: phoneNumber,
+		Topic:       c.Query("topic"),
+		Limit:       limit,
+		Offset:      (page - 1) * limit,
+	})
+	if err != nil {
+		return ReturnInternalError(c, "Failed to retrieve suppressions")
+	}
+
+	details := make([]SuppressionDetail, len(suppressions))
+	for i, suppression := range suppressions {
+		details[i] = toSuppressionDetail(suppression)
+	}
+
+	return c.JSON(SuppressionsListResponse{
+		Data: details,
+		Pagination: PaginationInfo{
+			Page:       page,
+			Limit:      limit,
+			Total:      total,
+			TotalPages: int(math.Ceil(float64(total) / float64(limit))),
+		},
+	})
+}
+
+func DeleteSuppressionHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id < 1 {
+		return ReturnBadRequest(c, "Invalid suppression id")
+	}
+
+	err = db.DeleteSuppression(uint(id))
+	if err == gorm.ErrRecordNotFound {
+		return ReturnNotFound(c, "Suppression not found")
+	}
+	if err != nil {
+		return ReturnInternalError(c, "Failed to delete suppression")
+	}
+
+	return c.JSON(SuccessResponse{
+		Message: "Suppression removed",
+	})
+}
+
+func toSuppressionDetail(suppression db.Suppression) SuppressionDetail {
+	return SuppressionDetail{
+		ID:               suppression.ID,
+		PhoneNumber:      suppression.PhoneNumber,
+		Topic:            suppression.Topic,
+		Reason:           suppression.Reason,
+		InboundMessageID: suppression.InboundMessageID,
+		CreatedAt:        suppression.CreatedAt,
+	}
+}
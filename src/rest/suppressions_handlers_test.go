@@ -0,0 +1,243 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"sms-gateway-api/db"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func setupSuppressionsTestApp() *fiber.App {
+	app := setupTestApp()
+	app.Get("/suppressions", ListSuppressionsHandler)
+	app.Post("/suppressions", CreateSuppressionHandler)
+	app.Delete("/suppressions/:id", DeleteSuppressionHandler)
+	return app
+}
+
+func TestSuppressionHandlers(t *testing.T) {
+	setupTestDB(t)
+	defer teardownTestDB()
+
+	app := setupSuppressionsTestApp()
+
+	device, err := db.CreateDevice("stop_device", nil)
+	if err != nil {
+		t.Fatalf("Failed to create test device: %v", err)
+	}
+	inbound, err := db.CreateInboundMessage(db.InboundParams{
+		DeviceID:   device.ID,
+		FromNumber: "+258841111111",
+		Body:       " Stop ",
+		ReceivedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create inbound message: %v", err)
+	}
+	if !inbound.OptOut {
+		t.Fatal("Expected STOP reply to be flagged as opt-out")
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		payload        interface{}
+		expectedStatus int
+		checkResponse  func(t *testing.T, body []byte)
+	}{
+		{
+			name:           "STOP reply suppresses the number globally",
+			method:         "POST",
+			path:           "/messages",
+			payload:        QueueSMSRequest{Topic: "marketing", ToNumber: "+258841111111", Body: "Weekend sale!"},
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response map[string]string
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response["code"] != "recipient_suppressed" {
+					t.Errorf("Expected code 'recipient_suppressed', got '%s'", response["code"])
+				}
+			},
+		},
+		{
+			name:           "Add topic suppression",
+			method:         "POST",
+			path:           "/suppressions",
+			payload:        CreateSuppressionRequest{PhoneNumber: "+258842222222", Topic: "marketing"},
+			expectedStatus: fiber.StatusCreated,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response SuppressionDetail
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.Topic != "marketing" || response.Reason != "manual" {
+					t.Errorf("Unexpected suppression: %+v", response)
+				}
+			},
+		},
+		{
+			name:           "Missing phone_number",
+			method:         "POST",
+			path:           "/suppressions",
+			payload:        CreateSuppressionRequest{Topic: "marketing"},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Topic suppression blocks that topic",
+			method:         "POST",
+			path:           "/messages",
+			payload:        QueueSMSRequest{Topic: "marketing", ToNumber: "+258842222222", Body: "Weekend sale!"},
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Topic suppression does not block other topics",
+			method:         "POST",
+			path:           "/messages",
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "+258842222222", Body: "Your OTP is 123456"},
+			expectedStatus: fiber.StatusCreated,
+		},
+		{
+			name:   "Batch reports suppressed recipients per item",
+			method: "POST",
+			path:   "/messages/batch",
+			payload: BatchSMSRequest{
+				QueueSMSRequest: QueueSMSRequest{Topic: "marketing", Body: "Weekend sale!"},
+				ToNumbers:       []string{"+258841111111", "+258843333333"},
+			},
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response BatchSMSResponse
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.Suppressed != 1 || response.Created != 1 {
+					t.Errorf("Expected 1 suppressed and 1 created, got %+v", response)
+				}
+				if response.Results[0].Status != "suppressed" {
+					t.Errorf("Expected first item to be suppressed, got '%s'", response.Results[0].Status)
+				}
+			},
+		},
+		{
+			name:           "List suppressions",
+			method:         "GET",
+			path:           "/suppressions",
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response SuppressionsListResponse
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.Pagination.Total != 2 {
+					t.Fatalf("Expected 2 suppressions, got %d", response.Pagination.Total)
+				}
+				for _, suppression := range response.Data {
+					if suppression.PhoneNumber == "+258841111111" {
+						if suppression.Topic != "*" || suppression.Reason != "keyword" || suppression.InboundMessageID == nil {
+							t.Errorf("Unexpected keyword suppression: %+v", suppression)
+						}
+					}
+				}
+			},
+		},
+		{
+			name:           "Filter suppressions by topic",
+			method:         "GET",
+			path:           "/suppressions?topic=marketing",
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response SuppressionsListResponse
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.Pagination.Total != 1 {
+					t.Errorf("Expected 1 suppression, got %d", response.Pagination.Total)
+				}
+			},
+		},
+		{
+			name:           "Filter suppressions by unformatted phone number",
+			method:         "GET",
+			path:           "/suppressions?phone_number=258%2084%20222%202222",
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response SuppressionsListResponse
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.Pagination.Total != 1 {
+					t.Errorf("Expected 1 suppression, got %d", response.Pagination.Total)
+				}
+			},
+		},
+		{
+			name:           "Invalid phone_number",
+			method:         "POST",
+			path:           "/suppressions",
+			payload:        CreateSuppressionRequest{PhoneNumber: "not-a-number"},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Remove suppression",
+			method:         "DELETE",
+			path:           "/suppressions/1",
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Removed number can be messaged again",
+			method:         "POST",
+			path:           "/messages",
+			payload:        QueueSMSRequest{Topic: "marketing", ToNumber: "+258841111111", Body: "Welcome back!"},
+			expectedStatus: fiber.StatusCreated,
+		},
+		{
+			name:           "Remove unknown suppression",
+			method:         "DELETE",
+			path:           "/suppressions/1",
+			expectedStatus: fiber.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bodyReader io.Reader
+			if tt.payload != nil {
+				bodyBytes, err := json.Marshal(tt.payload)
+				if err != nil {
+					t.Fatalf("Failed to marshal payload: %v", err)
+				}
+				bodyReader = bytes.NewReader(bodyBytes)
+			}
+
+			req := httptest.NewRequest(tt.method, tt.path, bodyReader)
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Failed to perform request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read response body: %v", err)
+			}
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d. Response: %s", tt.expectedStatus, resp.StatusCode, string(body))
+			}
+
+			if tt.checkResponse != nil {
+				tt.checkResponse(t, body)
+			}
+		})
+	}
+}
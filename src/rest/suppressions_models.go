@@ -0,0 +1,23 @@
+package rest
+
+import "time"
+
+type CreateSuppressionRequest struct {
+	PhoneNumber string `json:"phone_number" validate:"required"`
+	Topic       string `json:"topic,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+type SuppressionDetail struct {
+	ID               uint      `json:"id"`
+	PhoneNumber      string    `json:"phone_number"`
+	Topic            string    `json:"topic"`
+	Reason           string    `json:"reason"`
+	InboundMessageID *string   `json:"inbound_message_id,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+type SuppressionsListResponse struct {
+	Data       []SuppressionDetail `json:"data"`
+	Pagination PaginationInfo      `json:"pagination"`
+}
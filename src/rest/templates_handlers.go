@@ -0,0 +1,208 @@
+package rest
+
+import (
+	"errors"
+	"regexp"
+	"sms-gateway-api/db"
+	"sms-gateway-api/sms"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+var templateNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,100}$`)
+
+func CreateTemplateHandler(c *fiber.Ctx) error {
+	var req CreateTemplateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ReturnBadRequest(c, "Invalid request body")
+	}
+
+	if req.Topic == "" {
+		return ReturnBadRequest(c, "Topic is required")
+	}
+
+	if !topicAllowed(c, req.Topic) {
+		return returnTopicForbidden(c, req.Topic)
+	}
+
+	if !templateNamePattern.MatchString(req.Name) {
+		return ReturnBadRequest(c, "name is required and may only contain letters, digits, '_', '.' and '-'")
+	}
+
+	if err := validateTemplateBody(req.Body); err != nil {
+		return ReturnBadRequest(c, err.Error())
+	}
+
+	locale, err := templateLocale(req.Locale)
+	if err != nil {
+		return ReturnBadRequest(c, err.Error())
+	}
+
+	template, err := db.CreateTemplate(req.Topic, req.Name, locale, req.Body)
+	if errors.Is(err, db.ErrTemplateExists) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Template already exists for this locale, use PUT to create a new version",
+		})
+	}
+	if err != nil {
+		return ReturnInternalError(c, "Failed to create template")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(toTemplateDetail(*template))
+}
+
+func ListTemplatesHandler(c *fiber.Ctx) error {
+	templates, err := db.GetTemplates(c.Query("topic"))
+	if err != nil {
+		return ReturnInternalError(c, "Failed to retrieve templates")
+	}
+
+	details := make([]TemplateDetail, 0, len(templates))
+	for _, template := range templates {
+		if topicAllowed(c, template.Topic) {
+			details = append(details, toTemplateDetail(template))
+		}
+	}
+
+	return c.JSON(details)
+}
+
+func GetTemplateHandler(c *fiber.Ctx) error {
+	version := c.QueryInt("version", 0)
+	if version < 0 {
+		return ReturnBadRequest(c, "Invalid version value")
+	}
+
+	if !topicAllowed(c, c.Params("topic")) {
+		return ReturnNotFound(c, "Template not found")
+	}
+
+	locale, err := templateLocale(c.Query("locale"))
+	if err != nil {
+		return ReturnBadRequest(c, err.Error())
+	}
+
+	versions, err := db.GetTemplateVersions(c.Params("topic"), c.Params("name"))
+	if err != nil {
+		return ReturnInternalError(c, "Failed to retrieve template")
+	}
+
+	details := make([]TemplateDetail, len(versions))
+	current := -1
+	for i, template := range versions {
+		details[i] = toTemplateDetail(template)
+		if current == -1 && template.Locale == locale && (version == 0 || template.Version == version) {
+			current = i
+		}
+	}
+
+	// Without an explicit locale, a template that has no default-locale
+	// variant is shown through its first variant instead.
+	if current == -1 && c.Query("locale") == "" && version == 0 && len(details) > 0 {
+		current = 0
+	}
+
+	if current == -1 {
+		return ReturnNotFound(c, "Template not found")
+	}
+
+	return c.JSON(TemplateVersionsResponse{
+		TemplateDetail: details[current],
+		Versions:       details,
+	})
+}
+
+func UpdateTemplateHandler(c *fiber.Ctx) error {
+	if !topicAllowed(c, c.Params("topic")) {
+		return returnTopicForbidden(c, c.Params("topic"))
+	}
+
+	var req UpdateTemplateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ReturnBadRequest(c, "Invalid request body")
+	}
+
+	if err := validateTemplateBody(req.Body); err != nil {
+		return ReturnBadRequest(c, err.Error())
+	}
+
+	locale, err := templateLocale(req.Locale)
+	if err != nil {
+		return ReturnBadRequest(c, err.Error())
+	}
+
+	template, err := db.UpdateTemplate(c.Params("topic"), c.Params("name"), locale, req.Body)
+	if err == gorm.ErrRecordNotFound {
+		return ReturnNotFound(c, "Template not found")
+	}
+	if err != nil {
+		return ReturnInternalError(c, "Failed to update template")
+	}
+
+	return c.JSON(toTemplateDetail(*template))
+}
+
+func DeleteTemplateHandler(c *fiber.Ctx) error {
+	if !topicAllowed(c, c.Params("topic")) {
+		return returnTopicForbidden(c, c.Params("topic"))
+	}
+
+	locale, err := sms.NormalizeLocale(c.Query("locale"))
+	if err != nil {
+		return ReturnBadRequest(c, "Invalid locale. Use a language tag such as pt or pt-MZ")
+	}
+
+	err = db.DeleteTemplate(c.Params("topic"), c.Params("name"), locale)
+	if err == gorm.ErrRecordNotFound {
+		return ReturnNotFound(c, "Template not found")
+	}
+	if err != nil {
+		return ReturnInternalError(c, "Failed to delete template")
+	}
+
+	return c.JSON(SuccessResponse{
+		Message: "Template deleted",
+	})
+}
+
+// templateLocale normalizes a variant locale, defaulting to DEFAULT_LOCALE.
+func templateLocale(raw string) (string, error) {
+	locale, err := sms.NormalizeLocale(raw)
+	if err != nil {
+		return "", errors.New("Invalid locale. Use a language tag such as pt or pt-MZ")
+	}
+	if locale == "" {
+		locale = sms.GetDefaultLocale()
+	}
+	return locale, nil
+}
+
+func validateTemplateBody(body string) error {
+	if body == "" {
+		return errors.New("Body is required")
+	}
+	if sms.ValidateTemplate(body) != nil {
+		return errors.New("Invalid template body. Placeholders must look like {{name}}")
+	}
+	return nil
+}
+
+func toTemplateDetail(template db.MessageTemplate) TemplateDetail {
+	variables := sms.TemplateVariables(template.Body)
+	if variables == nil {
+		variables = []string{}
+	}
+
+	return TemplateDetail{
+		ID:        template.ID,
+		Topic:     template.Topic,
+		Name:      template.Name,
+		Locale:    template.Locale,
+		Version:   template.Version,
+		Body:      template.Body,
+		Variables: variables,
+		Encoding:  sms.AnalyzeBody(template.Body).Encoding,
+		CreatedAt: template.CreatedAt,
+	}
+}
@@ -0,0 +1,547 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os"
+	"sms-gateway-api/db"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func setupTemplatesTestApp() *fiber.App {
+	app := setupTestApp()
+	app.Post("/templates", CreateTemplateHandler)
+	app.Get("/templates", ListTemplatesHandler)
+	app.Get("/templates/:topic/:name", GetTemplateHandler)
+	app.Put("/templates/:topic/:name", UpdateTemplateHandler)
+	app.Delete("/templates/:topic/:name", DeleteTemplateHandler)
+	app.Get("/reports", GetReportsHandler)
+	return app
+}
+
+func TestTemplateHandlers(t *testing.T) {
+	os.Setenv("MAX_SEGMENTS_PER_MESSAGE", "1")
+	defer os.Unsetenv("MAX_SEGMENTS_PER_MESSAGE")
+
+	setupTestDB(t)
+	defer teardownTestDB()
+
+	app := setupTemplatesTestApp()
+
+	var firstMessageID string
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		payload        interface{}
+		expectedStatus int
+		checkResponse  func(t *testing.T, body []byte)
+	}{
+		{
+			name:           "Create template",
+			method:         "POST",
+			path:           "/templates",
+			payload:        CreateTemplateRequest{Topic: "otp", Name: "login", Body: "Hi {{name}}, your code is {{ code }}"},
+			expectedStatus: fiber.StatusCreated,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response TemplateDetail
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.Version != 1 || strings.Join(response.Variables, ",") != "name,code" {
+					t.Errorf("Unexpected template: %+v", response)
+				}
+			},
+		},
+		{
+			name:           "Create existing template",
+			method:         "POST",
+			path:           "/templates",
+			payload:        CreateTemplateRequest{Topic: "otp", Name: "login", Body: "Your code is {{code}}"},
+			expectedStatus: fiber.StatusConflict,
+		},
+		{
+			name:           "Malformed placeholder",
+			method:         "POST",
+			path:           "/templates",
+			payload:        CreateTemplateRequest{Topic: "otp", Name: "reset", Body: "Your code is {{first code}}"},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Invalid name",
+			method:         "POST",
+			path:           "/templates",
+			payload:        CreateTemplateRequest{Topic: "otp", Name: "log in", Body: "Your code is {{code}}"},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Queue message from template",
+			method:         "POST",
+			path:           "/messages",
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "+258841234567", Template: "login", Variables: map[string]string{"name": "Ana", "code": "123456"}},
+			expectedStatus: fiber.StatusCreated,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response QueueSMSResponse
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				firstMessageID = response.ID
+			},
+		},
+		{
+			name:           "Missing template variables",
+			method:         "POST",
+			path:           "/messages",
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "+258841234567", Template: "login", Variables: map[string]string{"name": "Ana"}},
+			expectedStatus: fiber.StatusBadRequest,
+			checkResponse: func(t *testing.T, body []byte) {
+				if !strings.Contains(string(body), "code") {
+					t.Errorf("Expected missing variable to be named, got %s", string(body))
+				}
+			},
+		},
+		{
+			name:           "Body and template together",
+			method:         "POST",
+			path:           "/messages",
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "+258841234567", Body: "Hello", Template: "login"},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Template of another topic",
+			method:         "POST",
+			path:           "/messages",
+			payload:        QueueSMSRequest{Topic: "alerts", ToNumber: "+258841234567", Template: "login", Variables: map[string]string{"name": "Ana", "code": "1"}},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Rendered body over the segment limit",
+			method:         "POST",
+			path:           "/messages",
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "+258841234568", Template: "login", Variables: map[string]string{"name": strings.Repeat("a", 150), "code": "123456"}},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Update creates a new version",
+			method:         "PUT",
+			path:           "/templates/otp/login",
+			payload:        UpdateTemplateRequest{Body: "{{code}} is your login code"},
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response TemplateDetail
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.Version != 2 {
+					t.Errorf("Expected version 2, got %d", response.Version)
+				}
+			},
+		},
+		{
+			name:           "Queue message from latest version",
+			method:         "POST",
+			path:           "/messages",
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "+258841234569", Template: "login", Variables: map[string]string{"code": "654321"}},
+			expectedStatus: fiber.StatusCreated,
+		},
+		{
+			name:           "Message records template version",
+			method:         "GET",
+			path:           "/messages?topic=otp",
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response MessagesListResponse
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				for _, msg := range response.Data {
+					if msg.Template == nil || *msg.Template != "login" || msg.TemplateVersion == nil {
+						t.Fatalf("Expected template reference, got %+v", msg)
+					}
+					if msg.ID == firstMessageID && (*msg.TemplateVersion != 1 || msg.Body != "Hi Ana, your code is 123456") {
+						t.Errorf("Unexpected first message: %+v", msg)
+					}
+					if msg.ID != firstMessageID && (*msg.TemplateVersion != 2 || msg.Body != "654321 is your login code") {
+						t.Errorf("Unexpected second message: %+v", msg)
+					}
+				}
+			},
+		},
+		{
+			name:           "Get template with versions",
+			method:         "GET",
+			path:           "/templates/otp/login?version=1",
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response TemplateVersionsResponse
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.Version != 1 || len(response.Versions) != 2 {
+					t.Errorf("Expected version 1 of 2, got %+v", response)
+				}
+			},
+		},
+		{
+			name:           "List templates returns latest versions",
+			method:         "GET",
+			path:           "/templates?topic=otp",
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response []TemplateDetail
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if len(response) != 1 || response[0].Version != 2 {
+					t.Errorf("Expected latest version only, got %+v", response)
+				}
+			},
+		},
+		{
+			name:           "Reports filtered by template",
+			method:         "GET",
+			path:           "/reports?start_date=2020-01-01&end_date=2030-12-31&template=login",
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response ReportResponse
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.Summary.Total != 2 {
+					t.Errorf("Expected 2 templated messages, got %d", response.Summary.Total)
+				}
+			},
+		},
+		{
+			name:           "Reports filtered by unknown template",
+			method:         "GET",
+			path:           "/reports?start_date=2020-01-01&end_date=2030-12-31&template=welcome",
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response ReportResponse
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.Summary.Total != 0 {
+					t.Errorf("Expected no messages, got %d", response.Summary.Total)
+				}
+			},
+		},
+		{
+			name:           "Delete template",
+			method:         "DELETE",
+			path:           "/templates/otp/login",
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Get deleted template",
+			method:         "GET",
+			path:           "/templates/otp/login",
+			expectedStatus: fiber.StatusNotFound,
+		},
+		{
+			name:           "Update unknown template",
+			method:         "PUT",
+			path:           "/templates/otp/login",
+			payload:        UpdateTemplateRequest{Body: "Your code is {{code}}"},
+			expectedStatus: fiber.StatusNotFound,
+		},
+		{
+			name:           "Recreated template continues the version numbers",
+			method:         "POST",
+			path:           "/templates",
+			payload:        CreateTemplateRequest{Topic: "otp", Name: "login", Body: "Your code is {{code}}"},
+			expectedStatus: fiber.StatusCreated,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response TemplateDetail
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.Version != 3 {
+					t.Errorf("Expected version 3, got %d", response.Version)
+				}
+
+				var stored int64
+				db.GetDB().Unscoped().Model(&db.MessageTemplate{}).Where("topic = ? AND name = ?", "otp", "login").Count(&stored)
+				if stored != 3 {
+					t.Errorf("Expected the deleted versions to be kept, got %d rows", stored)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bodyReader io.Reader
+			if tt.payload != nil {
+				bodyBytes, err := json.Marshal(tt.payload)
+				if err != nil {
+					t.Fatalf("Failed to marshal payload: %v", err)
+				}
+				bodyReader = bytes.NewReader(bodyBytes)
+			}
+
+			req := httptest.NewRequest(tt.method, tt.path, bodyReader)
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Failed to perform request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read response body: %v", err)
+			}
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d. Response: %s", tt.expectedStatus, resp.StatusCode, string(body))
+			}
+
+			if tt.checkResponse != nil {
+				tt.checkResponse(t, body)
+			}
+		})
+	}
+}
+
+func TestTemplateHandlers_Locales(t *testing.T) {
+	os.Setenv("DEFAULT_LOCALE", "pt")
+	defer os.Unsetenv("DEFAULT_LOCALE")
+
+	setupTestDB(t)
+	defer teardownTestDB()
+
+	app := setupTemplatesTestApp()
+
+	expectMessage := func(locale, body string) func(t *testing.T, respBody []byte) {
+		return func(t *testing.T, respBody []byte) {
+			var response QueueSMSResponse
+			if err := json.Unmarshal(respBody, &response); err != nil {
+				t.Fatalf("Failed to unmarshal response: %v", err)
+			}
+			message, err := db.GetMessageByID(response.ID)
+			if err != nil || message == nil {
+				t.Fatalf("Failed to get message: %v", err)
+			}
+			if message.Locale == nil || *message.Locale != locale || message.Body != body {
+				t.Errorf("Expected %s message %q, got %v %q", locale, body, message.Locale, message.Body)
+			}
+		}
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		payload        interface{}
+		expectedStatus int
+		checkResponse  func(t *testing.T, body []byte)
+	}{
+		{
+			name:           "Create default locale variant",
+			method:         "POST",
+			path:           "/templates",
+			payload:        CreateTemplateRequest{Topic: "otp", Name: "login", Body: "O seu código é {{code}}"},
+			expectedStatus: fiber.StatusCreated,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response TemplateDetail
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.Locale != "pt" {
+					t.Errorf("Expected default locale 'pt', got '%s'", response.Locale)
+				}
+			},
+		},
+		{
+			name:           "Add English variant",
+			method:         "POST",
+			path:           "/templates",
+			payload:        CreateTemplateRequest{Topic: "otp", Name: "login", Locale: "EN", Body: "Your code is {{code}}"},
+			expectedStatus: fiber.StatusCreated,
+		},
+		{
+			name:           "Duplicate variant",
+			method:         "POST",
+			path:           "/templates",
+			payload:        CreateTemplateRequest{Topic: "otp", Name: "login", Locale: "pt", Body: "Código: {{code}}"},
+			expectedStatus: fiber.StatusConflict,
+		},
+		{
+			name:           "Invalid locale",
+			method:         "POST",
+			path:           "/templates",
+			payload:        CreateTemplateRequest{Topic: "otp", Name: "login", Locale: "english!", Body: "Your code is {{code}}"},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Regional locale falls back to its language",
+			method:         "POST",
+			path:           "/messages",
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "+258841000001", Template: "login", Locale: "en_US", Variables: map[string]string{"code": "1111"}},
+			expectedStatus: fiber.StatusCreated,
+			checkResponse:  expectMessage("en", "Your code is 1111"),
+		},
+		{
+			name:           "Unknown locale falls back to the default locale",
+			method:         "POST",
+			path:           "/messages",
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "+258841000002", Template: "login", Locale: "sw", Variables: map[string]string{"code": "2222"}},
+			expectedStatus: fiber.StatusCreated,
+			checkResponse:  expectMessage("pt", "O seu código é 2222"),
+		},
+		{
+			name:           "Plain body keeps the requested locale",
+			method:         "POST",
+			path:           "/messages",
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "+258841000003", Body: "Khanimambo", Locale: "ts"},
+			expectedStatus: fiber.StatusCreated,
+			checkResponse:  expectMessage("ts", "Khanimambo"),
+		},
+		{
+			name:           "Invalid message locale",
+			method:         "POST",
+			path:           "/messages",
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "+258841000004", Body: "Hello", Locale: "123"},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Update versions only the given variant",
+			method:         "PUT",
+			path:           "/templates/otp/login",
+			payload:        UpdateTemplateRequest{Locale: "en", Body: "{{code}} is your login code"},
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response TemplateDetail
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.Locale != "en" || response.Version != 2 {
+					t.Errorf("Expected en version 2, got %+v", response)
+				}
+			},
+		},
+		{
+			name:           "List templates returns every variant",
+			method:         "GET",
+			path:           "/templates?topic=otp",
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response []TemplateDetail
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if len(response) != 2 || response[0].Locale != "en" || response[0].Version != 2 || response[1].Version != 1 {
+					t.Errorf("Unexpected templates: %+v", response)
+				}
+			},
+		},
+		{
+			name:           "Reports by locale",
+			method:         "GET",
+			path:           "/reports?start_date=2020-01-01&end_date=2030-12-31",
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response ReportResponse
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if len(response.ByLocale) != 3 {
+					t.Fatalf("Expected 3 locales, got %+v", response.ByLocale)
+				}
+				for _, stats := range response.ByLocale {
+					if stats.Total != 1 {
+						t.Errorf("Expected 1 message for %s, got %d", stats.Locale, stats.Total)
+					}
+				}
+			},
+		},
+		{
+			name:           "Reports filtered by locale",
+			method:         "GET",
+			path:           "/reports?start_date=2020-01-01&end_date=2030-12-31&locale=EN",
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response ReportResponse
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.Summary.Total != 1 {
+					t.Errorf("Expected 1 English message, got %d", response.Summary.Total)
+				}
+			},
+		},
+		{
+			name:           "Pinned version renders the requested locale",
+			method:         "POST",
+			path:           "/messages",
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "+258841000006", Template: "login", Locale: "en", TemplateVersion: 1, Variables: map[string]string{"code": "6666"}},
+			expectedStatus: fiber.StatusCreated,
+			checkResponse:  expectMessage("en", "Your code is 6666"),
+		},
+		{
+			name:           "Pinned version does not fall back to other locales",
+			method:         "POST",
+			path:           "/messages",
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "+258841000007", Template: "login", Locale: "sw", TemplateVersion: 1, Variables: map[string]string{"code": "7777"}},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Delete one variant",
+			method:         "DELETE",
+			path:           "/templates/otp/login?locale=en",
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Deleted variant falls back to the default locale",
+			method:         "POST",
+			path:           "/messages",
+			payload:        QueueSMSRequest{Topic: "otp", ToNumber: "+258841000005", Template: "login", Locale: "en", Variables: map[string]string{"code": "5555"}},
+			expectedStatus: fiber.StatusCreated,
+			checkResponse:  expectMessage("pt", "O seu código é 5555"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bodyReader io.Reader
+			if tt.payload != nil {
+				bodyBytes, err := json.Marshal(tt.payload)
+				if err != nil {
+					t.Fatalf("Failed to marshal payload: %v", err)
+				}
+				bodyReader = bytes.NewReader(bodyBytes)
+			}
+
+			req := httptest.NewRequest(tt.method, tt.path, bodyReader)
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Failed to perform request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read response body: %v", err)
+			}
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d. Response: %s", tt.expectedStatus, resp.StatusCode, string(body))
+			}
+
+			if tt.checkResponse != nil {
+				tt.checkResponse(t, body)
+			}
+		})
+	}
+}
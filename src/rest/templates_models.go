@@ -0,0 +1,32 @@
+package rest
+
+import "time"
+
+type CreateTemplateRequest struct {
+	Topic  string `json:"topic" validate:"required"`
+	Name   string `json:"name" validate:"required"`
+	Locale string `json:"locale,omitempty"`
+	Body   string `json:"body" validate:"required"`
+}
+
+type UpdateTemplateRequest struct {
+	Locale string `json:"locale,omitempty"`
+	Body   string `json:"body" validate:"required"`
+}
+
+type TemplateDetail struct {
+	ID        uint      `json:"id"`
+	Topic     string    `json:"topic"`
+	Name      string    `json:"name"`
+	Locale    string    `json:"locale"`
+	Version   int       `json:"version"`
+	Body      string    `json:"body"`
+	Variables []string  `json:"variables"`
+	Encoding  string    `json:"encoding"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type TemplateVersionsResponse struct {
+	TemplateDetail
+	Versions []TemplateDetail `json:"versions"`
+}
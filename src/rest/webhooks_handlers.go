@@ -0,0 +1,175 @@
+package rest
+
+import (
+	"encoding/json"
+	"math"
+	"slices"
+	"sms-gateway-api/db"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+var webhookDeliveryStatuses = []string{"pending", "delivered", "dead"}
+
+func isValidWebhookURL(rawURL string) bool {
+	return db.ValidateWebhookURL(rawURL) == nil
+}
+
+func CreateWebhookHandler(c *fiber.Ctx) error {
+	if db.GetWebhookSigningSecret() == "" {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "Webhooks are disabled until WEBHOOK_SIGNING_SECRET is set",
+		})
+	}
+
+	var req CreateWebhookRequest
+	if err := c.BodyParser(&req); err != nil {
+		return ReturnBadRequest(c, "Invalid request body")
+	}
+
+	if req.Topic == "" {
+		return ReturnBadRequest(c, "Topic is required")
+	}
+
+	if !isValidWebhookURL(req.URL) {
+		return ReturnBadRequest(c, "url must be an absolute https URL on a public host")
+	}
+
+	for _, event := range req.Events {
+		if !slices.Contains(db.WebhookEvents, event) {
+			return ReturnBadRequest(c, "Invalid event. Must be one of: "+strings.Join(db.WebhookEvents, ", "))
+		}
+	}
+
+	subscription, err := db.CreateWebhookSubscription(req.Topic, req.URL, req.Events)
+	if err != nil {
+		return ReturnInternalError(c, "Failed to create webhook")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(toWebhookSubscriptionDetail(*subscription))
+}
+
+func ListWebhooksHandler(c *fiber.Ctx) error {
+	subscriptions, err := db.GetWebhookSubscriptions()
+	if err != nil {
+		return ReturnInternalError(c, "Failed to retrieve webhooks")
+	}
+
+	details := make([]WebhookSubscriptionDetail, len(subscriptions))
+	for i, subscription := range subscriptions {
+		details[i] = toWebhookSubscriptionDetail(subscription)
+	}
+
+	return c.JSON(details)
+}
+
+func DeleteWebhookHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id < 1 {
+		return ReturnBadRequest(c, "Invalid webhook id")
+	}
+
+	err = db.DeleteWebhookSubscription(uint(id))
+	if err == gorm.ErrRecordNotFound {
+		return ReturnNotFound(c, "Webhook not found")
+	}
+	if err != nil {
+		return ReturnInternalError(c, "Failed to delete webhook")
+	}
+
+	return c.JSON(SuccessResponse{
+		Message: "Webhook deleted",
+	})
+}
+
+func ListWebhookDeliveriesHandler(c *fiber.Ctx) error {
+	status := c.Query("status")
+	if status != "" && !slices.Contains(webhookDeliveryStatuses, status) {
+		return ReturnBadRequest(c, "Invalid status value. Must be one of: "+strings.Join(webhookDeliveryStatuses, ", "))
+	}
+
+	page := c.QueryInt("page", 1)
+	if page < 1 {
+		page = 1
+	}
+
+	limit := c.QueryInt("limit", 20)
+	if limit < 1 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	deliveries, total, err := db.GetWebhookDeliveries(status, limit, (page-1)*limit)
+	if err != nil {
+		return ReturnInternalError(c, "Failed to retrieve webhook deliveries")
+	}
+
+	details := make([]WebhookDeliveryDetail, len(deliveries))
+	for i, delivery := range deliveries {
+		details[i] = toWebhookDeliveryDetail(delivery)
+	}
+
+	return c.JSON(WebhookDeliveriesListResponse{
+		Data: details,
+		Pagination: PaginationInfo{
+			Page:       page,
+			Limit:      limit,
+			Total:      int(total),
+			TotalPages: int(math.Ceil(float64(total) / float64(limit))),
+		},
+	})
+}
+
+func RetryWebhookDeliveryHandler(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id < 1 {
+		return ReturnBadRequest(c, "Invalid delivery id")
+	}
+
+	delivery, err := db.RetryWebhookDelivery(uint(id))
+	if err == gorm.ErrRecordNotFound {
+		return ReturnNotFound(c, "Webhook delivery not found")
+	}
+	if err != nil {
+		return ReturnInternalError(c, "Failed to retry webhook delivery")
+	}
+
+	return c.JSON(toWebhookDeliveryDetail(*delivery))
+}
+
+func toWebhookSubscriptionDetail(subscription db.WebhookSubscription) WebhookSubscriptionDetail {
+	events := subscription.EventList()
+	if events == nil {
+		events = db.WebhookEvents
+	}
+
+	return WebhookSubscriptionDetail{
+		ID:        subscription.ID,
+		Topic:     subscription.Topic,
+		URL:       subscription.URL,
+		Events:    events,
+		CreatedAt: subscription.CreatedAt,
+	}
+}
+
+func toWebhookDeliveryDetail(delivery db.WebhookDelivery) WebhookDeliveryDetail {
+	return WebhookDeliveryDetail{
+		ID:             delivery.ID,
+		SubscriptionID: delivery.SubscriptionID,
+		MessageID:      delivery.MessageID,
+		Event:          delivery.Event,
+		URL:            delivery.URL,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		NextAttemptAt:  delivery.NextAttemptAt,
+		LastStatusCode: delivery.LastStatusCode,
+		LastError:      delivery.LastError,
+		Payload:        json.RawMessage(delivery.Payload),
+		CreatedAt:      delivery.CreatedAt,
+		DeliveredAt:    delivery.DeliveredAt,
+	}
+}
@@ -0,0 +1,435 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sms-gateway-api/db"
+	"sms-gateway-api/worker"
+	"sync"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func setupWebhooksTestApp() *fiber.App {
+	app := fiber.New()
+	app.Post("/webhooks", CreateWebhookHandler)
+	app.Get("/webhooks", ListWebhooksHandler)
+	app.Delete("/webhooks/:id", DeleteWebhookHandler)
+	app.Get("/webhooks/deliveries", ListWebhookDeliveriesHandler)
+	app.Post("/webhooks/deliveries/:id/retry", RetryWebhookDeliveryHandler)
+	return app
+}
+
+type webhookReceiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   []string
+}
+
+func newWebhookReceiver(t *testing.T, status int) (*webhookReceiver, *httptest.Server) {
+	receiver := &webhookReceiver{status: status}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		receiver.mu.Lock()
+		receiver.requests = append(receiver.requests, r)
+		receiver.bodies = append(receiver.bodies, string(body))
+		receiver.mu.Unlock()
+		w.WriteHeader(receiver.status)
+	}))
+	t.Cleanup(server.Close)
+	return receiver, server
+}
+
+func TestWebhookSubscriptionHandlers(t *testing.T) {
+	setupTestDB(t)
+	defer teardownTestDB()
+
+	t.Setenv("WEBHOOK_SIGNING_SECRET", "test-secret")
+	t.Setenv("WEBHOOK_ALLOWED_HOSTS", "localhost")
+
+	app := setupWebhooksTestApp()
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		payload        interface{}
+		expectedStatus int
+		checkResponse  func(t *testing.T, body []byte)
+	}{
+		{
+			name:   "Create webhook for topic",
+			method: "POST",
+			path:   "/webhooks",
+			payload: CreateWebhookRequest{
+				Topic:  "otp",
+				URL:    "https://example.com/hooks/sms",
+				Events: []string{"message.delivered", "message.failed"},
+			},
+			expectedStatus: fiber.StatusCreated,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response WebhookSubscriptionDetail
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if response.ID == 0 || response.Topic != "otp" || len(response.Events) != 2 {
+					t.Errorf("Unexpected webhook: %+v", response)
+				}
+			},
+		},
+		{
+			name:           "Create webhook for all topics with all events",
+			method:         "POST",
+			path:           "/webhooks",
+			payload:        CreateWebhookRequest{Topic: "*", URL: "http://localhost:9000/hooks"},
+			expectedStatus: fiber.StatusCreated,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response WebhookSubscriptionDetail
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if len(response.Events) != len(db.WebhookEvents) {
+					t.Errorf("Expected all events, got %v", response.Events)
+				}
+			},
+		},
+		{
+			name:           "Missing topic",
+			method:         "POST",
+			path:           "/webhooks",
+			payload:        CreateWebhookRequest{URL: "https://example.com/hooks/sms"},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Relative URL",
+			method:         "POST",
+			path:           "/webhooks",
+			payload:        CreateWebhookRequest{Topic: "otp", URL: "/hooks/sms"},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Plain http URL",
+			method:         "POST",
+			path:           "/webhooks",
+			payload:        CreateWebhookRequest{Topic: "otp", URL: "http://example.com/hooks/sms"},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Loopback address",
+			method:         "POST",
+			path:           "/webhooks",
+			payload:        CreateWebhookRequest{Topic: "otp", URL: "https://127.0.0.1:9000/hooks"},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Private address",
+			method:         "POST",
+			path:           "/webhooks",
+			payload:        CreateWebhookRequest{Topic: "otp", URL: "https://10.0.0.5/hooks"},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Link-local address",
+			method:         "POST",
+			path:           "/webhooks",
+			payload:        CreateWebhookRequest{Topic: "otp", URL: "https://169.254.169.254/latest/meta-data"},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Unknown event",
+			method:         "POST",
+			path:           "/webhooks",
+			payload:        CreateWebhookRequest{Topic: "otp", URL: "https://example.com/hooks/sms", Events: []string{"message.opened"}},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "List webhooks",
+			method:         "GET",
+			path:           "/webhooks",
+			expectedStatus: fiber.StatusOK,
+			checkResponse: func(t *testing.T, body []byte) {
+				var response []WebhookSubscriptionDetail
+				if err := json.Unmarshal(body, &response); err != nil {
+					t.Fatalf("Failed to unmarshal response: %v", err)
+				}
+				if len(response) != 2 {
+					t.Errorf("Expected 2 webhooks, got %d", len(response))
+				}
+			},
+		},
+		{
+			name:           "Delete webhook",
+			method:         "DELETE",
+			path:           "/webhooks/1",
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Delete unknown webhook",
+			method:         "DELETE",
+			path:           "/webhooks/1",
+			expectedStatus: fiber.StatusNotFound,
+		},
+		{
+			name:           "Invalid delivery status filter",
+			method:         "GET",
+			path:           "/webhooks/deliveries?status=lost",
+			expectedStatus: fiber.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bodyReader io.Reader
+			if tt.payload != nil {
+				bodyBytes, err := json.Marshal(tt.payload)
+				if err != nil {
+					t.Fatalf("Failed to marshal payload: %v", err)
+				}
+				bodyReader = bytes.NewReader(bodyBytes)
+			}
+
+			req := httptest.NewRequest(tt.method, tt.path, bodyReader)
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Failed to perform request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read response body: %v", err)
+			}
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d. Response: %s", tt.expectedStatus, resp.StatusCode, string(body))
+			}
+
+			if tt.checkResponse != nil {
+				tt.checkResponse(t, body)
+			}
+		})
+	}
+}
+
+func TestWebhooksRequireSigningSecret(t *testing.T) {
+	setupTestDB(t)
+	defer teardownTestDB()
+
+	t.Setenv("WEBHOOK_SIGNING_SECRET", "")
+
+	app := setupWebhooksTestApp()
+	app.Post("/messages", QueueSMSHandler)
+
+	post := func(t *testing.T, path string, payload interface{}) int {
+		bodyBytes, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatalf("Failed to marshal payload: %v", err)
+		}
+
+		req := httptest.NewRequest("POST", path, bytes.NewReader(bodyBytes))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to perform request: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	t.Run("Webhook registration is refused", func(t *testing.T) {
+		status := post(t, "/webhooks", CreateWebhookRequest{Topic: "otp", URL: "https://example.com/hooks/sms"})
+		if status != fiber.StatusServiceUnavailable {
+			t.Errorf("Expected status %d, got %d", fiber.StatusServiceUnavailable, status)
+		}
+
+		subscriptions, err := db.GetWebhookSubscriptions()
+		if err != nil {
+			t.Fatalf("Failed to get webhooks: %v", err)
+		}
+		if len(subscriptions) != 0 {
+			t.Errorf("Expected no webhook to be stored, got %d", len(subscriptions))
+		}
+	})
+
+	t.Run("Callback URL is refused", func(t *testing.T) {
+		status := post(t, "/messages", QueueSMSRequest{
+			Topic:       "otp",
+			ToNumber:    "+1234567890",
+			Body:        "Your OTP is 123456",
+			CallbackURL: "https://example.com/hooks/sms",
+		})
+		if status != fiber.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", fiber.StatusBadRequest, status)
+		}
+	})
+
+	t.Run("Dispatcher does not send unsigned webhooks", func(t *testing.T) {
+		if _, err := worker.DispatchWebhooks(context.Background(), http.DefaultClient); !errors.Is(err, db.ErrWebhookSigningDisabled) {
+			t.Errorf("Expected ErrWebhookSigningDisabled, got %v", err)
+		}
+	})
+}
+
+func TestWebhookDispatch(t *testing.T) {
+	os.Setenv("WEBHOOK_SIGNING_SECRET", "test-secret")
+	defer os.Unsetenv("WEBHOOK_SIGNING_SECRET")
+	os.Setenv("WEBHOOK_ALLOWED_HOSTS", "127.0.0.1")
+	defer os.Unsetenv("WEBHOOK_ALLOWED_HOSTS")
+	os.Setenv("WEBHOOK_MAX_ATTEMPTS", "2")
+	defer os.Unsetenv("WEBHOOK_MAX_ATTEMPTS")
+	os.Setenv("WEBHOOK_BACKOFF_SECONDS", "0")
+	defer os.Unsetenv("WEBHOOK_BACKOFF_SECONDS")
+
+	setupTestDB(t)
+	defer teardownTestDB()
+
+	app := setupWebhooksTestApp()
+	ctx := context.Background()
+	client := worker.NewWebhookClient()
+
+	receiver, server := newWebhookReceiver(t, http.StatusOK)
+	failing, failingServer := newWebhookReceiver(t, http.StatusInternalServerError)
+
+	if _, err := db.CreateWebhookSubscription("otp", server.URL, nil); err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+	if _, err := db.CreateWebhookSubscription("*", failingServer.URL, []string{"message.failed"}); err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+
+	callbackURL := server.URL + "/callback"
+	msg, err := db.CreateMessageWithParams(db.MessageParams{
+		Topic:       "otp",
+		ToNumber:    "+1234567890",
+		Body:        "Your OTP is 123456",
+		CallbackURL: &callbackURL,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test message: %v", err)
+	}
+	other, err := db.CreateMessage("alerts", "+1234567890", "Alert: Login detected")
+	if err != nil {
+		t.Fatalf("Failed to create test message: %v", err)
+	}
+
+	t.Run("Status change is delivered signed to subscription and callback", func(t *testing.T) {
+		if err := reportStatus(msg.ID, "sent", nil); err != nil {
+			t.Fatalf("Failed to update message status: %v", err)
+		}
+
+		delivered, err := worker.DispatchWebhooks(ctx, client)
+		if err != nil {
+			t.Fatalf("Failed to dispatch webhooks: %v", err)
+		}
+		if delivered != 2 || len(receiver.requests) != 2 {
+			t.Fatalf("Expected 2 webhook calls, got %d delivered and %d received", delivered, len(receiver.requests))
+		}
+
+		paths := []string{receiver.requests[0].URL.Path, receiver.requests[1].URL.Path}
+		if paths[0] != "/" || paths[1] != "/callback" {
+			t.Errorf("Expected subscription and callback calls, got %v", paths)
+		}
+
+		for i, req := range receiver.requests {
+			if req.Header.Get("X-Webhook-Event") != "message.sent" {
+				t.Errorf("Expected event 'message.sent', got '%s'", req.Header.Get("X-Webhook-Event"))
+			}
+			expected := "sha256=" + worker.SignWebhookPayload("test-secret", req.Header.Get("X-Webhook-Timestamp"), receiver.bodies[i])
+			if req.Header.Get("X-Webhook-Signature") != expected {
+				t.Errorf("Invalid signature %s", req.Header.Get("X-Webhook-Signature"))
+			}
+
+			var payload struct {
+				Event string                `json:"event"`
+				Data  db.MessageWebhookData `json:"data"`
+			}
+			if err := json.Unmarshal([]byte(receiver.bodies[i]), &payload); err != nil {
+				t.Fatalf("Failed to unmarshal payload: %v", err)
+			}
+			if payload.Data.MessageID != msg.ID || payload.Data.Status != "sent" {
+				t.Errorf("Unexpected payload: %+v", payload)
+			}
+		}
+	})
+
+	t.Run("Failing receiver is retried and dead-lettered", func(t *testing.T) {
+		if err := reportStatus(other.ID, "failed", strPtr("No signal")); err != nil {
+			t.Fatalf("Failed to update message status: %v", err)
+		}
+
+		for i := 0; i < 2; i++ {
+			if _, err := worker.DispatchWebhooks(ctx, client); err != nil {
+				t.Fatalf("Failed to dispatch webhooks: %v", err)
+			}
+		}
+		if len(failing.requests) != 2 {
+			t.Errorf("Expected 2 attempts, got %d", len(failing.requests))
+		}
+
+		req := httptest.NewRequest("GET", "/webhooks/deliveries?status=dead", nil)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to perform request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var response WebhookDeliveriesListResponse
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if len(response.Data) != 1 {
+			t.Fatalf("Expected 1 dead delivery, got %d", len(response.Data))
+		}
+		dead := response.Data[0]
+		if dead.Attempts != 2 || dead.LastStatusCode == nil || *dead.LastStatusCode != http.StatusInternalServerError {
+			t.Errorf("Unexpected dead delivery: %+v", dead)
+		}
+
+		failing.mu.Lock()
+		failing.status = http.StatusNoContent
+		failing.mu.Unlock()
+
+		req = httptest.NewRequest("POST", fmt.Sprintf("/webhooks/deliveries/%d/retry", dead.ID), nil)
+		resp, err = app.Test(req)
+		if err != nil {
+			t.Fatalf("Failed to perform request: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != fiber.StatusOK {
+			t.Fatalf("Expected status %d, got %d", fiber.StatusOK, resp.StatusCode)
+		}
+
+		delivered, err := worker.DispatchWebhooks(ctx, client)
+		if err != nil {
+			t.Fatalf("Failed to dispatch webhooks: %v", err)
+		}
+		if delivered != 1 {
+			t.Errorf("Expected retried delivery to succeed, got %d delivered", delivered)
+		}
+	})
+
+	t.Run("Private address is refused once resolved", func(t *testing.T) {
+		t.Setenv("WEBHOOK_ALLOWED_HOSTS", "")
+
+		received := len(receiver.requests)
+		_, err := worker.NewWebhookClient().Post(server.URL, "application/json", nil)
+		if !errors.Is(err, db.ErrWebhookTargetNotAllowed) {
+			t.Errorf("Expected %v, got %v", db.ErrWebhookTargetNotAllowed, err)
+		}
+		if len(receiver.requests) != received {
+			t.Error("Expected the receiver not to be called")
+		}
+	})
+}
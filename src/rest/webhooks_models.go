@@ -0,0 +1,41 @@
+package rest
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type CreateWebhookRequest struct {
+	Topic  string   `json:"topic" validate:"required"`
+	URL    string   `json:"url" validate:"required"`
+	Events []string `json:"events,omitempty"`
+}
+
+type WebhookSubscriptionDetail struct {
+	ID        uint      `json:"id"`
+	Topic     string    `json:"topic"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WebhookDeliveryDetail struct {
+	ID             uint            `json:"id"`
+	SubscriptionID *uint           `json:"subscription_id,omitempty"`
+	MessageID      *string         `json:"message_id,omitempty"`
+	Event          string          `json:"event"`
+	URL            string          `json:"url"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastStatusCode *int            `json:"last_status_code,omitempty"`
+	LastError      *string         `json:"last_error,omitempty"`
+	Payload        json.RawMessage `json:"payload"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
+
+type WebhookDeliveriesListResponse struct {
+	Data       []WebhookDeliveryDetail `json:"data"`
+	Pagination PaginationInfo          `json:"pagination"`
+}
@@ -0,0 +1,132 @@
+package sms
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	EncodingGSM7 = "gsm7"
+	EncodingUCS2 = "ucs2"
+)
+
+const (
+	gsm7SingleSegment    = 160
+	gsm7MultipartSegment = 153
+	ucs2SingleSegment    = 70
+	ucs2MultipartSegment = 67
+)
+
+const gsm7Basic = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+
+// Characters in the extension table are sent as an escape plus the
+// character, so each one takes two septets.
+const gsm7Extension = "\f^{}\\[~]|€"
+
+var transliterations = map[rune]string{
+	'‘': "'", '’': "'", '‚': "'", '‛': "'", '′': "'", '`': "'",
+	'“': "\"", '”': "\"", '„': "\"", '″': "\"", '«': "\"", '»': "\"",
+	'–': "-", '—': "-", '―': "-", '‐': "-", '−': "-",
+	'…': "...", '•': "-", ' ': " ", '\t': " ",
+	'á': "a", 'â': "a", 'ã': "a", 'Á': "A", 'À': "A", 'Â': "A", 'Ã': "A",
+	'ê': "e", 'ë': "e", 'È': "E", 'Ê': "E", 'Ë': "E",
+	'í': "i", 'î': "i", 'ï': "i", 'Í': "I", 'Ì': "I", 'Î': "I", 'Ï': "I",
+	'ó': "o", 'ô': "o", 'õ': "o", 'Ó': "O", 'Ò': "O", 'Ô': "O", 'Õ': "O",
+	'ú': "u", 'û': "u", 'Ú': "U", 'Ù': "U", 'Û': "U",
+	'ç': "c", 'ý': "y", 'ÿ': "y", 'Ý': "Y",
+}
+
+type BodyStats struct {
+	Encoding   string
+	Segments   int
+	Characters int
+}
+
+func GetMaxSegments() int {
+	value, err := strconv.Atoi(os.Getenv("MAX_SEGMENTS_PER_MESSAGE"))
+	if err != nil || value <= 0 {
+		return 10
+	}
+	return value
+}
+
+func TransliterationEnabled() bool {
+	return os.Getenv("SMS_TRANSLITERATE") == "true"
+}
+
+func AnalyzeBody(body string) BodyStats {
+	stats := BodyStats{Characters: utf8.RuneCountInString(body)}
+
+	if IsGSM7(body) {
+		stats.Encoding = EncodingGSM7
+		stats.Segments = countSegments(body, gsm7SingleSegment, gsm7MultipartSegment, gsm7Units)
+	} else {
+		stats.Encoding = EncodingUCS2
+		stats.Segments = countSegments(body, ucs2SingleSegment, ucs2MultipartSegment, utf16.RuneLen)
+	}
+
+	return stats
+}
+
+func IsGSM7(body string) bool {
+	for _, r := range body {
+		if gsm7Units(r) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Transliterate replaces common characters that force UCS-2 (smart quotes,
+// dashes, accented vowels) with GSM-7 lookalikes. Anything else is kept.
+func Transliterate(body string) string {
+	var b strings.Builder
+	for _, r := range body {
+		if gsm7Units(r) == 0 {
+			if replacement, ok := transliterations[r]; ok {
+				b.WriteString(replacement)
+				continue
+			}
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func gsm7Units(r rune) int {
+	switch {
+	case strings.ContainsRune(gsm7Basic, r):
+		return 1
+	case strings.ContainsRune(gsm7Extension, r):
+		return 2
+	default:
+		return 0
+	}
+}
+
+// A character is never split across segments, so multipart messages are
+// packed rune by rune rather than by dividing the total length.
+func countSegments(body string, single, multipart int, units func(rune) int) int {
+	total := 0
+	for _, r := range body {
+		total += units(r)
+	}
+	if total <= single {
+		return 1
+	}
+
+	segments, used := 1, 0
+	for _, r := range body {
+		n := units(r)
+		if used+n > multipart {
+			segments++
+			used = 0
+		}
+		used += n
+	}
+	return segments
+}
@@ -0,0 +1,53 @@
+package sms
+
+import (
+	"errors"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var ErrInvalidLocale = errors.New("invalid locale")
+
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+func GetDefaultLocale() string {
+	locale, err := NormalizeLocale(os.Getenv("DEFAULT_LOCALE"))
+	if err != nil || locale == "" {
+		return "en"
+	}
+	return locale
+}
+
+// NormalizeLocale lowercases a BCP 47 style tag ("pt_MZ" becomes "pt-mz").
+// An empty locale is returned as is.
+func NormalizeLocale(raw string) (string, error) {
+	locale := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(raw), "_", "-"))
+	if locale == "" {
+		return "", nil
+	}
+	if !localePattern.MatchString(locale) {
+		return "", ErrInvalidLocale
+	}
+	return locale, nil
+}
+
+// LocaleFallbacks lists the locales to try for a normalized locale, from
+// most to least specific, ending with the default locale.
+func LocaleFallbacks(locale string) []string {
+	var fallbacks []string
+	for locale != "" {
+		fallbacks = append(fallbacks, locale)
+		i := strings.LastIndex(locale, "-")
+		if i < 0 {
+			break
+		}
+		locale = locale[:i]
+	}
+
+	if defaultLocale := GetDefaultLocale(); !slices.Contains(fallbacks, defaultLocale) {
+		fallbacks = append(fallbacks, defaultLocale)
+	}
+	return fallbacks
+}
@@ -0,0 +1,43 @@
+package sms
+
+import (
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var ErrInvalidPhoneNumber = errors.New("invalid phone number")
+
+var e164Pattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+var phoneSeparators = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "", "/", "")
+
+func GetDefaultCountryCode() string {
+	return strings.TrimPrefix(strings.TrimSpace(os.Getenv("DEFAULT_COUNTRY_CODE")), "+")
+}
+
+func NormalizePhoneNumber(raw string) (string, error) {
+	number := phoneSeparators.Replace(strings.TrimSpace(raw))
+	countryCode := GetDefaultCountryCode()
+
+	switch {
+	case strings.HasPrefix(number, "+"):
+	case strings.HasPrefix(number, "00"):
+		number = "+" + number[2:]
+	case countryCode == "":
+		number = "+" + number
+	case strings.HasPrefix(number, "0"):
+		number = "+" + countryCode + number[1:]
+	case strings.HasPrefix(number, countryCode) && len(number) > len(countryCode)+8:
+		number = "+" + number
+	default:
+		number = "+" + countryCode + number
+	}
+
+	if !e164Pattern.MatchString(number) {
+		return "", ErrInvalidPhoneNumber
+	}
+
+	return number, nil
+}
@@ -0,0 +1,51 @@
+package sms
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var ErrInvalidTemplate = errors.New("invalid template")
+
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// ValidateTemplate rejects bodies with braces that are not part of a
+// well-formed {{placeholder}}, such as "{{first name}}" or a missing "}}".
+func ValidateTemplate(body string) error {
+	rest := placeholderPattern.ReplaceAllString(body, "")
+	if strings.Contains(rest, "{{") || strings.Contains(rest, "}}") {
+		return ErrInvalidTemplate
+	}
+	return nil
+}
+
+func TemplateVariables(body string) []string {
+	var variables []string
+	for _, match := range placeholderPattern.FindAllStringSubmatch(body, -1) {
+		if !slices.Contains(variables, match[1]) {
+			variables = append(variables, match[1])
+		}
+	}
+	return variables
+}
+
+// RenderTemplate substitutes every placeholder and returns the names of any
+// variables that were not provided. Extra variables are ignored.
+func RenderTemplate(body string, variables map[string]string) (string, []string) {
+	var missing []string
+	for _, name := range TemplateVariables(body) {
+		if _, ok := variables[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", missing
+	}
+
+	rendered := placeholderPattern.ReplaceAllStringFunc(body, func(placeholder string) string {
+		return variables[placeholderPattern.FindStringSubmatch(placeholder)[1]]
+	})
+	return rendered, nil
+}
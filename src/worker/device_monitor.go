@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"os"
+	"sms-gateway-api/db"
+	"strconv"
+	"time"
+)
+
+func getDeviceMonitorInterval() time.Duration {
+	secondsStr := os.Getenv("DEVICE_MONITOR_INTERVAL_SECONDS")
+	if secondsStr == "" {
+		return 30 * time.Second
+	}
+
+	seconds, err := strconv.Atoi(secondsStr)
+	if err != nil || seconds <= 0 {
+		return 30 * time.Second
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+func RunDeviceMonitor(ctx context.Context) {
+	ticker := time.NewTicker(getDeviceMonitorInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			devices, err := db.MarkOfflineDevices()
+			if err != nil {
+				log.Printf("Warning: Failed to check for offline devices: %v", err)
+			}
+			for _, device := range devices {
+				log.Printf("Device %d went offline, last poll at %s", device.ID, device.LastPollAt.Format(time.RFC3339))
+			}
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"os"
+	"sms-gateway-api/db"
+	"strconv"
+	"time"
+)
+
+func getExpirySweepInterval() time.Duration {
+	secondsStr := os.Getenv("EXPIRY_SWEEP_INTERVAL_SECONDS")
+	if secondsStr == "" {
+		return 30 * time.Second
+	}
+
+	seconds, err := strconv.Atoi(secondsStr)
+	if err != nil || seconds <= 0 {
+		return 30 * time.Second
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+func RunExpirySweeper(ctx context.Context) {
+	ticker := time.NewTicker(getExpirySweepInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expired, err := db.ExpireMessages()
+			if err != nil {
+				log.Printf("Warning: Failed to expire messages: %v", err)
+				continue
+			}
+			if expired > 0 {
+				log.Printf("Expired %d messages", expired)
+			}
+		}
+	}
+}
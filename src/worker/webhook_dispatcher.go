@@ -0,0 +1,186 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sms-gateway-api/db"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const webhookBatchSize = 50
+
+func getWebhookDispatchInterval() time.Duration {
+	secondsStr := os.Getenv("WEBHOOK_DISPATCH_INTERVAL_SECONDS")
+	if secondsStr == "" {
+		return 5 * time.Second
+	}
+
+	seconds, err := strconv.Atoi(secondsStr)
+	if err != nil || seconds <= 0 {
+		return 5 * time.Second
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+func getWebhookTimeout() time.Duration {
+	secondsStr := os.Getenv("WEBHOOK_TIMEOUT_SECONDS")
+	if secondsStr == "" {
+		return 10 * time.Second
+	}
+
+	seconds, err := strconv.Atoi(secondsStr)
+	if err != nil || seconds <= 0 {
+		return 10 * time.Second
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+func RunWebhookDispatcher(ctx context.Context) {
+	if db.GetWebhookSigningSecret() == "" {
+		log.Printf("Warning: Webhook dispatcher not started: %v", db.ErrWebhookSigningDisabled)
+		return
+	}
+
+	client := NewWebhookClient()
+
+	ticker := time.NewTicker(getWebhookDispatchInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := DispatchWebhooks(ctx, client); err != nil {
+				log.Printf("Warning: Failed to dispatch webhooks: %v", err)
+			}
+		}
+	}
+}
+
+// NewWebhookClient returns the client webhooks are sent with. Host names are
+// only resolved when connecting, so the address is checked again at that point;
+// redirects are not followed for the same reason.
+func NewWebhookClient() *http.Client {
+	direct := &net.Dialer{Timeout: getWebhookTimeout()}
+	checked := &net.Dialer{Timeout: getWebhookTimeout(), Control: checkWebhookAddress}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would connect to the receiver on our behalf, past the check.
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if host, _, err := net.SplitHostPort(addr); err == nil && db.IsWebhookHostAllowed(host) {
+			return direct.DialContext(ctx, network, addr)
+		}
+		return checked.DialContext(ctx, network, addr)
+	}
+
+	return &http.Client{
+		Timeout:   getWebhookTimeout(),
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func checkWebhookAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !db.IsPublicWebhookIP(ip) {
+		return fmt.Errorf("%w: %s is not a public address", db.ErrWebhookTargetNotAllowed, host)
+	}
+	return nil
+}
+
+func DispatchWebhooks(ctx context.Context, client *http.Client) (int, error) {
+	secret := db.GetWebhookSigningSecret()
+	if secret == "" {
+		return 0, db.ErrWebhookSigningDisabled
+	}
+
+	// Claimed deliveries are hidden from other dispatchers for a little longer
+	// than a request can take, so a crash mid-send only delays the retry.
+	deliveries, err := db.ClaimDueWebhookDeliveries(webhookBatchSize, 2*getWebhookTimeout())
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, delivery := range deliveries {
+		statusCode, err := sendWebhook(ctx, client, secret, delivery)
+		if err == nil {
+			if err := db.MarkWebhookDelivered(delivery.ID, statusCode); err != nil {
+				log.Printf("Warning: Failed to mark webhook %d delivered: %v", delivery.ID, err)
+			}
+			delivered++
+			continue
+		}
+
+		var code *int
+		if statusCode != 0 {
+			code = &statusCode
+		}
+		if err := db.MarkWebhookFailed(delivery.ID, code, err.Error()); err != nil {
+			log.Printf("Warning: Failed to mark webhook %d failed: %v", delivery.ID, err)
+		}
+	}
+
+	return delivered, nil
+}
+
+func sendWebhook(ctx context.Context, client *http.Client, secret string, delivery db.WebhookDelivery) (int, error) {
+	// The URL was checked when it was registered, but WEBHOOK_ALLOWED_HOSTS
+	// may have changed since.
+	if err := db.ValidateWebhookURL(delivery.URL); err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("invalid webhook request: %w", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "sms-gateway-api-webhooks")
+	req.Header.Set("X-Webhook-Id", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+SignWebhookPayload(secret, timestamp, delivery.Payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("receiver responded with status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+func SignWebhookPayload(secret, timestamp, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}